@@ -10,13 +10,13 @@ const MaxCodeSize = 24576
 
 // Account represents an account in Figaro
 type Account struct {
-	Address     Address
-	Nonce       uint64
-	Bonded      bool
-	Stake       uint64
-	Balance     uint64
-	StorageRoot Root
-	Code        []byte
+	Address     Address `json:"address"`
+	Nonce       uint64  `json:"nonce"`
+	Bonded      bool    `json:"bonded"`
+	Stake       uint64  `json:"stake"`
+	Balance     uint64  `json:"balance"`
+	StorageRoot Root    `json:"storageRoot"`
+	Code        []byte  `json:"code"`
 }
 
 // Encode deterministically encodes an account to binary format.
@@ -68,4 +68,5 @@ type AccountDataService interface {
 	AccountLDataService
 	SaveAccount(root Root, account *Account) (Root, error)
 	SaveAccountStorage(root Root, account *Account, key, data []byte) (Root, error)
+	SetAccountStorage(account *Account, key, data []byte) error
 }
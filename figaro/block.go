@@ -11,7 +11,6 @@ import (
 	"github.com/figaro-tech/go-fig-buf"
 	"github.com/figaro-tech/go-fig-crypto/hasher"
 	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
-	"github.com/figaro-tech/go-fig-crypto/trie"
 	"github.com/figaro-tech/go-fig-db/bloom"
 )
 
@@ -36,17 +35,19 @@ var (
 
 // BlockHeader is the header for a block.
 type BlockHeader struct {
-	ID               []byte
-	Signature        []byte
-	Producer         Address
-	Beneficiary      Address
-	Number           uint64
-	Timestamp        time.Time
-	ParentBlock      BlockHash
-	StateRoot        Root
-	CommitsRoot      Root
-	TransactionsRoot Root
-	ReceiptsRoot     Root
+	ID               []byte    `json:"id"`
+	Signature        []byte    `json:"signature"`
+	Producer         Address   `json:"producer"`
+	Beneficiary      Address   `json:"beneficiary"`
+	Number           uint64    `json:"number"`
+	Timestamp        time.Time `json:"timestamp"`
+	ParentBlock      BlockHash `json:"parentBlock"`
+	StateRoot        Root      `json:"stateRoot"`
+	CommitsRoot      Root      `json:"commitsRoot"`
+	TransactionsRoot Root      `json:"transactionsRoot"`
+	ReceiptsRoot     Root      `json:"receiptsRoot"`
+	EvidenceRoot     Root      `json:"evidenceRoot"`
+	GasUsed          uint64    `json:"gasUsed"`
 
 	ChainConfig
 }
@@ -71,6 +72,8 @@ func (bl *BlockHeader) ToHash() (BlockHash, error) {
 		bl.CommitsRoot,
 		bl.TransactionsRoot,
 		bl.ReceiptsRoot,
+		bl.EvidenceRoot,
+		bl.GasUsed,
 		cfg,
 	)
 	if err != nil {
@@ -109,6 +112,8 @@ func (bl BlockHeader) Encode() ([]byte, error) {
 		buf = enc.EncodeNextBytes(buf, bl.CommitsRoot)
 		buf = enc.EncodeNextBytes(buf, bl.TransactionsRoot)
 		buf = enc.EncodeNextBytes(buf, bl.ReceiptsRoot)
+		buf = enc.EncodeNextBytes(buf, bl.EvidenceRoot)
+		buf = enc.EncodeNextUint64(buf, bl.GasUsed)
 		cfg, err := bl.ChainConfig.Encode()
 		if err != nil {
 			panic(err)
@@ -123,7 +128,8 @@ func (bl *BlockHeader) Decode(buf []byte) error {
 	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
 	defer figbuf.DecoderPool.Put(dec)
 
-	return dec.DecodeList(buf, func(r []byte) []byte {
+	var err error
+	derr := dec.DecodeList(buf, func(r []byte) []byte {
 		bl.Signature, r = dec.DecodeNextBytes(r)
 		bl.Producer, r = dec.DecodeNextBytes(r)
 		bl.Beneficiary, r = dec.DecodeNextBytes(r)
@@ -134,14 +140,20 @@ func (bl *BlockHeader) Decode(buf []byte) error {
 		bl.CommitsRoot, r = dec.DecodeNextBytes(r)
 		bl.TransactionsRoot, r = dec.DecodeNextBytes(r)
 		bl.ReceiptsRoot, r = dec.DecodeNextBytes(r)
+		bl.EvidenceRoot, r = dec.DecodeNextBytes(r)
+		bl.GasUsed, r = dec.DecodeNextUint64(r)
 		var cfg []byte
 		cfg, r = dec.DecodeNextBytes(r)
-		err := bl.ChainConfig.Decode(cfg)
+		err = bl.ChainConfig.Decode(cfg)
 		if err != nil {
-			panic(err)
+			return nil
 		}
 		return r
 	})
+	if derr != nil {
+		return derr
+	}
+	return err
 }
 
 // Block is a collection of ordered transactions that determine world state.
@@ -151,6 +163,7 @@ type Block struct {
 	Commits      []Commit
 	TxBloom      []byte
 	Transactions []*Transaction
+	Evidence     []*Evidence
 
 	// local data
 	cbloom   *bloom.Bloom
@@ -168,6 +181,26 @@ func (bl *Block) AddCommit(commit Commit) (int, error) {
 	return len(bl.Commits), nil
 }
 
+// ValidContents returns whether the commits and transactions of the block follow the rules that a
+// FraudProof can prove broken: at most MaxCommitSize commits, each a TxHash, and at most MaxTxSize
+// transactions, each committed in an earlier block.
+func (bl *Block) ValidContents() bool {
+	if len(bl.Commits) > MaxCommitSize || len(bl.Transactions) > MaxTxSize {
+		return false
+	}
+	for _, c := range bl.Commits {
+		if !TxHash(c).Valid() {
+			return false
+		}
+	}
+	for _, tx := range bl.Transactions {
+		if tx.CommitBlock >= bl.Number {
+			return false
+		}
+	}
+	return true
+}
+
 // HasCommit returns whether the txhash has a commit in the block.
 func (bl *Block) HasCommit(txhash TxHash) bool {
 	if !bl.cbloom.Has(txhash) {
@@ -182,16 +215,34 @@ func (bl *Block) HasCommit(txhash TxHash) bool {
 }
 
 // AddTx adds a transaction to the block, returning the total transactions
-// after the tx was added. Total transactions cannot exceed `math.MaxUint16`.
+// after the tx was added. Total transactions cannot exceed `math.MaxUint16`,
+// and the GasUsed of the receipt is added to the block GasUsed.
 func (bl *Block) AddTx(tx *Transaction, receipt *Receipt) (int, error) {
 	if len(bl.Transactions) == math.MaxUint16 {
 		return 0, ErrExceedsBlockLimit
 	}
 	bl.Transactions = append(bl.Transactions, tx)
 	bl.receipts = append(bl.receipts, receipt)
+	bl.GasUsed += receipt.GasUsed
 	return len(bl.Transactions), nil
 }
 
+// FitsGas returns whether the block has room for the whole GasLimit of tx
+// within the block GasLimit.
+func (bl *Block) FitsGas(tx *Transaction) bool {
+	return tx.GasLimit <= bl.GasLimit && bl.GasUsed <= bl.GasLimit-tx.GasLimit
+}
+
+// AddEvidence adds evidence of a producer offence to the block, returning the total evidence
+// after the evidence was added. Total evidence cannot exceed `MaxEvidenceSize`.
+func (bl *Block) AddEvidence(ev *Evidence) (int, error) {
+	if len(bl.Evidence) == MaxEvidenceSize {
+		return 0, ErrExceedsBlockLimit
+	}
+	bl.Evidence = append(bl.Evidence, ev)
+	return len(bl.Evidence), nil
+}
+
 // HasTx returns whether the txhash has a transaction in the block.
 func (bl *Block) HasTx(txhash TxHash) bool {
 	if !bl.txbloom.Has(txhash) {
@@ -240,20 +291,17 @@ func (bl *Block) Seal(db BlockContentsDataService) error {
 	if err != nil {
 		return err
 	}
-	var br []byte
-	binreceipts := make([][]byte, len(bl.receipts))
-	for i, r := range bl.receipts {
+	for _, r := range bl.receipts {
 		err = db.SaveReceipt(*r)
 		if err != nil {
 			return err
 		}
-		br, err = r.Encode()
-		if err != nil {
-			return err
-		}
-		binreceipts[i] = br
 	}
-	bl.ReceiptsRoot = trie.Trie(binreceipts)
+	bl.ReceiptsRoot, err = db.ArchiveReceipts(bl.receipts)
+	if err != nil {
+		return err
+	}
+	bl.EvidenceRoot, err = db.ArchiveEvidence(bl.Evidence)
 	if err != nil {
 		return err
 	}
@@ -295,22 +343,36 @@ func (bl Block) Encode() ([]byte, error) {
 			}
 			return buf
 		})
+		buf = enc.EncodeNextList(buf, func(buf []byte) []byte {
+			for _, ev := range bl.Evidence {
+				e, err := ev.Encode()
+				if err != nil {
+					panic(err)
+				}
+				buf = enc.EncodeNextBytes(buf, e)
+			}
+			return buf
+		})
 		return buf
 	})
 }
 
 // Decode decodes a deterministically encoded Block from binary format.
-// This is used for communication between nodes.
+// This is used for communication between nodes, so bad input returns an error.
 func (bl *Block) Decode(buf []byte) error {
 	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
 	defer figbuf.DecoderPool.Put(dec)
 
-	return dec.DecodeList(buf, func(r []byte) []byte {
+	var err error
+	derr := dec.DecodeList(buf, func(r []byte) []byte {
 		var head []byte
 		head, r = dec.DecodeNextBytes(r)
-		err := bl.BlockHeader.Decode(head)
+		if bl.BlockHeader == nil {
+			bl.BlockHeader = &BlockHeader{}
+		}
+		err = bl.BlockHeader.Decode(head)
 		if err != nil {
-			panic(err)
+			return nil
 		}
 		bl.CommitsBloom, r = dec.DecodeNextBytes(r)
 		bl.TxBloom, r = dec.DecodeNextBytes(r)
@@ -325,26 +387,41 @@ func (bl *Block) Decode(buf []byte) error {
 		r = dec.DecodeNextList(r, func(r []byte) []byte {
 			var e []byte
 
-			for len(r) > 0 {
-				var t *Transaction
+			for len(r) > 0 && err == nil {
+				t := &Transaction{}
 				e, r = dec.DecodeNextBytes(r)
-				err := t.Decode(e)
-				if err != nil {
-					panic(err)
-				}
+				err = t.Decode(e)
 				bl.Transactions = append(bl.Transactions, t)
 			}
 			return r
 		})
+		if err != nil {
+			return nil
+		}
+		r = dec.DecodeNextList(r, func(r []byte) []byte {
+			var e []byte
+			for len(r) > 0 && err == nil {
+				ev := &Evidence{}
+				e, r = dec.DecodeNextBytes(r)
+				err = ev.Decode(e)
+				bl.Evidence = append(bl.Evidence, ev)
+			}
+			return r
+		})
 		return r
 	})
+	if derr != nil {
+		return derr
+	}
+	return err
 }
 
-// BlockContentsDataService is a data service that can support commits, transactions, and receipts.
+// BlockContentsDataService is a data service that can support commits, transactions, receipts, and evidence.
 type BlockContentsDataService interface {
 	CommitDataService
 	TransactionDataService
 	ReceiptDataService
+	EvidenceDataService
 }
 
 // BlockDataService should save blocks directly into a key/value store.
@@ -368,8 +445,21 @@ func NewBlockHeap() *BlockHeap {
 type BlockHeap []*BlockHeader
 
 // PeekNextNumber returns the next block index on the heap without modifying the heap.
+// It returns 0 if the heap is empty.
 func (h BlockHeap) PeekNextNumber() uint64 {
-	return h[len(h)-1].Number
+	if len(h) == 0 {
+		return 0
+	}
+	return h[0].Number
+}
+
+// Peek returns the next block on the heap without modifying the heap.
+// It returns nil if the heap is empty.
+func (h BlockHeap) Peek() *BlockHeader {
+	if len(h) == 0 {
+		return nil
+	}
+	return h[0]
 }
 
 func (h BlockHeap) Len() int           { return len(h) }
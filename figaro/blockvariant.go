@@ -1,19 +1,29 @@
 // Package figaro is the main package for go-figaro
 package figaro
 
+import (
+	"bytes"
+
+	"github.com/figaro-tech/go-fig-buf"
+	"github.com/figaro-tech/go-fig-db/bloom"
+)
+
 // A RefBlock is a Block where Transactions is replacedwith TxIDs.
 // Useful for requesting only missing Transactions.
 type RefBlock struct {
 	*BlockHeader
-	Commits []Commit
-	TxIDs   []TxHash
+	Commits  []Commit
+	TxIDs    []TxHash
+	Evidence []*Evidence
 }
 
 // Ref converts a Block into a RefBlock.
 // The Block should already be sealed and signed before calling Ref.
 func (bl Block) Ref() (rf *RefBlock) {
+	rf = &RefBlock{}
 	rf.BlockHeader = bl.BlockHeader
 	rf.Commits = bl.Commits
+	rf.Evidence = bl.Evidence
 	rf.TxIDs = make([]TxHash, len(bl.Transactions))
 	for i, t := range bl.Transactions {
 		rf.TxIDs[i] = t.ID
@@ -21,6 +31,114 @@ func (bl Block) Ref() (rf *RefBlock) {
 	return
 }
 
+// Fill converts a RefBlock back into a Block, with the transactions for its TxIDs, in order.
+// Blooms are rebuilt, since they are not part of a RefBlock.
+func (rf RefBlock) Fill(txs []*Transaction) (*Block, error) {
+	if len(txs) != len(rf.TxIDs) {
+		return nil, ErrInvalidBlock
+	}
+	for i, t := range txs {
+		if t == nil || !bytes.Equal(t.ID, rf.TxIDs[i]) {
+			return nil, ErrInvalidBlock
+		}
+	}
+	bl := &Block{BlockHeader: rf.BlockHeader, Commits: rf.Commits, Transactions: txs, Evidence: rf.Evidence}
+	err := bl.SetBlooms()
+	if err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+// Encode deterministically encodes a RefBlock to binary format.
+// This is used for communication between nodes.
+func (rf RefBlock) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	return enc.EncodeList(func(buf []byte) []byte {
+		head, err := rf.BlockHeader.Encode()
+		if err != nil {
+			panic(err)
+		}
+		buf = enc.EncodeNextBytes(buf, head)
+		buf = enc.EncodeNextList(buf, func(buf []byte) []byte {
+			for _, c := range rf.Commits {
+				buf = enc.EncodeNextBytes(buf, c)
+			}
+			return buf
+		})
+		buf = enc.EncodeNextList(buf, func(buf []byte) []byte {
+			for _, id := range rf.TxIDs {
+				buf = enc.EncodeNextBytes(buf, id)
+			}
+			return buf
+		})
+		buf = enc.EncodeNextList(buf, func(buf []byte) []byte {
+			for _, ev := range rf.Evidence {
+				e, err := ev.Encode()
+				if err != nil {
+					panic(err)
+				}
+				buf = enc.EncodeNextBytes(buf, e)
+			}
+			return buf
+		})
+		return buf
+	})
+}
+
+// Decode decodes a deterministically encoded RefBlock from binary format.
+// This is used for communication between nodes, so bad input returns an error.
+func (rf *RefBlock) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	var err error
+	derr := dec.DecodeList(buf, func(r []byte) []byte {
+		var head []byte
+		head, r = dec.DecodeNextBytes(r)
+		if rf.BlockHeader == nil {
+			rf.BlockHeader = &BlockHeader{}
+		}
+		err = rf.BlockHeader.Decode(head)
+		if err != nil {
+			return nil
+		}
+		r = dec.DecodeNextList(r, func(r []byte) []byte {
+			var c []byte
+			for len(r) > 0 {
+				c, r = dec.DecodeNextBytes(r)
+				rf.Commits = append(rf.Commits, c)
+			}
+			return r
+		})
+		r = dec.DecodeNextList(r, func(r []byte) []byte {
+			var id []byte
+			for len(r) > 0 {
+				id, r = dec.DecodeNextBytes(r)
+				rf.TxIDs = append(rf.TxIDs, id)
+			}
+			return r
+		})
+		r = dec.DecodeNextList(r, func(r []byte) []byte {
+			var e []byte
+			for len(r) > 0 && err == nil {
+				ev := &Evidence{}
+				e, r = dec.DecodeNextBytes(r)
+				err = ev.Decode(e)
+				rf.Evidence = append(rf.Evidence, ev)
+			}
+			return r
+		})
+		return r
+	})
+	if derr != nil {
+		return derr
+	}
+	return err
+}
+
 // A CompBlock is a Block with only CommitsBloom and TxBloom.
 // Useful for checking inclusion of transactions in light-clients.
 type CompBlock struct {
@@ -29,11 +147,69 @@ type CompBlock struct {
 	TxBloom      []byte
 }
 
+// MayHaveTx returns whether txhash may have a transaction in the block, according to the TxBloom.
+// There are no false negatives, but false positives must be ruled out with a proof of inclusion.
+// The TxBloom is not covered by the block ID, so a bloom from an untrusted source is only a hint.
+func (cb *CompBlock) MayHaveTx(txhash TxHash) (bool, error) {
+	b := &bloom.Bloom{}
+	err := b.Unmarshal(cb.TxBloom)
+	if err != nil {
+		return false, err
+	}
+	return b.Has(txhash), nil
+}
+
 // Compress converts a Block into a CompBlock.
 // The Block should already be sealed and signed before calling Compress.
 func (bl Block) Compress() (cb *CompBlock) {
+	cb = &CompBlock{}
 	cb.BlockHeader = bl.BlockHeader
 	cb.CommitsBloom = bl.CommitsBloom
 	cb.TxBloom = bl.TxBloom
 	return
 }
+
+// Encode deterministically encodes a CompBlock to binary format.
+// This is used for communication between nodes.
+func (cb CompBlock) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	return enc.EncodeList(func(buf []byte) []byte {
+		head, err := cb.BlockHeader.Encode()
+		if err != nil {
+			panic(err)
+		}
+		buf = enc.EncodeNextBytes(buf, head)
+		buf = enc.EncodeNextBytes(buf, cb.CommitsBloom)
+		buf = enc.EncodeNextBytes(buf, cb.TxBloom)
+		return buf
+	})
+}
+
+// Decode decodes a deterministically encoded CompBlock from binary format.
+// This is used for communication between nodes, so bad input returns an error.
+func (cb *CompBlock) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	var err error
+	derr := dec.DecodeList(buf, func(r []byte) []byte {
+		var head []byte
+		head, r = dec.DecodeNextBytes(r)
+		if cb.BlockHeader == nil {
+			cb.BlockHeader = &BlockHeader{}
+		}
+		err = cb.BlockHeader.Decode(head)
+		if err != nil {
+			return nil
+		}
+		cb.CommitsBloom, r = dec.DecodeNextBytes(r)
+		cb.TxBloom, r = dec.DecodeNextBytes(r)
+		return r
+	})
+	if derr != nil {
+		return derr
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package figaro
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRefBlockFill(t *testing.T) {
+	txs := []*Transaction{signedTx(t, 1, 1), signedTx(t, 1, 2), signedTx(t, 1, 3)}
+	bl := &Block{BlockHeader: signedHeader(t, 2, nil), Commits: []Commit{Commit(txs[0].ID)}, Transactions: txs}
+	err := bl.SetBlooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bl.Ref().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf := &RefBlock{}
+	err = rf.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filled, err := rf.Fill(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := bl.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := filled.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("filled block does not match the original")
+	}
+	if !filled.HasCommit(txs[0].ID) {
+		t.Error("filled block blooms not rebuilt")
+	}
+
+	tests := []struct {
+		name string
+		txs  []*Transaction
+	}{
+		{"wrong tx", []*Transaction{txs[0], signedTx(t, 1, 2), txs[2]}},
+		{"wrong order", []*Transaction{txs[1], txs[0], txs[2]}},
+		{"missing tx", []*Transaction{txs[0], nil, txs[2]}},
+		{"too few", txs[:2]},
+		{"too many", append(append([]*Transaction(nil), txs...), signedTx(t, 1, 4))},
+	}
+	for _, tt := range tests {
+		if _, err := rf.Fill(tt.txs); err != ErrInvalidBlock {
+			t.Errorf("%s: Fill() = %v, want %v", tt.name, err, ErrInvalidBlock)
+		}
+	}
+}
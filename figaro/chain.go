@@ -2,6 +2,7 @@
 package figaro
 
 import (
+	"bytes"
 	"errors"
 
 	"github.com/figaro-tech/go-fig-buf"
@@ -12,15 +13,23 @@ var (
 	ErrInvalidBlock = errors.New("figaro chain: invalid block for chain")
 	// ErrReorgRequired is a self-explantory error
 	ErrReorgRequired = errors.New("figaro chain: chain reorg required")
+	// ErrUnknownAncestor is returned when a fork cannot be traced back to the canonical chain.
+	ErrUnknownAncestor = errors.New("figaro chain: unknown fork ancestor")
+	// ErrForkRejected is returned by a ConsensusEngine that keeps the current chain over a
+	// competing fork, such as one that is not longer. The fork blocks may still be valid.
+	ErrForkRejected = errors.New("figaro chain: fork rejected in favor of the current chain")
 )
 
 // ChainConfig represents the current config for the chain. It will be saved in each
-// block header for future reference.
+// block header for future reference. Transactions pay the flat CommitFee for their commit,
+// and pay for the gas they use at their own GasPrice, which must be at least MinGasPrice.
+// GasLimit caps the total gas of the transactions in a block.
 type ChainConfig struct {
-	Stake      uint64
-	CommitFee  uint32
-	TxFee      uint32
-	WaitBlocks uint8
+	Stake       uint64 `json:"stake"`
+	CommitFee   uint32 `json:"commitFee"`
+	MinGasPrice uint64 `json:"minGasPrice"`
+	GasLimit    uint64 `json:"gasLimit"`
+	WaitBlocks  uint8  `json:"waitBlocks"`
 }
 
 // Encode deterministically encodes a Chain to binary format.
@@ -31,7 +40,8 @@ func (cc ChainConfig) Encode() ([]byte, error) {
 	return enc.EncodeList(func(buf []byte) []byte {
 		buf = enc.EncodeNextUint64(buf, cc.Stake)
 		buf = enc.EncodeNextUint32(buf, cc.CommitFee)
-		buf = enc.EncodeNextUint32(buf, cc.TxFee)
+		buf = enc.EncodeNextUint64(buf, cc.MinGasPrice)
+		buf = enc.EncodeNextUint64(buf, cc.GasLimit)
 		buf = enc.EncodeNextUint8(buf, cc.WaitBlocks)
 		return buf
 	})
@@ -45,7 +55,8 @@ func (cc *ChainConfig) Decode(buf []byte) error {
 	return dec.DecodeList(buf, func(r []byte) []byte {
 		cc.Stake, r = dec.DecodeNextUint64(r)
 		cc.CommitFee, r = dec.DecodeNextUint32(r)
-		cc.TxFee, r = dec.DecodeNextUint32(r)
+		cc.MinGasPrice, r = dec.DecodeNextUint64(r)
+		cc.GasLimit, r = dec.DecodeNextUint64(r)
 		cc.WaitBlocks, r = dec.DecodeNextUint8(r)
 		return r
 	})
@@ -55,8 +66,8 @@ func (cc *ChainConfig) Decode(buf []byte) error {
 // to the previous block in the chain via cryptographically secure IDs.
 // There can be only one canononical chain.
 type Chain struct {
-	Depth uint64
-	Head  BlockHash
+	Depth uint64    `json:"depth"`
+	Head  BlockHash `json:"head"`
 	ChainConfig
 }
 
@@ -85,6 +96,40 @@ func (chain *Chain) AppendBlock(db ChainDataService, bl *BlockHeader) error {
 	return db.SaveChain(chain)
 }
 
+// ForkBranch walks back from forkblock by ParentBlock until it reaches a block in the canonical chain,
+// returning the branch ordered from the first block after the common ancestor up to forkblock.
+func (chain *Chain) ForkBranch(db FullDataService, forkblock *BlockHeader) ([]*BlockHeader, error) {
+	branch := []*BlockHeader{forkblock}
+	header := forkblock
+	for {
+		if header.Number == 0 {
+			return nil, ErrUnknownAncestor
+		}
+		if header.Number-1 <= chain.Depth {
+			canonical, err := db.FetchChainBlock(header.Number - 1)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(canonical, header.ParentBlock) {
+				break
+			}
+		}
+		parent, err := db.FetchBlockHeader(header.ParentBlock)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil || parent.Number != header.Number-1 {
+			return nil, ErrUnknownAncestor
+		}
+		branch = append(branch, parent)
+		header = parent
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch, nil
+}
+
 // Encode deterministically encodes a Chain to binary format.
 func (chain Chain) Encode() ([]byte, error) {
 	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
@@ -107,18 +152,23 @@ func (chain *Chain) Decode(buf []byte) error {
 	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
 	defer figbuf.DecoderPool.Put(dec)
 
-	return dec.DecodeList(buf, func(r []byte) []byte {
+	var err error
+	derr := dec.DecodeList(buf, func(r []byte) []byte {
 		chain.Head, r = dec.DecodeNextBytes(r)
 		chain.Depth, r = dec.DecodeNextUint64(r)
 
 		var cfg []byte
 		cfg, r = dec.DecodeNextBytes(r)
-		err := chain.ChainConfig.Decode(cfg)
+		err = chain.ChainConfig.Decode(cfg)
 		if err != nil {
-			panic("invalid data for chain config")
+			return nil
 		}
 		return r
 	})
+	if derr != nil {
+		return derr
+	}
+	return err
 }
 
 // ChainDataService should save chain directly into a key/value store.
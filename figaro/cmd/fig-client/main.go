@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/consensus"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-client"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	"github.com/figaro-tech/go-figaro/figaro/keystore"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// stdin is shared, so that buffered input is not lost between password prompts.
+var stdin = bufio.NewReader(os.Stdin)
+
+const usage = `usage: fig-client <command> [flags]
+
+commands:
+  keygen    generate a new key in the keystore
+  accounts  list the addresses in the keystore
+  import    import a hex private key into the keystore
+  export    export a hex private key from the keystore
+  account   show the balance, stake and nonce of an address (--light to verify)
+  receipt   show the receipt of a processed tx (--light to verify)
+  send      commit and reveal a BalanceTx or StakeTx
+  deploy    commit and reveal a DeployTx, creating a contract
+  bond      commit and reveal a BondTx, bonding the stake of an address
+  unbond    commit and reveal an UnbondTx, unbonding the stake of an address
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "keygen":
+		keygen(args)
+	case "accounts":
+		accounts(args)
+	case "import":
+		importKey(args)
+	case "export":
+		exportKey(args)
+	case "account":
+		account(args)
+	case "receipt":
+		receipt(args)
+	case "send":
+		send(args)
+	case "deploy":
+		deploy(args)
+	case "bond":
+		bond("bond", figaro.BondTx, args)
+	case "unbond":
+		bond("unbond", figaro.UnbondTx, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// lightCacheSize is the number of headers the light client caches in memory.
+const lightCacheSize = 256
+
+// sendTimeout is how long the sender key stays unlocked while a tx is committed and revealed.
+const sendTimeout = 30 * time.Minute
+
+func keyStoreFlag(flags *flag.FlagSet) *string {
+	return flags.String("keystore", defaultKeyStore(), "Keystore Directory")
+}
+
+func defaultKeyStore() string {
+	u, err := user.Current()
+	if err != nil {
+		return "keystore"
+	}
+	return filepath.Join(u.HomeDir, ".figaro", "keystore")
+}
+
+func openKeyStore(dir string) *keystore.KeyStore {
+	keys, err := keystore.New(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return keys
+}
+
+// readPassword prompts for a password, without echo if stdin is a terminal.
+func readPassword(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		b, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return string(b)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func parseAddress(human string) figaro.Address {
+	addr, err := figaro.NewAddressFromHuman(human)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return *addr
+}
+
+func keygen(args []string) {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	ksFlag := keyStoreFlag(flags)
+	flags.Parse(args)
+
+	keys := openKeyStore(*ksFlag)
+	password := readPassword("Password: ")
+	if readPassword("Repeat Password: ") != password {
+		log.Fatal("fig-client: passwords do not match")
+	}
+	addr, err := keys.NewKey(password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Address:", addr.Human())
+}
+
+func accounts(args []string) {
+	flags := flag.NewFlagSet("accounts", flag.ExitOnError)
+	ksFlag := keyStoreFlag(flags)
+	flags.Parse(args)
+
+	addrs, err := openKeyStore(*ksFlag).Accounts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, addr := range addrs {
+		fmt.Println(addr.Human())
+	}
+}
+
+func importKey(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	ksFlag := keyStoreFlag(flags)
+	addrFlag := flags.String("address", "", "Account Address")
+	flags.Parse(args)
+
+	addr := parseAddress(*addrFlag)
+	privkey, err := hex.DecodeString(readPassword("Private Key (hex): "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	password := readPassword("Password: ")
+	err = openKeyStore(*ksFlag).Import(addr, privkey, password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Address:", addr.Human())
+}
+
+func exportKey(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	ksFlag := keyStoreFlag(flags)
+	addrFlag := flags.String("address", "", "Account Address")
+	flags.Parse(args)
+
+	addr := parseAddress(*addrFlag)
+	privkey, err := openKeyStore(*ksFlag).Export(addr, readPassword("Password: "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(hex.EncodeToString(privkey))
+}
+
+func account(args []string) {
+	flags := flag.NewFlagSet("account", flag.ExitOnError)
+	nodeFlag := flags.String("node", "http://localhost:8545", "Node JSON-RPC URL")
+	addrFlag := flags.String("address", "", "Account Address")
+	light := lightFlags(flags)
+	flags.Parse(args)
+
+	c := internal.NewClient(*nodeFlag)
+	addr := parseAddress(*addrFlag)
+	var acc *figaro.Account
+	var err error
+	if *light.enabled {
+		acc, err = light.open(c).FetchAccount(addr)
+	} else {
+		acc, err = c.FetchAccount(addr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Balance:", acc.Balance)
+	fmt.Println("Stake:", acc.Stake)
+	fmt.Println("Bonded:", acc.Bonded)
+	fmt.Println("Nonce:", acc.Nonce)
+}
+
+func receipt(args []string) {
+	flags := flag.NewFlagSet("receipt", flag.ExitOnError)
+	nodeFlag := flags.String("node", "http://localhost:8545", "Node JSON-RPC URL")
+	txFlag := flags.String("tx", "", "Tx ID (hex)")
+	light := lightFlags(flags)
+	flags.Parse(args)
+
+	txid := make(figaro.TxHash, figaro.TxHashSize)
+	err := txid.SetHex(*txFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := internal.NewClient(*nodeFlag)
+	var r *figaro.Receipt
+	if *light.enabled {
+		_, r, err = light.open(c).ProveTx(txid)
+	} else {
+		r, err = c.FetchReceipt(txid)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	printReceipt(r)
+}
+
+func send(args []string) {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	nodeFlag := flags.String("node", "http://localhost:8545", "Node JSON-RPC URL")
+	ksFlag := keyStoreFlag(flags)
+	fromFlag := flags.String("from", "", "Sender Address")
+	toFlag := flags.String("to", "", "Recipient Address")
+	valueFlag := flags.Uint64("value", 0, "Value to Transfer")
+	stakeFlag := flags.Bool("stake", false, "Transfer Stake instead of Balance")
+	gasFlag, gasPriceFlag := gasFlags(flags)
+	flags.Parse(args)
+
+	from, to := parseAddress(*fromFlag), parseAddress(*toFlag)
+	keys := openKeyStore(*ksFlag)
+	err := keys.Unlock(from, readPassword("Password: "), sendTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer keys.Lock(from)
+	txtype := figaro.BalanceTx
+	if *stakeFlag {
+		txtype = figaro.StakeTx
+	}
+
+	c := internal.NewClient(*nodeFlag)
+	tx, err := internal.NewTx(c, from, to, txtype, *valueFlag, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setGas(tx, *gasFlag, *gasPriceFlag)
+	err = internal.SendTx(c, tx, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Tx:", figaro.TxHash(tx.ID).Hex())
+	r, err := internal.WaitReceipt(c, tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printReceipt(r)
+}
+
+func deploy(args []string) {
+	flags := flag.NewFlagSet("deploy", flag.ExitOnError)
+	nodeFlag := flags.String("node", "http://localhost:8545", "Node JSON-RPC URL")
+	ksFlag := keyStoreFlag(flags)
+	fromFlag := flags.String("from", "", "Sender Address")
+	codeFlag := flags.String("code", "", "Contract Code (hex)")
+	initFlag := flags.String("init", "", "Constructor Code (hex)")
+	valueFlag := flags.Uint64("value", 0, "Value to Transfer to the Contract")
+	gasFlag, gasPriceFlag := gasFlags(flags)
+	flags.Parse(args)
+
+	from := parseAddress(*fromFlag)
+	d := figaro.DeployData{}
+	var err error
+	d.Code, err = hex.DecodeString(strings.TrimPrefix(*codeFlag, "0x"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	d.Init, err = hex.DecodeString(strings.TrimPrefix(*initFlag, "0x"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !d.Valid() {
+		log.Fatal(figaro.ErrInvalidDeployData)
+	}
+	data, err := d.Encode()
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := openKeyStore(*ksFlag)
+	err = keys.Unlock(from, readPassword("Password: "), sendTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer keys.Lock(from)
+
+	c := internal.NewClient(*nodeFlag)
+	tx, err := internal.NewTx(c, from, nil, figaro.DeployTx, *valueFlag, data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tx.To = figaro.ContractAddress(from, tx.Nonce)
+	setGas(tx, *gasFlag, *gasPriceFlag)
+	err = internal.SendTx(c, tx, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Tx:", figaro.TxHash(tx.ID).Hex())
+	fmt.Println("Contract:", tx.To.Human())
+	r, err := internal.WaitReceipt(c, tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printReceipt(r)
+}
+
+// bond sends a BondTx or UnbondTx for the sender itself.
+func bond(name string, txtype figaro.TxType, args []string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	nodeFlag := flags.String("node", "http://localhost:8545", "Node JSON-RPC URL")
+	ksFlag := keyStoreFlag(flags)
+	fromFlag := flags.String("from", "", "Staker Address")
+	gasFlag, gasPriceFlag := gasFlags(flags)
+	flags.Parse(args)
+
+	from := parseAddress(*fromFlag)
+	keys := openKeyStore(*ksFlag)
+	err := keys.Unlock(from, readPassword("Password: "), sendTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer keys.Lock(from)
+
+	c := internal.NewClient(*nodeFlag)
+	tx, err := internal.NewTx(c, from, from, txtype, 0, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setGas(tx, *gasFlag, *gasPriceFlag)
+	err = internal.SendTx(c, tx, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Tx:", figaro.TxHash(tx.ID).Hex())
+	r, err := internal.WaitReceipt(c, tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printReceipt(r)
+}
+
+func gasFlags(flags *flag.FlagSet) (gas, gasPrice *uint64) {
+	gas = flags.Uint64("gas", 0, "Gas Limit (default estimated)")
+	gasPrice = flags.Uint64("gasprice", 0, "Gas Price (default chain minimum)")
+	return
+}
+
+// setGas overrides the default gas limit and price of tx, if set.
+func setGas(tx *figaro.Transaction, gas, gasPrice uint64) {
+	if gas > 0 {
+		tx.GasLimit = gas
+	}
+	if gasPrice > 0 {
+		tx.GasPrice = gasPrice
+	}
+}
+
+// lightOptions are the flags for verifying node data with a light client.
+type lightOptions struct {
+	enabled   *bool
+	dir       *string
+	genesis   *string
+	consensus *string
+}
+
+func lightFlags(flags *flag.FlagSet) lightOptions {
+	return lightOptions{
+		enabled:   flags.Bool("light", false, "Verify node data against a light client header chain"),
+		dir:       flags.String("lightdir", defaultLightDir(), "Light Client Data Directory"),
+		genesis:   flags.String("genesis", "", "Trusted Genesis Block Hash (hex), on first use"),
+		consensus: flags.String("consensus", "authority", "Consensus Engine (authority or stake)"),
+	}
+}
+
+func defaultLightDir() string {
+	u, err := user.Current()
+	if err != nil {
+		return "light"
+	}
+	return filepath.Join(u.HomeDir, ".figaro", "light")
+}
+
+// open opens the light client, syncing its header chain with the node.
+func (o lightOptions) open(c *internal.Client) *internal.LightClient {
+	var genesis figaro.BlockHash
+	if *o.genesis != "" {
+		genesis = make(figaro.BlockHash, figaro.BlockHashSize)
+		err := genesis.SetHex(strings.TrimPrefix(*o.genesis, "0x"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var newEngine func([]figaro.Address) figaro.ConsensusEngine
+	switch *o.consensus {
+	case "authority":
+		newEngine = func(p []figaro.Address) figaro.ConsensusEngine { return consensus.NewAuthorityEngine(p) }
+	case "stake":
+		newEngine = func([]figaro.Address) figaro.ConsensusEngine { return consensus.NewStakeEngine() }
+	default:
+		log.Fatalf("fig-client: unknown consensus engine %q", *o.consensus)
+	}
+	lc, err := internal.NewLightClient(c, figdb.New(*o.dir, lightCacheSize), genesis, newEngine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = lc.Sync()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return lc
+}
+
+func printReceipt(r *figaro.Receipt) {
+	fmt.Println("Block:", r.BlockNum)
+	fmt.Println("Index:", r.Index)
+	fmt.Println("Success:", r.Success)
+	fmt.Println("Gas Used:", r.GasUsed)
+	fmt.Println("Fees:", r.TotalFees)
+}
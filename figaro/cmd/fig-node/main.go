@@ -3,19 +3,172 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/figaro-tech/go-fig-p2p"
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/consensus"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	"github.com/figaro-tech/go-figaro/figaro/keystore"
 	"github.com/multiformats/go-multiaddr"
 )
 
+const (
+	blockCacheSize = 1024
+	fastSyncRetry  = 10 * time.Second
+)
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		initChain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "state" {
+		state(os.Args[2:])
+		return
+	}
+	run()
+}
+
+// initChain creates the genesis block and chain from a genesis spec file.
+func initChain(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	genesisFlag := flags.String("genesis", "", "Genesis Spec File")
+	dataDirFlag := flags.String("datadir", "data", "Data Directory")
+	flags.Parse(args)
+
+	f, err := os.Open(*genesisFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	genesis, err := figaro.ReadGenesis(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := figdb.New(*dataDirFlag, blockCacheSize)
+	bl, err := internal.InitGenesis(db, genesis)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(figaro.BlockHash(bl.ID).Hex())
+}
+
+// state exports or imports a snapshot of the world state.
+func state(args []string) {
+	if len(args) < 1 {
+		log.Fatal("usage: fig-node state <export|import> [flags]")
+	}
+	switch args[0] {
+	case "export":
+		exportState(args[1:])
+	case "import":
+		importState(args[1:])
+	default:
+		log.Fatal("usage: fig-node state <export|import> [flags]")
+	}
+}
+
+func exportState(args []string) {
+	flags := flag.NewFlagSet("state export", flag.ExitOnError)
+	rootFlag := flags.String("root", "", "State Root (hex, default chain head)")
+	outFlag := flags.String("out", "state.snap", "Snapshot File")
+	dataDirFlag := flags.String("datadir", "data", "Data Directory")
+	flags.Parse(args)
+
+	db := figdb.New(*dataDirFlag, blockCacheSize)
+	root := make(figaro.Root, figaro.RootSize)
+	if *rootFlag != "" {
+		err := root.SetHex(strings.TrimPrefix(*rootFlag, "0x"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		chain, err := db.FetchChain()
+		if err != nil || chain == nil {
+			log.Fatal("fig-node: no chain found, pass --root")
+		}
+		header, err := db.FetchBlockHeader(chain.Head)
+		if err != nil {
+			log.Fatal(err)
+		}
+		root = header.StateRoot
+	}
+	f, err := os.Create(*outFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = internal.ExportState(db, root, f)
+	if err != nil {
+		f.Close()
+		os.Remove(*outFlag)
+		log.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(root.Hex())
+}
+
+func importState(args []string) {
+	flags := flag.NewFlagSet("state import", flag.ExitOnError)
+	inFlag := flags.String("in", "state.snap", "Snapshot File")
+	dataDirFlag := flags.String("datadir", "data", "Data Directory")
+	flags.Parse(args)
+
+	f, err := os.Open(*inFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	db := figdb.New(*dataDirFlag, blockCacheSize)
+	root, err := internal.ImportState(db, f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(root.Hex())
+}
+
+func run() {
 	ctx := context.Background()
 
 	bootAddFlag := flag.String("bootaddr", "", "Bootstrap Node Addrs")
+	dataDirFlag := flag.String("datadir", "data", "Data Directory")
+	rpcAddrFlag := flag.String("rpcaddr", "", "JSON-RPC Listen Addr")
+	adminAddrFlag := flag.String("adminaddr", "", "Admin JSON-RPC Listen Addr, keep it private")
+	consensusFlag := flag.String("consensus", "authority", "Consensus Engine (authority or stake)")
+	keyStoreFlag := flag.String("keystore", "", "Keystore Directory (default <datadir>/keystore)")
+	producerFlag := flag.String("producer", "", "Block Producer Address, to produce blocks")
+	beneficiaryFlag := flag.String("beneficiary", "", "Block Beneficiary Address (default producer)")
+	passwordFlag := flag.String("password", "", "Producer Key Password File")
+	blockTimeFlag := flag.Duration("blocktime", 5*time.Second, "Block Production Interval")
+	fastSyncFlag := flag.Bool("fastsync", true, "Fast Sync a fresh node from peers")
+	networkIDFlag := flag.Uint64("networkid", figaro.DefaultNetworkID, "Network ID, which peers must share")
 	flag.Parse()
 
+	db := figdb.New(*dataDirFlag, blockCacheSize)
+	genesis, err := internal.FetchGenesis(db)
+	if err != nil {
+		log.Fatal("fig-node: no chain found, run `fig-node init --genesis <file>` first")
+	}
+
+	engine, err := newEngine(db, genesis, *consensusFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pool := figaro.NewTxPool(figaro.DefaultTxPoolConfig)
+	sigs := internal.NewSigVerifier(0, internal.NewSigCache(internal.DefaultSigCacheSize))
+
 	bootAddr, err := multiaddr.NewMultiaddr(*bootAddFlag)
 	if err != nil {
 		log.Panic(err)
@@ -25,11 +178,141 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
+	rep, err := internal.NewReputation(node.Host(), figdb.New(filepath.Join(*dataDirFlag, "peers"), 0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	peers := internal.Handshake(ctx, node.Host(), db, rep, *networkIDFlag, genesis)
+	internal.ServeSync(node.Host(), db)
+	fig := internal.NewNode(db, pool, engine, sigs)
+	fig.OnReorg(func(ev *internal.ReorgEvent) {
+		log.Printf("fig-node: chain reorg at block %d, depth %d, head %s -> %s", ev.AncestorNumber, ev.Depth, ev.OldHead, ev.NewHead)
+	})
+	gossip := internal.NewGossip(ctx, node.Host(), peers, db, pool, sigs, fig)
+	go internal.NewDownloader(node.Host(), peers, fig).Run(ctx)
+
+	if *rpcAddrFlag != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*rpcAddrFlag, internal.NewRPCServer(db, pool, sigs, gossip)))
+		}()
+	}
+	if *adminAddrFlag != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*adminAddrFlag, internal.NewAdminRPCServer(rep)))
+		}()
+	}
 
 	go node.Start(ctx)
 
+	var producer *internal.Producer
+	if *producerFlag != "" {
+		dir := *keyStoreFlag
+		if dir == "" {
+			dir = filepath.Join(*dataDirFlag, "keystore")
+		}
+		producer, err = newProducer(dir, *producerFlag, *beneficiaryFlag, *passwordFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	go func() {
+		// Blocks are only received and produced once the node has caught up
+		if *fastSyncFlag {
+			fig.SetSyncing(true)
+			fastSync(ctx, internal.NewFastSync(node.Host(), peers, db, pool, engine, sigs))
+			fig.SetSyncing(false)
+		}
+		if producer != nil {
+			produce(fig, gossip, producer, *blockTimeFlag)
+		}
+	}()
+
 	for {
 		time.Sleep(1 * time.Second)
 		log.Println("Connections:", len(node.Host().Network().Conns()))
 	}
 }
+
+// newEngine returns the consensus engine, with the initial producers from the genesis state.
+func newEngine(db *figdb.DB, genesis figaro.BlockHash, kind string) (figaro.ConsensusEngine, error) {
+	header, err := db.FetchBlockHeader(genesis)
+	if err != nil {
+		return nil, err
+	}
+	producers, err := figaro.GenesisProducers(db, header.StateRoot)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "authority":
+		return consensus.NewAuthorityEngine(producers), nil
+	case "stake":
+		return consensus.NewStakeEngine(), nil
+	default:
+		return nil, fmt.Errorf("fig-node: unknown consensus engine %q", kind)
+	}
+}
+
+// newProducer unlocks the producer key in the keystore at dir, using the password in passfile.
+func newProducer(dir, address, beneficiary, passfile string) (*internal.Producer, error) {
+	keys, err := keystore.New(dir)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := figaro.NewAddressFromHuman(address)
+	if err != nil {
+		return nil, err
+	}
+	benef := addr
+	if beneficiary != "" {
+		benef, err = figaro.NewAddressFromHuman(beneficiary)
+		if err != nil {
+			return nil, err
+		}
+	}
+	password, err := ioutil.ReadFile(passfile)
+	if err != nil {
+		return nil, err
+	}
+	err = keys.Unlock(*addr, strings.TrimRight(string(password), "\r\n"), 0)
+	if err != nil {
+		return nil, err
+	}
+	return &internal.Producer{Address: *addr, Beneficiary: *benef, Keys: keys}, nil
+}
+
+// fastSync runs a fast sync until it completes, retrying after errors.
+func fastSync(ctx context.Context, fs *internal.FastSync) {
+	for {
+		err := fs.Run(ctx)
+		if err == nil {
+			p := fs.Progress()
+			if p.Stage == figaro.SyncDone {
+				log.Printf("fig-node: fast sync done at block %d", p.Blocks)
+			}
+			return
+		}
+		log.Println(err)
+		time.Sleep(fastSyncRetry)
+	}
+}
+
+// produce produces a block every interval in which this node is the next block producer,
+// announcing it to peers.
+func produce(fig *internal.Node, gossip *internal.Gossip, producer *internal.Producer, interval time.Duration) {
+	for range time.Tick(interval) {
+		bl, err := fig.ProduceBlock(producer)
+		if err == internal.ErrNotProducer {
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Printf("fig-node: produced block %d %s", bl.Number, figaro.BlockHash(bl.ID))
+		err = gossip.BroadcastBlock(bl)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -15,7 +15,9 @@ type ConsensusEngine interface {
 	// ChainReorg is responsibile for determining a canonical chain in the event of divergent,
 	// but otherwise valid, chains/blocks. It receives the current chain, the block that would conflict
 	// with the current chain head, and the list of pending fugure blocks. It should return the new chain,
-	// along with the next and future blocks in the canonical chain.
+	// along with the next and future blocks in the canonical chain. Rules that depend on the state of
+	// the fork, such as the producer of each block, are left to the replay of the fork, since its
+	// state is not synced yet.
 	ChainReorg(db FullDataService, chain *Chain, forkblock *BlockHeader, futureblocks *BlockHeap) (*Chain, *BlockHeader, *BlockHeap, error)
 }
 
@@ -25,6 +27,14 @@ type FullDataService interface {
 	CommitDataService
 	TransactionDataService
 	ReceiptDataService
+	EvidenceDataService
 	BlockDataService
 	ChainDataService
+	FraudDataService
+}
+
+// FraudDataService records blocks found to be fraudulent, so that they are never adopted.
+type FraudDataService interface {
+	SaveFraudBlock(id BlockHash) error
+	HasFraudBlock(id BlockHash) (bool, error)
 }
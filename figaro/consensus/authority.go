@@ -0,0 +1,70 @@
+// Package consensus implements figaro consensus engines.
+package consensus
+
+import (
+	"bytes"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// AuthorityEngine is a round-robin proof-of-authority ConsensusEngine, suitable for local
+// and CI networks. Block production rotates through a fixed list of producers by block
+// number, regardless of stake.
+type AuthorityEngine struct {
+	producers []figaro.Address
+}
+
+// NewAuthorityEngine returns an AuthorityEngine that rotates through producers, in order.
+func NewAuthorityEngine(producers []figaro.Address) *AuthorityEngine {
+	p := make([]figaro.Address, len(producers))
+	copy(p, producers)
+	return &AuthorityEngine{producers: p}
+}
+
+// Producer returns the producer in turn for the block number.
+func (e *AuthorityEngine) Producer(number uint64) (figaro.Address, error) {
+	if len(e.producers) == 0 {
+		return nil, ErrNoProducer
+	}
+	return e.producers[number%uint64(len(e.producers))], nil
+}
+
+// NextBlockProducer returns the producer in turn for the block following prevblock.
+func (e *AuthorityEngine) NextBlockProducer(db figaro.FullDataService, prevblock figaro.BlockHash) (figaro.Address, error) {
+	prev, err := db.FetchBlockHeader(prevblock)
+	if err != nil {
+		return nil, err
+	}
+	return e.Producer(prev.Number + 1)
+}
+
+// HandleFraud does nothing, since the producers are trusted. The fraudulent block is still rejected.
+func (e *AuthorityEngine) HandleFraud(db figaro.FullDataService, fraudblock *figaro.BlockHeader) error {
+	return nil
+}
+
+// ChainReorg adopts a fork only if it is longer than the current chain, keeping the first seen
+// chain otherwise. Every block of the fork must be signed by the producer in turn.
+func (e *AuthorityEngine) ChainReorg(db figaro.FullDataService, chain *figaro.Chain, forkblock *figaro.BlockHeader, futureblocks *figaro.BlockHeap) (*figaro.Chain, *figaro.BlockHeader, *figaro.BlockHeap, error) {
+	if forkblock.Number <= chain.Depth {
+		return nil, nil, nil, figaro.ErrForkRejected
+	}
+	branch, err := chain.ForkBranch(db, forkblock)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, header := range branch {
+		producer, err := e.Producer(header.Number)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !bytes.Equal(producer, header.Producer) || !header.VerifySignature() {
+			return nil, nil, nil, figaro.ErrInvalidBlock
+		}
+	}
+	first, err := rewind(db, chain, branch, futureblocks)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return chain, first, futureblocks, nil
+}
@@ -0,0 +1,28 @@
+// Package consensus implements figaro consensus engines.
+package consensus
+
+import (
+	"container/heap"
+	"errors"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// ErrNoProducer is returned when no account is eligible to produce the next block.
+var ErrNoProducer = errors.New("figaro consensus: no eligible block producer")
+
+// rewind resets the chain to the common ancestor of branch and queues the branch as future blocks,
+// so that it is synced on top of the ancestor state. It returns the first block to sync.
+func rewind(db figaro.FullDataService, chain *figaro.Chain, branch []*figaro.BlockHeader, futureblocks *figaro.BlockHeap) (*figaro.BlockHeader, error) {
+	first := branch[0]
+	chain.Depth = first.Number - 1
+	chain.Head = first.ParentBlock
+	err := db.SaveChain(chain)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range branch[1:] {
+		heap.Push(futureblocks, header)
+	}
+	return first, nil
+}
@@ -0,0 +1,114 @@
+// Package consensus implements figaro consensus engines.
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// ErrStakeOverflow is returned when the total eligible stake overflows.
+var ErrStakeOverflow = errors.New("figaro consensus: total stake overflow")
+
+// StakeEngine is a proof-of-stake ConsensusEngine. The next block producer is selected
+// from the Bonded accounts with at least `ChainConfig.Stake` at the previous block,
+// with a probability proportional to their Stake, seeded by the previous block hash.
+// Accounts join the candidates with a BondTx, and leave them with an UnbondTx or when slashed.
+// Fraudulent blocks are recorded in the db, so that they are never adopted.
+type StakeEngine struct{}
+
+// NewStakeEngine returns a StakeEngine.
+func NewStakeEngine() *StakeEngine {
+	return &StakeEngine{}
+}
+
+// NextBlockProducer deterministically selects the next block producer, weighted by stake.
+func (e *StakeEngine) NextBlockProducer(db figaro.FullDataService, prevblock figaro.BlockHash) (figaro.Address, error) {
+	prev, err := db.FetchBlockHeader(prevblock)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := figaro.BondedAddresses(db, prev.StateRoot)
+	if err != nil {
+		return nil, err
+	}
+	eligible := make([]*figaro.Account, 0, len(candidates))
+	var total uint64
+	for _, addr := range candidates {
+		acc, err := db.FetchAccount(prev.StateRoot, addr)
+		if err != nil {
+			return nil, err
+		}
+		if !acc.Bonded || acc.Stake == 0 || acc.Stake < prev.Stake {
+			continue
+		}
+		if acc.Stake > math.MaxUint64-total {
+			return nil, ErrStakeOverflow
+		}
+		total += acc.Stake
+		eligible = append(eligible, acc)
+	}
+	if total == 0 {
+		return nil, ErrNoProducer
+	}
+	seed := binary.BigEndian.Uint64(hasher.Hash256(prevblock)) % total
+	for _, acc := range eligible {
+		if seed < acc.Stake {
+			return acc.Address, nil
+		}
+		seed -= acc.Stake
+	}
+	// unreachable, since seed < total
+	return nil, ErrNoProducer
+}
+
+// HandleFraud records a fraudulent block in db, so that no fork containing it is ever adopted.
+// Only blocks signed by the producer selected for their parent are attributable to that producer.
+func (e *StakeEngine) HandleFraud(db figaro.FullDataService, fraudblock *figaro.BlockHeader) error {
+	if !fraudblock.VerifySignature() {
+		return nil
+	}
+	next, err := e.NextBlockProducer(db, fraudblock.ParentBlock)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(next, fraudblock.Producer) {
+		return nil
+	}
+	return db.SaveFraudBlock(fraudblock.ID)
+}
+
+// ChainReorg adopts a fork only if it is longer than the current chain, keeping the first seen
+// chain otherwise. Every block of the fork must be signed, and must not be fraudulent. Whether
+// each block is signed by the producer selected for its parent depends on the state of the fork,
+// so it is checked as the fork is replayed on top of the common ancestor.
+func (e *StakeEngine) ChainReorg(db figaro.FullDataService, chain *figaro.Chain, forkblock *figaro.BlockHeader, futureblocks *figaro.BlockHeap) (*figaro.Chain, *figaro.BlockHeader, *figaro.BlockHeap, error) {
+	if forkblock.Number <= chain.Depth {
+		return nil, nil, nil, figaro.ErrForkRejected
+	}
+	branch, err := chain.ForkBranch(db, forkblock)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, header := range branch {
+		if !header.VerifySignature() {
+			return nil, nil, nil, figaro.ErrInvalidBlock
+		}
+		fraud, err := db.HasFraudBlock(header.ID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if fraud {
+			return nil, nil, nil, figaro.ErrInvalidBlock
+		}
+	}
+	first, err := rewind(db, chain, branch, futureblocks)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return chain, first, futureblocks, nil
+}
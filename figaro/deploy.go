@@ -0,0 +1,67 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/figaro-tech/go-fig-buf"
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+)
+
+// ErrInvalidDeployData is a self-explantory error.
+var ErrInvalidDeployData = errors.New("figaro tx: invalid DeployTx data")
+
+// DeployData is the Data of a DeployTx. Code is saved as the Code of the new contract
+// account. Init is optional constructor code, which is run once against the new contract
+// account, with no input, and is not saved.
+type DeployData struct {
+	Code []byte
+	Init []byte
+}
+
+// ContractAddress returns the address of the contract deployed by from with nonce.
+func ContractAddress(from Address, nonce uint64) Address {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], nonce)
+	return Address(hasher.Hash256([]byte("figaro/contract"), from, n[:])[:AddressSize])
+}
+
+// Valid returns whether the deploy data is within limits.
+func (d DeployData) Valid() bool {
+	return len(d.Code) > 0 && len(d.Code) <= MaxCodeSize && len(d.Init) <= MaxTxDataSize
+}
+
+// Encode deterministically encodes deploy data to binary format.
+func (d DeployData) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	return enc.EncodeList(func(buf []byte) []byte {
+		buf = enc.EncodeNextBytes(buf, d.Code)
+		buf = enc.EncodeNextBytes(buf, d.Init)
+		return buf
+	})
+}
+
+// Decode decodes deterministically encoded deploy data from binary format.
+func (d *DeployData) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	return dec.DecodeList(buf, func(r []byte) []byte {
+		d.Code, r = dec.DecodeNextBytes(r)
+		d.Init, r = dec.DecodeNextBytes(r)
+		return r
+	})
+}
+
+// decodeDeployData decodes and validates the Data of a DeployTx.
+func decodeDeployData(tx *Transaction) (*DeployData, error) {
+	d := &DeployData{}
+	err := d.Decode(tx.Data)
+	if err != nil || !d.Valid() {
+		return nil, ErrInvalidDeployData
+	}
+	return d, nil
+}
@@ -0,0 +1,183 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/figaro-tech/go-fig-buf"
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+)
+
+const (
+	// MaxEvidenceSize is the max number of evidence for a block
+	MaxEvidenceSize = 64
+	// FraudSlashPercent is the percent of Stake slashed from a producer for a fraudulent block.
+	FraudSlashPercent = 100
+	// DoubleSignSlashPercent is the percent of Stake slashed from a producer for signing
+	// two different blocks with the same Number.
+	DoubleSignSlashPercent = 50
+)
+
+var (
+	// ErrInvalidEvidence is returned when evidence does not prove an offence, or the offence
+	// has already been penalized.
+	ErrInvalidEvidence = errors.New("figaro evidence: invalid evidence")
+	// ErrInvalidEvidenceData is a self-explantory error.
+	ErrInvalidEvidenceData = errors.New("figaro evidence: invalid Evidence data")
+)
+
+// EvidenceType is the kind of offence proven by Evidence.
+type EvidenceType byte
+
+const (
+	// FraudEvidence proves that a producer signed a fraudulent block.
+	FraudEvidence EvidenceType = iota
+	// DoubleSignEvidence proves that a producer signed two different blocks with the same Number.
+	DoubleSignEvidence
+)
+
+// Evidence of a producer offence. Evidence is included in blocks, so that every node applies
+// the same penalty to the offending producer.
+type Evidence struct {
+	Type    EvidenceType
+	Fraud   *FraudProof
+	Headers [2]*BlockHeader
+}
+
+// header returns the block header the offence was committed with.
+func (ev *Evidence) header() *BlockHeader {
+	if ev.Type == FraudEvidence {
+		if ev.Fraud == nil {
+			return nil
+		}
+		return ev.Fraud.Header
+	}
+	return ev.Headers[0]
+}
+
+// Offender returns the address of the offending producer.
+func (ev *Evidence) Offender() Address {
+	header := ev.header()
+	if header == nil {
+		return nil
+	}
+	return header.Producer
+}
+
+// OffenceKey uniquely identifies the offence, so that a producer is only penalized once per block Number.
+func (ev *Evidence) OffenceKey() []byte {
+	header := ev.header()
+	if header == nil {
+		return nil
+	}
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], header.Number)
+	return hasher.Hash256([]byte("figaro/offence"), header.Producer, n[:])
+}
+
+// SlashPercent returns the percent of Stake slashed for the offence.
+func (ev *Evidence) SlashPercent() uint64 {
+	if ev.Type == FraudEvidence {
+		return FraudSlashPercent
+	}
+	return DoubleSignSlashPercent
+}
+
+// Verify returns whether the evidence proves an offence. See `FraudProof.Verify` for the checks
+// left to the caller for FraudEvidence.
+func (ev *Evidence) Verify(db FraudLDataService) bool {
+	switch ev.Type {
+	case FraudEvidence:
+		return ev.Fraud != nil && ev.Fraud.Verify(db)
+	case DoubleSignEvidence:
+		a, b := ev.Headers[0], ev.Headers[1]
+		if !validHeader(a) || !validHeader(b) {
+			return false
+		}
+		return a.Number == b.Number && bytes.Equal(a.Producer, b.Producer) && !bytes.Equal(a.ID, b.ID)
+	default:
+		return false
+	}
+}
+
+// Slash applies the penalty for the offence to the offender account, unbonding it.
+func (ev *Evidence) Slash(offender *Account) {
+	pct := ev.SlashPercent()
+	offender.Stake -= offender.Stake/100*pct + offender.Stake%100*pct/100
+	offender.Bonded = false
+}
+
+// Encode deterministically encodes Evidence to binary format.
+func (ev Evidence) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	var err error
+	encodeNext := func(buf []byte, e func() ([]byte, error)) []byte {
+		b, eerr := e()
+		if eerr != nil {
+			err = eerr
+		}
+		return enc.EncodeNextBytes(buf, b)
+	}
+	b, eerr := enc.EncodeList(func(buf []byte) []byte {
+		buf = enc.EncodeNextUint8(buf, uint8(ev.Type))
+		buf = encodeNext(buf, func() ([]byte, error) {
+			if ev.Fraud == nil {
+				return nil, nil
+			}
+			return ev.Fraud.Encode()
+		})
+		buf = encodeNext(buf, encodeHeader(ev.Headers[0]))
+		buf = encodeNext(buf, encodeHeader(ev.Headers[1]))
+		return buf
+	})
+	if eerr != nil {
+		return nil, eerr
+	}
+	return b, err
+}
+
+// Decode decodes deterministically encoded Evidence from binary format.
+func (ev *Evidence) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	var err error
+	derr := dec.DecodeList(buf, func(r []byte) []byte {
+		var t uint8
+		var b []byte
+		t, r = dec.DecodeNextUint8(r)
+		ev.Type = EvidenceType(t)
+		b, r = dec.DecodeNextBytes(r)
+		if len(b) > 0 {
+			ev.Fraud = &FraudProof{}
+			err = ev.Fraud.Decode(b)
+		}
+		b, r = dec.DecodeNextBytes(r)
+		ev.Headers[0], err = decodeHeader(b, err)
+		b, r = dec.DecodeNextBytes(r)
+		ev.Headers[1], err = decodeHeader(b, err)
+		return r
+	})
+	if derr != nil {
+		return derr
+	}
+	if err != nil {
+		return ErrInvalidEvidenceData
+	}
+	return nil
+}
+
+// EvidenceLDataService implements limited local data for evidence.
+type EvidenceLDataService interface {
+	RetrieveEvidence(root Root) ([]*Evidence, error)
+}
+
+// EvidenceDataService provides archive data service for evidence.
+type EvidenceDataService interface {
+	ArchiveEvidence(evidence []*Evidence) (root Root, err error)
+	EvidenceLDataService
+}
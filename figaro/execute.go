@@ -0,0 +1,241 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+)
+
+// TxAccounts are the accounts touched by a transaction, fetched from the same state root. An
+// address in more than one role, such as the sender of a self-transfer, or a block producer paying
+// itself fees, is a single shared Account, so that every change to it is kept. Call Dedupe after
+// fetching the accounts to ensure this.
+type TxAccounts struct {
+	From              *Account
+	To                *Account
+	CommitBeneficiary *Account
+	TxBeneficiary     *Account
+}
+
+// Dedupe points every role with the address of an earlier role at the Account of that role.
+func (accs *TxAccounts) Dedupe() {
+	roles := []**Account{&accs.From, &accs.To, &accs.CommitBeneficiary, &accs.TxBeneficiary}
+	for i, acc := range roles {
+		if *acc == nil {
+			continue
+		}
+		for _, prev := range roles[:i] {
+			if *prev != nil && bytes.Equal((*prev).Address, (*acc).Address) {
+				*acc = *prev
+				break
+			}
+		}
+	}
+}
+
+// Ordered returns each distinct non-nil account once, in the order they must be saved.
+func (accs TxAccounts) Ordered() []*Account {
+	ordered := make([]*Account, 0, 4)
+	for _, acc := range []*Account{accs.From, accs.To, accs.CommitBeneficiary, accs.TxBeneficiary} {
+		if acc == nil {
+			continue
+		}
+		seen := false
+		for _, prev := range ordered {
+			if bytes.Equal(prev.Address, acc.Address) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			ordered = append(ordered, acc)
+		}
+	}
+	return ordered
+}
+
+// CheckTx returns whether tx is valid for processing as the next transaction in txblock, given the
+// sender account at the current state, the header of the block the tx commit was mined into, and
+// whether that block has the commit.
+func CheckTx(tx *Transaction, from *Account, txblock, commitblock *BlockHeader, committed bool) bool {
+	if from == nil {
+		return false
+	}
+	// Nonce must match
+	if tx.Nonce != from.Nonce {
+		return false
+	}
+	// Is a valid TxType
+	if !ValidTxType(tx.Type) {
+		return false
+	}
+	// Follows data limits
+	if !tx.ValidData() {
+		return false
+	}
+	// Deploys to the derived contract address
+	if tx.Type == DeployTx && !bytes.Equal(tx.To, ContractAddress(tx.From, tx.Nonce)) {
+		return false
+	}
+	// Bonds or unbonds the sender itself
+	if (tx.Type == BondTx || tx.Type == UnbondTx) && (!bytes.Equal(tx.To, tx.From) || tx.Value != 0) {
+		return false
+	}
+	// Pays at least the min gas price, for at least the intrinsic gas, within the block gas limit
+	if tx.GasPrice < txblock.MinGasPrice || tx.GasLimit < IntrinsicGas(tx) || tx.GasLimit > txblock.GasLimit {
+		return false
+	}
+	if tx.GasPrice != 0 && tx.GasLimit > math.MaxUint64/tx.GasPrice {
+		return false
+	}
+	// Is signed
+	if len(tx.Signature) != fastsig.SignatureSize {
+		return false
+	}
+	// Sanity check
+	if tx.CommitBlock != commitblock.Number {
+		return false
+	}
+	// MPTx rules
+	diffN := txblock.Number - commitblock.Number
+	if diffN < uint64(txblock.WaitBlocks) || diffN > 2*uint64(txblock.WaitBlocks)+1 {
+		return false
+	}
+	if !committed {
+		return false
+	}
+	// No free money, even if the whole GasLimit is used
+	totalFees := TxFees(tx, tx.GasLimit, txblock, commitblock)
+	if totalFees > from.Balance {
+		return false
+	}
+	switch tx.Type {
+	case StakeTx:
+		if tx.Value > from.Stake {
+			return false
+		}
+	case BalanceTx, DeployTx:
+		if tx.Value > from.Balance-totalFees {
+			return false
+		}
+	case BondTx:
+		// Only stake that is eligible to produce blocks can be bonded
+		if from.Bonded || from.Stake == 0 || from.Stake < txblock.Stake {
+			return false
+		}
+	case UnbondTx:
+		if !from.Bonded {
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// TxFees returns the total fees owed by a transaction in txblock that was committed in commitblock,
+// when it uses gasUsed. Fees saturate at math.MaxUint64 rather than overflowing.
+func TxFees(tx *Transaction, gasUsed uint64, txblock, commitblock *BlockHeader) uint64 {
+	var totalFees uint64
+	if !commitblock.Beneficiary.IsZeroAddress() {
+		totalFees += uint64(commitblock.CommitFee)
+	}
+	if !txblock.Beneficiary.IsZeroAddress() {
+		fee := GasFee(gasUsed, tx.GasPrice)
+		if fee > math.MaxUint64-totalFees {
+			return math.MaxUint64
+		}
+		totalFees += fee
+	}
+	return totalFees
+}
+
+// RunsCode returns whether applying a valid transaction to the To account runs contract code,
+// either the To account Code, or the Init code of a DeployTx.
+func RunsCode(tx *Transaction, to *Account) bool {
+	if tx.Type == DeployTx {
+		d, err := decodeDeployData(tx)
+		return err == nil && len(d.Init) > 0
+	}
+	return len(to.Code) > 0
+}
+
+// ApplyTx applies a valid transaction that used gasUsed to the accounts it touches, returning
+// the total fees paid. The sender only pays for gasUsed, so the rest of the GasLimit is refunded.
+// It assumes that the transaction is valid for processing, and will perform no checks. The
+// beneficiary accounts must be set if, and only if, the beneficiary is not the ZeroAddress.
+// A BondTx must also add the sender to the bonded list, with AddBondedAddress.
+func ApplyTx(tx *Transaction, accs *TxAccounts, gasUsed uint64, txblock, commitblock *BlockHeader) (uint64, error) {
+	accs.From.Nonce++
+	var totalFees uint64
+	if accs.CommitBeneficiary != nil {
+		accs.CommitBeneficiary.Balance += uint64(commitblock.CommitFee)
+		accs.From.Balance -= uint64(commitblock.CommitFee)
+		totalFees += uint64(commitblock.CommitFee)
+	}
+	if accs.TxBeneficiary != nil {
+		fee := GasFee(gasUsed, tx.GasPrice)
+		accs.TxBeneficiary.Balance += fee
+		accs.From.Balance -= fee
+		totalFees += fee
+	}
+	switch tx.Type {
+	case StakeTx:
+		accs.From.Stake -= tx.Value
+		accs.To.Stake += tx.Value
+	case BalanceTx:
+		accs.From.Balance -= tx.Value
+		accs.To.Balance += tx.Value
+	case DeployTx:
+		d, err := decodeDeployData(tx)
+		if err != nil {
+			return 0, err
+		}
+		accs.From.Balance -= tx.Value
+		accs.To.Balance += tx.Value
+		accs.To.Code = d.Code
+	case BondTx:
+		accs.From.Bonded = true
+	case UnbondTx:
+		accs.From.Bonded = false
+	default:
+		return 0, ErrInvalidTransaction
+	}
+	return totalFees, nil
+}
+
+// ApplyInvalidTx applies an invalid transaction that used gasUsed to the accounts it touches,
+// returning the total fees owed. Invalid transactions still pay fees to discourage spam txs, as
+// far as the sender balance allows. The To account is left untouched, and is cleared from accs.
+func ApplyInvalidTx(tx *Transaction, accs *TxAccounts, gasUsed uint64, txblock, commitblock *BlockHeader) uint64 {
+	accs.To = nil
+	accs.From.Nonce++
+	totalFees := TxFees(tx, gasUsed, txblock, commitblock)
+	var feeRatio float64
+	if totalFees > accs.From.Balance {
+		feeRatio = float64(accs.From.Balance) / float64(totalFees)
+	} else {
+		feeRatio = 1
+	}
+	if accs.CommitBeneficiary != nil {
+		cfee := uint64(feeRatio * float64(commitblock.CommitFee))
+		if cfee > accs.From.Balance {
+			// this should never happen
+			panic("invalid commit fee")
+		}
+		accs.CommitBeneficiary.Balance += cfee
+		accs.From.Balance -= cfee
+	}
+	if accs.TxBeneficiary != nil {
+		// Gas fees may be too large to round trip through a float64, so cap at the balance
+		txfee := accs.From.Balance
+		if f := feeRatio * float64(GasFee(gasUsed, tx.GasPrice)); f < float64(txfee) {
+			txfee = uint64(f)
+		}
+		accs.TxBeneficiary.Balance += txfee
+		accs.From.Balance -= txfee
+	}
+	return totalFees
+}
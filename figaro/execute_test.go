@@ -0,0 +1,247 @@
+package figaro
+
+import (
+	"testing"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+)
+
+// memState is an AccountDataService over a single in-memory world state, ignoring roots.
+type memState map[string]*Account
+
+func (m memState) FetchAccount(root Root, address Address) (*Account, error) {
+	acc, ok := m[string(address)]
+	if !ok {
+		return &Account{Address: address}, nil
+	}
+	cp := *acc
+	return &cp, nil
+}
+
+func (m memState) ProveAccount(root Root, address Address) (*Account, [][][]byte, error) {
+	acc, err := m.FetchAccount(root, address)
+	return acc, nil, err
+}
+
+func (m memState) ValidateAccount(root Root, account *Account, proof [][][]byte) bool {
+	return true
+}
+
+func (m memState) FetchAccountStorage(account *Account, key []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (m memState) ProveAccountStorage(account *Account, key []byte) ([]byte, [][][]byte, error) {
+	return nil, nil, nil
+}
+
+func (m memState) ValidateAccountStorage(account *Account, key, data []byte, proof [][][]byte) bool {
+	return true
+}
+
+func (m memState) SaveAccount(root Root, account *Account) (Root, error) {
+	cp := *account
+	m[string(account.Address)] = &cp
+	return root, nil
+}
+
+func (m memState) SaveAccountStorage(root Root, account *Account, key, data []byte) (Root, error) {
+	return root, nil
+}
+
+func (m memState) SetAccountStorage(account *Account, key, data []byte) error {
+	return nil
+}
+
+// applyTx applies tx to state the way a node does, fetching the accounts it touches from the
+// overlay, applying it, and flushing the accounts back.
+func applyTx(t *testing.T, state *StateOverlay, tx *Transaction, txblock, commitblock *BlockHeader) uint64 {
+	fetch := func(address Address) *Account {
+		acc, err := state.FetchAccount(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return acc
+	}
+	accs := &TxAccounts{
+		From:              fetch(tx.From),
+		To:                fetch(tx.To),
+		CommitBeneficiary: fetch(commitblock.Beneficiary),
+		TxBeneficiary:     fetch(txblock.Beneficiary),
+	}
+	accs.Dedupe()
+	fees, err := ApplyTx(tx, accs, IntrinsicGas(tx), txblock, commitblock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range accs.Ordered() {
+		state.SetAccount(acc)
+	}
+	_, err = state.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fees
+}
+
+func TestApplyTxAliasedAccounts(t *testing.T) {
+	alice := Address("alice")
+	bob := Address("bob")
+	carol := Address("carol")
+	const balance = 1000000
+	tests := []struct {
+		name      string
+		to        Address
+		producer  Address
+		committer Address
+		want      map[string]Account
+	}{
+		{
+			name:      "self-transfer",
+			to:        alice,
+			producer:  bob,
+			committer: carol,
+			// Alice only pays the fees, and the nonce is kept
+			want: map[string]Account{
+				"alice": {Nonce: 1, Balance: balance - TxGas - 5},
+				"bob":   {Balance: TxGas},
+				"carol": {Balance: 5},
+			},
+		},
+		{
+			name:      "producer pays itself",
+			to:        bob,
+			producer:  alice,
+			committer: alice,
+			// Alice pays the fees to herself, and only the value leaves
+			want: map[string]Account{
+				"alice": {Nonce: 1, Balance: balance - 100},
+				"bob":   {Balance: 100},
+			},
+		},
+		{
+			name:      "self-transfer by the producer",
+			to:        alice,
+			producer:  alice,
+			committer: alice,
+			want: map[string]Account{
+				"alice": {Nonce: 1, Balance: balance},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memState{"alice": {Address: alice, Balance: balance}}
+			state := NewStateOverlay(db, Root("root"))
+			tx := &Transaction{Type: BalanceTx, From: alice, To: tt.to, Value: 100, GasPrice: 1, GasLimit: TxGas}
+			txblock := &BlockHeader{Beneficiary: tt.producer}
+			commitblock := &BlockHeader{Beneficiary: tt.committer, ChainConfig: ChainConfig{CommitFee: 5}}
+			fees := applyTx(t, state, tx, txblock, commitblock)
+			if fees != TxGas+5 {
+				t.Errorf("fees = %d, want %d", fees, TxGas+5)
+			}
+			for addr, want := range tt.want {
+				got, err := state.FetchAccount(Address(addr))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Nonce != want.Nonce || got.Balance != want.Balance {
+					t.Errorf("%s: nonce %d balance %d, want nonce %d balance %d", addr, got.Nonce, got.Balance, want.Nonce, want.Balance)
+				}
+			}
+			// A replay must fail the nonce check
+			from, err := state.FetchAccount(alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from.Nonce == tx.Nonce {
+				t.Error("nonce was not incremented")
+			}
+		})
+	}
+}
+
+func TestTxAccountsOrdered(t *testing.T) {
+	alice := &Account{Address: Address("alice")}
+	bob := &Account{Address: Address("bob")}
+	aliceCopy := *alice
+	accs := &TxAccounts{From: alice, To: bob, CommitBeneficiary: &aliceCopy, TxBeneficiary: nil}
+	accs.Dedupe()
+	if accs.CommitBeneficiary != accs.From {
+		t.Error("CommitBeneficiary does not share the From account")
+	}
+	ordered := accs.Ordered()
+	if len(ordered) != 2 || ordered[0] != alice || ordered[1] != bob {
+		t.Errorf("Ordered() = %v, want [alice bob]", ordered)
+	}
+}
+
+func TestCheckBondTx(t *testing.T) {
+	alice := Address("alice")
+	txblock := &BlockHeader{Number: 1, ChainConfig: ChainConfig{Stake: 100, GasLimit: TxGas}}
+	commitblock := &BlockHeader{Number: 1}
+	tests := []struct {
+		name  string
+		typ   TxType
+		to    Address
+		value uint64
+		from  Account
+		valid bool
+	}{
+		{"bond", BondTx, alice, 0, Account{Stake: 100}, true},
+		{"bond below min stake", BondTx, alice, 0, Account{Stake: 99}, false},
+		{"bond when bonded", BondTx, alice, 0, Account{Stake: 100, Bonded: true}, false},
+		{"bond another account", BondTx, Address("bob"), 0, Account{Stake: 100}, false},
+		{"bond with value", BondTx, alice, 1, Account{Stake: 100}, false},
+		{"unbond", UnbondTx, alice, 0, Account{Bonded: true}, true},
+		{"unbond when unbonded", UnbondTx, alice, 0, Account{Stake: 100}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := tt.from
+			from.Address = alice
+			tx := &Transaction{Type: tt.typ, From: alice, To: tt.to, Value: tt.value, CommitBlock: 1, GasLimit: TxGas, Signature: make([]byte, fastsig.SignatureSize)}
+			if got := CheckTx(tx, &from, txblock, commitblock, true); got != tt.valid {
+				t.Fatalf("CheckTx() = %v, want %v", got, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			accs := &TxAccounts{From: &from, To: &from}
+			_, err := ApplyTx(tx, accs, TxGas, txblock, commitblock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from.Bonded != (tt.typ == BondTx) {
+				t.Errorf("Bonded = %v after %s", from.Bonded, tt.name)
+			}
+		})
+	}
+}
+
+func TestAddBondedAddress(t *testing.T) {
+	state := NewStateOverlay(memState{}, Root("root"))
+	for _, addr := range []string{"carol", "alice", "carol", "bob"} {
+		err := AddBondedAddress(state, Address(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := state.FetchStorage(ZeroAddress, BondedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bonded, err := decodeAddresses(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"alice", "bob", "carol"}
+	if len(bonded) != len(want) {
+		t.Fatalf("bonded = %q, want %q", bonded, want)
+	}
+	for i, addr := range bonded {
+		if string(addr) != want[i] {
+			t.Errorf("bonded[%d] = %q, want %q", i, addr, want[i])
+		}
+	}
+}
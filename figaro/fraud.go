@@ -0,0 +1,485 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/figaro-tech/go-fig-buf"
+)
+
+// ErrInvalidFraudProofData is a self-explantory error.
+var ErrInvalidFraudProofData = errors.New("figaro fraud: invalid FraudProof data")
+
+// FraudType is the kind of fraud proven by a FraudProof.
+type FraudType byte
+
+const (
+	// FraudTxSignature proves that a block contains a transaction with an invalid signature.
+	FraudTxSignature FraudType = iota
+	// FraudStateRoot proves that a block applied a transaction with the wrong state transition.
+	FraudStateRoot
+	// FraudCommitsRoot proves that the CommitsRoot of a block archives a commit that cannot be in a
+	// block, either because it is not a TxHash or because it exceeds MaxCommitSize.
+	FraudCommitsRoot
+	// FraudTransactionsRoot proves that the TransactionsRoot of a block archives a transaction that
+	// cannot be in the block, either because it exceeds MaxTxSize or because it was committed in the
+	// block itself or later.
+	FraudTransactionsRoot
+	// FraudReceiptsRoot proves that the ReceiptsRoot of a block archives a receipt that disagrees with
+	// the block, or with the state root of the receipt before it.
+	FraudReceiptsRoot
+)
+
+// FraudProof is portable evidence that a signed block is invalid. It carries Merkle proofs for
+// everything it references, so it can be verified against block headers alone, without state.
+type FraudProof struct {
+	Type   FraudType
+	Header *BlockHeader
+	// Index is the index of the Tx, Commit or Receipt in the block.
+	Index uint64
+
+	Tx           *Transaction
+	TxProof      [][]byte
+	Commit       Commit
+	CommitProof  [][]byte
+	Receipt      *Receipt
+	ReceiptProof [][]byte
+
+	// PrevReceipt is the receipt at Index-1, or ParentHeader when Index is 0, for FraudReceiptsRoot.
+	PrevReceipt      *Receipt
+	PrevReceiptProof [][]byte
+	ParentHeader     *BlockHeader
+
+	// CommitHeader is the header of the block that Tx was committed in, for FraudStateRoot.
+	// If TxCommitProof is set, it proves the commit at TxCommitIndex in CommitHeader.
+	CommitHeader  *BlockHeader
+	TxCommitIndex uint64
+	TxCommitProof [][]byte
+
+	// PreAccounts are proven in the Receipt PrevStateRoot, and PostAccounts in the Receipt StateRoot,
+	// for every account touched by Tx, for FraudStateRoot.
+	PreAccounts  []AccountProof
+	PostAccounts []AccountProof
+}
+
+// FraudLDataService can validate the Merkle proofs carried by a FraudProof.
+type FraudLDataService interface {
+	CommitLDataService
+	TransactionLDataService
+	ReceiptLDataService
+	AccountLDataService
+}
+
+// Verify returns whether the fraud proof proves that Header is an invalid block. Header must be
+// signed by its producer. For FraudStateRoot, the caller must also check that CommitHeader is the
+// canonical block at the Tx CommitBlock.
+func (fp *FraudProof) Verify(db FraudLDataService) bool {
+	if !validHeader(fp.Header) {
+		return false
+	}
+	index := int(fp.Index)
+	switch fp.Type {
+	case FraudTxSignature:
+		if !fp.validTx(db) {
+			return false
+		}
+		return !fp.Tx.VerifySignature()
+	case FraudStateRoot:
+		return fp.verifyStateRoot(db)
+	case FraudCommitsRoot:
+		if !db.ValidateCommit(fp.Header.CommitsRoot, index, fp.Commit, fp.CommitProof) {
+			return false
+		}
+		return fp.Index >= MaxCommitSize || !TxHash(fp.Commit).Valid()
+	case FraudTransactionsRoot:
+		if !fp.validTx(db) {
+			return false
+		}
+		return fp.Index >= MaxTxSize || fp.Tx.CommitBlock >= fp.Header.Number
+	case FraudReceiptsRoot:
+		if !fp.validReceipt(db) {
+			return false
+		}
+		if uint64(fp.Receipt.Index) != fp.Index || fp.Receipt.BlockNum != fp.Header.Number {
+			return true
+		}
+		if fp.Index > 0 && fp.PrevReceipt != nil {
+			if !db.ValidateReceipt(fp.Header.ReceiptsRoot, index-1, *fp.PrevReceipt, fp.PrevReceiptProof) {
+				return false
+			}
+			return !bytes.Equal(fp.PrevReceipt.StateRoot, fp.Receipt.PrevStateRoot)
+		}
+		if fp.Index == 0 && fp.ParentHeader != nil {
+			id, err := fp.ParentHeader.ToHash()
+			if err != nil || !bytes.Equal(id, fp.Header.ParentBlock) {
+				return false
+			}
+			return !bytes.Equal(fp.ParentHeader.StateRoot, fp.Receipt.PrevStateRoot)
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// verifyStateRoot re-applies Tx to the proven PreAccounts, and returns whether the result disagrees with
+// the proven PostAccounts or the Receipt. If the commit is not proven, the Tx is applied both with and
+// without its commit, and the proof only holds if neither agrees.
+func (fp *FraudProof) verifyStateRoot(db FraudLDataService) bool {
+	if !fp.validTx(db) || !fp.validReceipt(db) || !validHeader(fp.CommitHeader) {
+		return false
+	}
+	committed := []bool{true, false}
+	if fp.TxCommitProof != nil {
+		if !db.ValidateCommit(fp.CommitHeader.CommitsRoot, int(fp.TxCommitIndex), Commit(fp.Tx.ID), fp.TxCommitProof) {
+			return false
+		}
+		committed = committed[:1]
+	}
+	pre, ok := validAccounts(db, fp.Receipt.PrevStateRoot, fp.PreAccounts)
+	if !ok {
+		return false
+	}
+	post, ok := validAccounts(db, fp.Receipt.StateRoot, fp.PostAccounts)
+	if !ok {
+		return false
+	}
+	txblock := *fp.Header
+	txblock.StateRoot = fp.Receipt.PrevStateRoot
+	for _, c := range committed {
+		// Every attempt starts from fresh copies, shared per address as when the tx is processed
+		accs := &TxAccounts{From: pre.get(fp.Tx.From), To: pre.get(fp.Tx.To)}
+		if !fp.CommitHeader.Beneficiary.IsZeroAddress() {
+			accs.CommitBeneficiary = pre.get(fp.CommitHeader.Beneficiary)
+		}
+		if !txblock.Beneficiary.IsZeroAddress() {
+			accs.TxBeneficiary = pre.get(txblock.Beneficiary)
+		}
+		if accs.From == nil || accs.To == nil ||
+			(!fp.CommitHeader.Beneficiary.IsZeroAddress() && accs.CommitBeneficiary == nil) ||
+			(!txblock.Beneficiary.IsZeroAddress() && accs.TxBeneficiary == nil) {
+			// Incomplete proof
+			return false
+		}
+		accs.Dedupe()
+		valid := CheckTx(fp.Tx, accs.From, &txblock, fp.CommitHeader, c)
+		if valid && (RunsCode(fp.Tx, accs.To) || fp.Tx.Type == BondTx) {
+			// Contract execution depends on storage, and bonding writes the bonded list to storage,
+			// which account proofs do not prove
+			return false
+		}
+		var fees, gasUsed uint64
+		if valid {
+			var err error
+			gasUsed = IntrinsicGas(fp.Tx)
+			fees, err = ApplyTx(fp.Tx, accs, gasUsed, &txblock, fp.CommitHeader)
+			if err != nil {
+				return false
+			}
+		} else {
+			gasUsed = InvalidTxGas(fp.Tx)
+			fees = ApplyInvalidTx(fp.Tx, accs, gasUsed, &txblock, fp.CommitHeader)
+		}
+		match, complete := post.matches(accs.Ordered())
+		if !complete {
+			return false
+		}
+		if match && valid == fp.Receipt.Success && fees == fp.Receipt.TotalFees && gasUsed == fp.Receipt.GasUsed {
+			return false
+		}
+	}
+	return true
+}
+
+// validTx returns whether Tx is proven at Index in the Header TransactionsRoot, setting its ID.
+func (fp *FraudProof) validTx(db FraudLDataService) bool {
+	if fp.Tx == nil || !db.ValidateTransaction(fp.Header.TransactionsRoot, int(fp.Index), *fp.Tx, fp.TxProof) {
+		return false
+	}
+	id, err := fp.Tx.ToHash()
+	if err != nil {
+		return false
+	}
+	fp.Tx.ID = id
+	return true
+}
+
+// validReceipt returns whether Receipt is proven at Index in the Header ReceiptsRoot.
+func (fp *FraudProof) validReceipt(db FraudLDataService) bool {
+	return fp.Receipt != nil && db.ValidateReceipt(fp.Header.ReceiptsRoot, int(fp.Index), *fp.Receipt, fp.ReceiptProof)
+}
+
+// validHeader returns whether a header is signed by its producer.
+func validHeader(header *BlockHeader) bool {
+	if header == nil {
+		return false
+	}
+	id, err := header.ToHash()
+	if err != nil || !bytes.Equal(id, header.ID) {
+		return false
+	}
+	return header.VerifySignature()
+}
+
+// provenAccounts are accounts proven in a state root, by address.
+type provenAccounts map[string]*Account
+
+// validAccounts validates every account proof in root.
+func validAccounts(db FraudLDataService, root Root, proofs []AccountProof) (provenAccounts, bool) {
+	accounts := make(provenAccounts)
+	for _, ap := range proofs {
+		if ap.Account == nil || !db.ValidateAccount(root, ap.Account, ap.Proof) {
+			return nil, false
+		}
+		accounts[string(ap.Account.Address)] = ap.Account
+	}
+	return accounts, true
+}
+
+// get returns a copy of the account at address, or nil if it is not proven.
+func (pa provenAccounts) get(address Address) *Account {
+	acc, ok := pa[string(address)]
+	if !ok {
+		return nil
+	}
+	cp := *acc
+	return &cp
+}
+
+// matches returns whether the accounts, saved in order, agree with the proven accounts, and
+// whether every one of them is proven at all.
+func (pa provenAccounts) matches(ordered []*Account) (match bool, complete bool) {
+	final := make(map[string]*Account)
+	for _, acc := range ordered {
+		final[string(acc.Address)] = acc
+	}
+	match = true
+	for addr, acc := range final {
+		proven, ok := pa[addr]
+		if !ok {
+			return false, false
+		}
+		a, err := acc.Encode()
+		if err != nil {
+			return false, false
+		}
+		b, err := proven.Encode()
+		if err != nil {
+			return false, false
+		}
+		if !bytes.Equal(a, b) {
+			match = false
+		}
+	}
+	return match, true
+}
+
+// Encode deterministically encodes a FraudProof to binary format.
+func (fp FraudProof) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	var err error
+	encodeNext := func(buf []byte, e func() ([]byte, error)) []byte {
+		b, eerr := e()
+		if eerr != nil {
+			err = eerr
+		}
+		return enc.EncodeNextBytes(buf, b)
+	}
+	encodeNextProof := func(buf []byte, proof [][]byte) []byte {
+		return enc.EncodeNextList(buf, func(buf []byte) []byte {
+			for _, p := range proof {
+				buf = enc.EncodeNextBytes(buf, p)
+			}
+			return buf
+		})
+	}
+	encodeNextAccounts := func(buf []byte, aps []AccountProof) []byte {
+		return enc.EncodeNextList(buf, func(buf []byte) []byte {
+			for _, ap := range aps {
+				buf = enc.EncodeNextList(buf, func(buf []byte) []byte {
+					buf = enc.EncodeNextBytes(buf, ap.Account.Address)
+					buf = encodeNext(buf, ap.Account.Encode)
+					return enc.EncodeNextList(buf, func(buf []byte) []byte {
+						for _, p := range ap.Proof {
+							buf = encodeNextProof(buf, p)
+						}
+						return buf
+					})
+				})
+			}
+			return buf
+		})
+	}
+
+	b, eerr := enc.EncodeList(func(buf []byte) []byte {
+		buf = enc.EncodeNextUint8(buf, uint8(fp.Type))
+		buf = encodeNext(buf, encodeHeader(fp.Header))
+		buf = enc.EncodeNextUint64(buf, fp.Index)
+		buf = encodeNext(buf, func() ([]byte, error) {
+			if fp.Tx == nil {
+				return nil, nil
+			}
+			return fp.Tx.Encode()
+		})
+		buf = encodeNextProof(buf, fp.TxProof)
+		buf = enc.EncodeNextBytes(buf, fp.Commit)
+		buf = encodeNextProof(buf, fp.CommitProof)
+		buf = encodeNext(buf, encodeReceipt(fp.Receipt))
+		buf = encodeNextProof(buf, fp.ReceiptProof)
+		buf = encodeNext(buf, encodeReceipt(fp.PrevReceipt))
+		buf = encodeNextProof(buf, fp.PrevReceiptProof)
+		buf = encodeNext(buf, encodeHeader(fp.ParentHeader))
+		buf = encodeNext(buf, encodeHeader(fp.CommitHeader))
+		buf = enc.EncodeNextUint64(buf, fp.TxCommitIndex)
+		buf = encodeNextProof(buf, fp.TxCommitProof)
+		buf = encodeNextAccounts(buf, fp.PreAccounts)
+		buf = encodeNextAccounts(buf, fp.PostAccounts)
+		return buf
+	})
+	if eerr != nil {
+		return nil, eerr
+	}
+	return b, err
+}
+
+// Decode decodes a deterministically encoded FraudProof from binary format.
+func (fp *FraudProof) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	var err error
+	decodeNextProof := func(r []byte) ([][]byte, []byte) {
+		var proof [][]byte
+		r = dec.DecodeNextList(r, func(r []byte) []byte {
+			var p []byte
+			for len(r) > 0 {
+				p, r = dec.DecodeNextBytes(r)
+				proof = append(proof, p)
+			}
+			return r
+		})
+		return proof, r
+	}
+	decodeNextAccounts := func(r []byte) ([]AccountProof, []byte) {
+		var aps []AccountProof
+		r = dec.DecodeNextList(r, func(r []byte) []byte {
+			for len(r) > 0 {
+				ap := AccountProof{Account: &Account{}}
+				r = dec.DecodeNextList(r, func(r []byte) []byte {
+					var addr, b []byte
+					addr, r = dec.DecodeNextBytes(r)
+					b, r = dec.DecodeNextBytes(r)
+					if derr := ap.Account.Decode(b); derr != nil {
+						err = derr
+					}
+					ap.Account.Address = addr
+					return dec.DecodeNextList(r, func(r []byte) []byte {
+						var p [][]byte
+						for len(r) > 0 {
+							p, r = decodeNextProof(r)
+							ap.Proof = append(ap.Proof, p)
+						}
+						return r
+					})
+				})
+				aps = append(aps, ap)
+			}
+			return r
+		})
+		return aps, r
+	}
+
+	derr := dec.DecodeList(buf, func(r []byte) []byte {
+		var t uint8
+		var b []byte
+		t, r = dec.DecodeNextUint8(r)
+		fp.Type = FraudType(t)
+		b, r = dec.DecodeNextBytes(r)
+		fp.Header, err = decodeHeader(b, err)
+		fp.Index, r = dec.DecodeNextUint64(r)
+		b, r = dec.DecodeNextBytes(r)
+		if len(b) > 0 {
+			fp.Tx = &Transaction{}
+			if terr := fp.Tx.Decode(b); terr != nil {
+				err = terr
+			}
+		}
+		fp.TxProof, r = decodeNextProof(r)
+		fp.Commit, r = dec.DecodeNextBytes(r)
+		fp.CommitProof, r = decodeNextProof(r)
+		b, r = dec.DecodeNextBytes(r)
+		fp.Receipt, err = decodeReceipt(b, err)
+		fp.ReceiptProof, r = decodeNextProof(r)
+		b, r = dec.DecodeNextBytes(r)
+		fp.PrevReceipt, err = decodeReceipt(b, err)
+		fp.PrevReceiptProof, r = decodeNextProof(r)
+		b, r = dec.DecodeNextBytes(r)
+		fp.ParentHeader, err = decodeHeader(b, err)
+		b, r = dec.DecodeNextBytes(r)
+		fp.CommitHeader, err = decodeHeader(b, err)
+		fp.TxCommitIndex, r = dec.DecodeNextUint64(r)
+		fp.TxCommitProof, r = decodeNextProof(r)
+		fp.PreAccounts, r = decodeNextAccounts(r)
+		fp.PostAccounts, r = decodeNextAccounts(r)
+		return r
+	})
+	if derr != nil {
+		return derr
+	}
+	if err != nil {
+		return ErrInvalidFraudProofData
+	}
+	return nil
+}
+
+// encodeHeader encodes an optional header.
+func encodeHeader(header *BlockHeader) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if header == nil {
+			return nil, nil
+		}
+		return header.Encode()
+	}
+}
+
+// decodeHeader decodes an optional header, deriving its ID, and keeping the first error.
+func decodeHeader(b []byte, err error) (*BlockHeader, error) {
+	if len(b) == 0 {
+		return nil, err
+	}
+	header := &BlockHeader{}
+	derr := header.Decode(b)
+	if derr == nil {
+		header.ID, derr = header.ToHash()
+	}
+	if err == nil {
+		err = derr
+	}
+	return header, err
+}
+
+// encodeReceipt encodes an optional receipt.
+func encodeReceipt(r *Receipt) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if r == nil {
+			return nil, nil
+		}
+		return r.Encode()
+	}
+}
+
+// decodeReceipt decodes an optional receipt, keeping the first error.
+func decodeReceipt(b []byte, err error) (*Receipt, error) {
+	if len(b) == 0 {
+		return nil, err
+	}
+	r := &Receipt{}
+	derr := r.Decode(b)
+	if err == nil {
+		err = derr
+	}
+	return r, err
+}
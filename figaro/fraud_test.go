@@ -0,0 +1,397 @@
+package figaro
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+)
+
+// proofArchive is a FraudLDataService over in-memory archives, keyed by root. A proof is valid
+// if the item is the one archived at its index, so the tests can forge any archive.
+type proofArchive struct {
+	commits  map[string][]Commit
+	txs      map[string][]*Transaction
+	receipts map[string][]*Receipt
+	accounts map[string]memState
+}
+
+func newProofArchive() *proofArchive {
+	return &proofArchive{
+		commits:  make(map[string][]Commit),
+		txs:      make(map[string][]*Transaction),
+		receipts: make(map[string][]*Receipt),
+		accounts: make(map[string]memState),
+	}
+}
+
+func (pa *proofArchive) RetrieveCommits(root Root) ([]Commit, error) {
+	return pa.commits[string(root)], nil
+}
+
+func (pa *proofArchive) GetCommit(root Root, index int) (Commit, error) {
+	return pa.commits[string(root)][index], nil
+}
+
+func (pa *proofArchive) GetAndProveCommit(root Root, index int) (Commit, [][]byte, error) {
+	return pa.commits[string(root)][index], nil, nil
+}
+
+func (pa *proofArchive) ValidateCommit(root Root, index int, commit Commit, proof [][]byte) bool {
+	commits := pa.commits[string(root)]
+	return index >= 0 && index < len(commits) && bytes.Equal(commits[index], commit)
+}
+
+func (pa *proofArchive) RetrieveTransactions(root Root) ([]*Transaction, error) {
+	return pa.txs[string(root)], nil
+}
+
+func (pa *proofArchive) GetTransaction(root Root, index int) (*Transaction, error) {
+	return pa.txs[string(root)][index], nil
+}
+
+func (pa *proofArchive) GetAndProveTransaction(root Root, index int) (*Transaction, [][]byte, error) {
+	return pa.txs[string(root)][index], nil, nil
+}
+
+func (pa *proofArchive) ValidateTransaction(root Root, index int, tx Transaction, proof [][]byte) bool {
+	txs := pa.txs[string(root)]
+	return index >= 0 && index < len(txs) && sameEncoding(*txs[index], tx)
+}
+
+func (pa *proofArchive) FetchReceipt(txid TxHash) (*Receipt, error) {
+	return nil, nil
+}
+
+func (pa *proofArchive) GetAndProveReceipt(root Root, index int) (*Receipt, [][]byte, error) {
+	return pa.receipts[string(root)][index], nil, nil
+}
+
+func (pa *proofArchive) ValidateReceipt(root Root, index int, receipt Receipt, proof [][]byte) bool {
+	receipts := pa.receipts[string(root)]
+	return index >= 0 && index < len(receipts) && sameEncoding(*receipts[index], receipt)
+}
+
+func (pa *proofArchive) FetchAccount(root Root, address Address) (*Account, error) {
+	return pa.accounts[string(root)].FetchAccount(root, address)
+}
+
+func (pa *proofArchive) ProveAccount(root Root, address Address) (*Account, [][][]byte, error) {
+	return pa.accounts[string(root)].ProveAccount(root, address)
+}
+
+func (pa *proofArchive) ValidateAccount(root Root, account *Account, proof [][][]byte) bool {
+	acc, ok := pa.accounts[string(root)][string(account.Address)]
+	return ok && sameEncoding(*acc, *account)
+}
+
+func (pa *proofArchive) FetchAccountStorage(account *Account, key []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (pa *proofArchive) ProveAccountStorage(account *Account, key []byte) ([]byte, [][][]byte, error) {
+	return nil, nil, nil
+}
+
+func (pa *proofArchive) ValidateAccountStorage(account *Account, key, data []byte, proof [][][]byte) bool {
+	return false
+}
+
+// sameEncoding returns whether a and b encode to the same bytes.
+func sameEncoding(a, b interface{ Encode() ([]byte, error) }) bool {
+	ea, err := a.Encode()
+	if err != nil {
+		return false
+	}
+	eb, err := b.Encode()
+	return err == nil && bytes.Equal(ea, eb)
+}
+
+// signedHeader returns a header at number, signed by a new producer, with the archive roots
+// named after the block.
+func signedHeader(t *testing.T, number uint64, parent BlockHash) *BlockHeader {
+	pubkey, privkey, err := fastsig.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := string(rune('a' + number))
+	header := &BlockHeader{
+		Producer:         pubkey,
+		Number:           number,
+		ParentBlock:      parent,
+		StateRoot:        Root(name + "/state"),
+		CommitsRoot:      Root(name + "/commits"),
+		TransactionsRoot: Root(name + "/txs"),
+		ReceiptsRoot:     Root(name + "/receipts"),
+		ChainConfig:      ChainConfig{GasLimit: 10 * TxGas},
+	}
+	header.ID, err = header.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = header.Sign(privkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// signedTx returns a BalanceTx of value, committed in commitblock, signed by a new sender.
+func signedTx(t *testing.T, commitblock, value uint64) *Transaction {
+	from, privkey, err := fastsig.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transaction{
+		Type:        BalanceTx,
+		From:        from,
+		To:          poolAddress(0xff),
+		CommitBlock: commitblock,
+		Value:       value,
+		GasLimit:    TxGas,
+	}
+	tx.ID, err = tx.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Sign(privkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// verifyFraud checks Verify on fp, and on fp once encoded and decoded.
+func verifyFraud(t *testing.T, db FraudLDataService, fp *FraudProof, want bool) {
+	t.Helper()
+	if got := fp.Verify(db); got != want {
+		t.Errorf("Verify() = %v, want %v", got, want)
+	}
+	b, err := fp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &FraudProof{}
+	err = decoded.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Verify(db); got != want {
+		t.Errorf("Verify() of the decoded proof = %v, want %v", got, want)
+	}
+}
+
+func TestFraudTxSignature(t *testing.T) {
+	db := newProofArchive()
+	header := signedHeader(t, 2, nil)
+	honest := signedTx(t, 1, 1)
+	forged := signedTx(t, 1, 1)
+	forged.Signature = honest.Signature
+	db.txs[string(header.TransactionsRoot)] = []*Transaction{honest, forged}
+
+	t.Run("fraud", func(t *testing.T) {
+		fp := &FraudProof{Type: FraudTxSignature, Header: header, Index: 1, Tx: forged}
+		verifyFraud(t, db, fp, true)
+	})
+	t.Run("honest", func(t *testing.T) {
+		fp := &FraudProof{Type: FraudTxSignature, Header: header, Index: 0, Tx: honest}
+		verifyFraud(t, db, fp, false)
+	})
+	t.Run("unproven", func(t *testing.T) {
+		fp := &FraudProof{Type: FraudTxSignature, Header: header, Index: 0, Tx: forged}
+		verifyFraud(t, db, fp, false)
+	})
+}
+
+func TestFraudStateRoot(t *testing.T) {
+	db := newProofArchive()
+	commitblock := signedHeader(t, 1, nil)
+	header := signedHeader(t, 2, commitblock.ID)
+	tx := signedTx(t, commitblock.Number, 10)
+	db.commits[string(commitblock.CommitsRoot)] = []Commit{Commit(tx.ID)}
+	db.txs[string(header.TransactionsRoot)] = []*Transaction{tx}
+
+	pre := memState{
+		string(tx.From): {Address: tx.From, Balance: 100},
+		string(tx.To):   {Address: tx.To},
+	}
+	// The honest post state is the pre state with the tx applied
+	post := memState{
+		string(tx.From): {Address: tx.From, Balance: 90, Nonce: 1},
+		string(tx.To):   {Address: tx.To, Balance: 10},
+	}
+	// The fraudulent post state credits the receiver with more than the tx value
+	forged := memState{
+		string(tx.From): {Address: tx.From, Balance: 90, Nonce: 1},
+		string(tx.To):   {Address: tx.To, Balance: 1000},
+	}
+	db.accounts["pre"] = pre
+	db.accounts["post"] = post
+	db.accounts["forged"] = forged
+
+	proofs := func(state memState) []AccountProof {
+		var aps []AccountProof
+		for _, acc := range state {
+			aps = append(aps, AccountProof{Account: acc})
+		}
+		return aps
+	}
+	proof := func(stateRoot string) *FraudProof {
+		receipt := &Receipt{
+			TxID:          tx.ID,
+			BlockNum:      header.Number,
+			PrevStateRoot: Root("pre"),
+			StateRoot:     Root(stateRoot),
+			GasUsed:       IntrinsicGas(tx),
+			Success:       true,
+		}
+		db.receipts[string(header.ReceiptsRoot)] = []*Receipt{receipt}
+		return &FraudProof{
+			Type:          FraudStateRoot,
+			Header:        header,
+			Tx:            tx,
+			Receipt:       receipt,
+			CommitHeader:  commitblock,
+			TxCommitProof: [][]byte{},
+			PreAccounts:   proofs(pre),
+			PostAccounts:  proofs(db.accounts[stateRoot]),
+		}
+	}
+
+	t.Run("fraud", func(t *testing.T) {
+		verifyFraud(t, db, proof("forged"), true)
+	})
+	t.Run("honest", func(t *testing.T) {
+		verifyFraud(t, db, proof("post"), false)
+	})
+	t.Run("incomplete", func(t *testing.T) {
+		fp := proof("forged")
+		fp.PostAccounts = fp.PostAccounts[:1]
+		if fp.Verify(db) {
+			t.Error("Verify() = true for a proof missing a touched account")
+		}
+	})
+}
+
+func TestFraudCommitsRoot(t *testing.T) {
+	db := newProofArchive()
+	header := signedHeader(t, 2, nil)
+	tx := signedTx(t, 1, 1)
+	db.commits[string(header.CommitsRoot)] = []Commit{Commit(tx.ID), Commit("not a tx hash")}
+
+	t.Run("fraud", func(t *testing.T) {
+		fp := &FraudProof{Type: FraudCommitsRoot, Header: header, Index: 1, Commit: Commit("not a tx hash")}
+		verifyFraud(t, db, fp, true)
+	})
+	t.Run("honest", func(t *testing.T) {
+		fp := &FraudProof{Type: FraudCommitsRoot, Header: header, Index: 0, Commit: Commit(tx.ID)}
+		verifyFraud(t, db, fp, false)
+	})
+	t.Run("refused", func(t *testing.T) {
+		// The chain must never accept a block that the proof above proves fraudulent
+		bl := &Block{BlockHeader: header, Commits: db.commits[string(header.CommitsRoot)]}
+		if bl.ValidContents() {
+			t.Error("ValidContents() = true for a block with an invalid commit")
+		}
+		bl.Commits = bl.Commits[:1]
+		if !bl.ValidContents() {
+			t.Error("ValidContents() = false for an honest block")
+		}
+	})
+}
+
+func TestFraudTransactionsRoot(t *testing.T) {
+	db := newProofArchive()
+	header := signedHeader(t, 2, nil)
+	honest := signedTx(t, 1, 1)
+	// A tx cannot be committed in the block that includes it
+	early := signedTx(t, 2, 1)
+	db.txs[string(header.TransactionsRoot)] = []*Transaction{honest, early}
+
+	t.Run("fraud", func(t *testing.T) {
+		fp := &FraudProof{Type: FraudTransactionsRoot, Header: header, Index: 1, Tx: early}
+		verifyFraud(t, db, fp, true)
+	})
+	t.Run("honest", func(t *testing.T) {
+		fp := &FraudProof{Type: FraudTransactionsRoot, Header: header, Index: 0, Tx: honest}
+		verifyFraud(t, db, fp, false)
+	})
+	t.Run("refused", func(t *testing.T) {
+		// The chain must never accept a block that the proof above proves fraudulent
+		bl := &Block{BlockHeader: header, Transactions: db.txs[string(header.TransactionsRoot)]}
+		if bl.ValidContents() {
+			t.Error("ValidContents() = true for a block with a tx committed in the block")
+		}
+		bl.Transactions = bl.Transactions[:1]
+		if !bl.ValidContents() {
+			t.Error("ValidContents() = false for an honest block")
+		}
+	})
+}
+
+func TestFraudReceiptsRoot(t *testing.T) {
+	db := newProofArchive()
+	parent := signedHeader(t, 1, nil)
+	header := signedHeader(t, 2, parent.ID)
+	first := &Receipt{BlockNum: 2, Index: 0, PrevStateRoot: parent.StateRoot, StateRoot: Root("s1")}
+	second := &Receipt{BlockNum: 2, Index: 1, PrevStateRoot: Root("s1"), StateRoot: Root("s2")}
+	// The third receipt does not start from the state the second one ended in
+	third := &Receipt{BlockNum: 2, Index: 2, PrevStateRoot: Root("s1"), StateRoot: Root("s3")}
+	// The fourth receipt claims another block
+	fourth := &Receipt{BlockNum: 3, Index: 3, PrevStateRoot: Root("s3"), StateRoot: Root("s4")}
+	db.receipts[string(header.ReceiptsRoot)] = []*Receipt{first, second, third, fourth}
+
+	tests := []struct {
+		name  string
+		fp    *FraudProof
+		fraud bool
+	}{
+		{"honest first", &FraudProof{Index: 0, Receipt: first, ParentHeader: parent}, false},
+		{"honest next", &FraudProof{Index: 1, Receipt: second, PrevReceipt: first}, false},
+		{"broken state chain", &FraudProof{Index: 2, Receipt: third, PrevReceipt: second}, true},
+		{"wrong block", &FraudProof{Index: 3, Receipt: fourth}, true},
+		{"unproven previous receipt", &FraudProof{Index: 2, Receipt: third, PrevReceipt: first}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fp.Type = FraudReceiptsRoot
+			tt.fp.Header = header
+			verifyFraud(t, db, tt.fp, tt.fraud)
+		})
+	}
+}
+
+func TestFraudProofDecodeInvalid(t *testing.T) {
+	header := signedHeader(t, 2, nil)
+	tx := signedTx(t, 1, 1)
+	fp := FraudProof{
+		Type:         FraudStateRoot,
+		Header:       header,
+		Tx:           tx,
+		TxProof:      [][]byte{[]byte("proof")},
+		Receipt:      &Receipt{TxID: tx.ID, BlockNum: 2},
+		CommitHeader: header,
+		PreAccounts:  []AccountProof{{Account: &Account{Address: tx.From}, Proof: [][][]byte{{[]byte("node")}}}},
+	}
+	b, err := fp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(b); n++ {
+		err := (&FraudProof{}).Decode(b[:n])
+		if err == nil {
+			t.Fatalf("Decode() of %d of %d bytes returned no error", n, len(b))
+		}
+	}
+	for _, garbage := range [][]byte{
+		nil,
+		[]byte("garbage"),
+		bytes.Repeat([]byte{0xff}, 64),
+		append(append([]byte{}, b...), 0),
+	} {
+		err := (&FraudProof{}).Decode(garbage)
+		if err == nil {
+			t.Errorf("Decode(%x) returned no error", garbage)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import "math"
+
+// Gas costs. These are protocol values, and changing them changes consensus.
+const (
+	// TxGas is the gas used by every transaction.
+	TxGas = 1000
+	// TxDataGas is the gas used per byte of tx Data.
+	TxDataGas = 4
+	// CodeGas is the gas used per byte of Code saved by a DeployTx.
+	CodeGas = 50
+	// StepGas is the gas used per step of contract code.
+	StepGas = 1
+)
+
+// IntrinsicGas returns the gas used by a transaction before any contract code is run.
+func IntrinsicGas(tx *Transaction) uint64 {
+	gas := uint64(TxGas) + uint64(len(tx.Data))*TxDataGas
+	if tx.Type == DeployTx {
+		d, err := decodeDeployData(tx)
+		if err == nil {
+			gas += uint64(len(d.Code)) * CodeGas
+		}
+	}
+	return gas
+}
+
+// InvalidTxGas returns the gas charged to a transaction that is invalid for processing,
+// which is its IntrinsicGas, up to its GasLimit.
+func InvalidTxGas(tx *Transaction) uint64 {
+	gas := IntrinsicGas(tx)
+	if gas > tx.GasLimit {
+		return tx.GasLimit
+	}
+	return gas
+}
+
+// GasFee returns the fee for gas at price, saturating at math.MaxUint64 rather than overflowing.
+func GasFee(gas, price uint64) uint64 {
+	if price != 0 && gas > math.MaxUint64/price {
+		return math.MaxUint64
+	}
+	return gas * price
+}
@@ -0,0 +1,263 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/figaro-tech/go-fig-buf"
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+)
+
+var (
+	// ErrInvalidGenesis is a self-explantory error.
+	ErrInvalidGenesis = errors.New("figaro genesis: invalid genesis spec")
+	// ErrGenesisMismatch is returned when a peer or database has a different genesis block.
+	ErrGenesisMismatch = errors.New("figaro genesis: genesis block mismatch")
+)
+
+// ProducersKey is the storage key of the ZeroAddress account under which
+// the initial block producers are recorded in the genesis state.
+var ProducersKey = hasher.Hash256([]byte("figaro/producers"))
+
+// BondedKey is the storage key of the ZeroAddress account under which the addresses of the
+// bonded accounts are recorded, in address order. Accounts are bonded in the genesis state, and
+// by a BondTx, which adds them to the list. Accounts unbonded since, by an UnbondTx or by
+// slashing, remain listed with Bonded unset, so that bonding again does not grow the list.
+var BondedKey = hasher.Hash256([]byte("figaro/bonded"))
+
+// Genesis is the specification of block 0 of a chain. It is read from JSON,
+// with addresses Base58 encoded and code hex encoded.
+type Genesis struct {
+	Config    ChainConfig      `json:"config"`
+	Timestamp time.Time        `json:"timestamp"`
+	Producers []string         `json:"producers"`
+	Alloc     []GenesisAccount `json:"alloc"`
+}
+
+// GenesisAccount is an initial account allocation in the Genesis.
+type GenesisAccount struct {
+	Address string `json:"address"`
+	Balance uint64 `json:"balance"`
+	Stake   uint64 `json:"stake"`
+	Bonded  bool   `json:"bonded"`
+	Code    string `json:"code,omitempty"`
+}
+
+// ReadGenesis reads and validates a JSON encoded Genesis.
+func ReadGenesis(r io.Reader) (*Genesis, error) {
+	g := &Genesis{}
+	err := json.NewDecoder(r).Decode(g)
+	if err != nil {
+		return nil, err
+	}
+	// A chain must fit at least one plain transaction in a block
+	if g.Config.GasLimit < TxGas {
+		return nil, ErrInvalidGenesis
+	}
+	_, err = g.producers()
+	if err != nil {
+		return nil, err
+	}
+	_, err = g.accounts()
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ToBlock builds the genesis state in db and returns the sealed genesis Block.
+// The Block is not saved.
+func (g *Genesis) ToBlock(db GenesisDataService) (*Block, error) {
+	producers, err := g.producers()
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := g.accounts()
+	if err != nil {
+		return nil, err
+	}
+	var root Root
+	var bonded []Address
+	for _, acc := range accounts {
+		root, err = db.SaveAccount(root, acc)
+		if err != nil {
+			return nil, err
+		}
+		if acc.Bonded {
+			bonded = append(bonded, acc.Address)
+		}
+	}
+	sort.Slice(bonded, func(i, j int) bool { return bytes.Compare(bonded[i], bonded[j]) < 0 })
+	system, err := db.FetchAccount(root, ZeroAddress)
+	if err != nil {
+		return nil, err
+	}
+	root, err = db.SaveAccountStorage(root, system, ProducersKey, encodeAddresses(producers))
+	if err != nil {
+		return nil, err
+	}
+	root, err = db.SaveAccountStorage(root, system, BondedKey, encodeAddresses(bonded))
+	if err != nil {
+		return nil, err
+	}
+	bl := &Block{
+		BlockHeader: &BlockHeader{
+			Number:      0,
+			ChainConfig: g.Config,
+			StateRoot:   root,
+		},
+	}
+	err = bl.Seal(db)
+	if err != nil {
+		return nil, err
+	}
+	// Seal stamps the current time, but the genesis block must hash the same on every node
+	bl.Timestamp = g.Timestamp
+	bl.ID, err = bl.ToHash()
+	if err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+// Chain returns the canonical Chain consisting of only the genesis block.
+func (g *Genesis) Chain(genesis *BlockHeader) *Chain {
+	return &Chain{
+		Depth:       0,
+		Head:        genesis.ID,
+		ChainConfig: g.Config,
+	}
+}
+
+// GenesisProducers returns the initial block producers recorded in the genesis state at root.
+func GenesisProducers(db AccountLDataService, root Root) ([]Address, error) {
+	system, err := db.FetchAccount(root, ZeroAddress)
+	if err != nil {
+		return nil, err
+	}
+	b, err := db.FetchAccountStorage(system, ProducersKey)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAddresses(b)
+}
+
+// BondedAddresses returns the addresses of the accounts that were ever bonded, in address order,
+// as recorded in the world state at root. Callers must check the Bonded flag of each account.
+func BondedAddresses(db AccountLDataService, root Root) ([]Address, error) {
+	system, err := db.FetchAccount(root, ZeroAddress)
+	if err != nil {
+		return nil, err
+	}
+	b, err := db.FetchAccountStorage(system, BondedKey)
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	return decodeAddresses(b)
+}
+
+// AddBondedAddress adds address to the bonded list in state, keeping it in address order, if it
+// is not listed yet.
+func AddBondedAddress(state *StateOverlay, address Address) error {
+	b, err := state.FetchStorage(ZeroAddress, BondedKey)
+	if err != nil {
+		return err
+	}
+	var bonded []Address
+	if len(b) > 0 {
+		bonded, err = decodeAddresses(b)
+		if err != nil {
+			return err
+		}
+	}
+	i := sort.Search(len(bonded), func(i int) bool { return bytes.Compare(bonded[i], address) >= 0 })
+	if i < len(bonded) && bytes.Equal(bonded[i], address) {
+		return nil
+	}
+	bonded = append(bonded, nil)
+	copy(bonded[i+1:], bonded[i:])
+	bonded[i] = address
+	return state.SetStorage(ZeroAddress, BondedKey, encodeAddresses(bonded))
+}
+
+func (g *Genesis) producers() ([]Address, error) {
+	if len(g.Producers) == 0 {
+		return nil, ErrInvalidGenesis
+	}
+	producers := make([]Address, 0, len(g.Producers))
+	for _, p := range g.Producers {
+		addr, err := NewAddressFromHuman(p)
+		if err != nil {
+			return nil, ErrInvalidGenesis
+		}
+		producers = append(producers, *addr)
+	}
+	return producers, nil
+}
+
+func (g *Genesis) accounts() ([]*Account, error) {
+	seen := make(map[string]bool)
+	accounts := make([]*Account, 0, len(g.Alloc))
+	for _, a := range g.Alloc {
+		addr, err := NewAddressFromHuman(a.Address)
+		if err != nil || addr.IsZeroAddress() || seen[string(*addr)] {
+			return nil, ErrInvalidGenesis
+		}
+		seen[string(*addr)] = true
+		code, err := hex.DecodeString(a.Code)
+		if err != nil || len(code) > MaxCodeSize {
+			return nil, ErrInvalidGenesis
+		}
+		accounts = append(accounts, &Account{
+			Address: *addr,
+			Balance: a.Balance,
+			Stake:   a.Stake,
+			Bonded:  a.Bonded,
+			Code:    code,
+		})
+	}
+	return accounts, nil
+}
+
+func encodeAddresses(addresses []Address) []byte {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	b, err := enc.EncodeList(func(buf []byte) []byte {
+		for _, addr := range addresses {
+			buf = enc.EncodeNextBytes(buf, addr)
+		}
+		return buf
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func decodeAddresses(buf []byte) (addresses []Address, err error) {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	err = dec.DecodeList(buf, func(r []byte) []byte {
+		var addr []byte
+		for len(r) > 0 {
+			addr, r = dec.DecodeNextBytes(r)
+			addresses = append(addresses, addr)
+		}
+		return r
+	})
+	return
+}
+
+// GenesisDataService is a data service that can build the genesis state and block.
+type GenesisDataService interface {
+	AccountDataService
+	BlockContentsDataService
+}
@@ -29,7 +29,8 @@ var (
 
 // NewAddressFromHuman is a convenience helper to create an address from a Base58 encoded string.
 func NewAddressFromHuman(humaddr string) (address *Address, err error) {
-	address = &Address{}
+	addr := make(Address, AddressSize)
+	address = &addr
 	err = address.SetHuman(humaddr)
 	return
 }
@@ -51,7 +52,7 @@ func (addr Address) IsZeroAddress() bool {
 }
 
 // String converts to a string.
-func (addr Address) String() string { return fmt.Sprintf("%#x", addr) }
+func (addr Address) String() string { return fmt.Sprintf("%#x", []byte(addr)) }
 
 // Human converts to a Base58 encoded string.
 func (addr Address) Human() string { return fastsig.ToHumanAddress(addr) }
@@ -92,7 +93,7 @@ func (root Root) Valid() bool {
 }
 
 // String converts to a string.
-func (root Root) String() string { return fmt.Sprintf("%#x", root) }
+func (root Root) String() string { return fmt.Sprintf("%#x", []byte(root)) }
 
 // Hex converts to a hex encoded string.
 func (root Root) Hex() string { return hex.EncodeToString(root) }
@@ -119,7 +120,7 @@ func (bh BlockHash) Valid() bool {
 }
 
 // String converts to a string.
-func (bh BlockHash) String() string { return fmt.Sprintf("%#x", bh) }
+func (bh BlockHash) String() string { return fmt.Sprintf("%#x", []byte(bh)) }
 
 // Hex converts to a hex encoded string.
 func (bh BlockHash) Hex() string { return hex.EncodeToString(bh) }
@@ -146,7 +147,7 @@ func (txhash TxHash) Valid() bool {
 }
 
 // String converts to a string.
-func (txhash TxHash) String() string { return fmt.Sprintf("%#x", txhash) }
+func (txhash TxHash) String() string { return fmt.Sprintf("%#x", []byte(txhash)) }
 
 // Hex converts to a hex encoded string.
 func (txhash TxHash) Hex() string { return hex.EncodeToString(txhash) }
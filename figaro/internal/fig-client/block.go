@@ -1 +1,33 @@
 package internal
+
+import (
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// PollInterval is how often the client polls the node for new blocks.
+var PollInterval = 2 * time.Second
+
+// WaitChain blocks until the canonical chain of the node reaches depth, returning the chain.
+func WaitChain(c *Client, depth uint64) (*figaro.Chain, error) {
+	for {
+		chain, err := c.FetchChain()
+		if err != nil {
+			return nil, err
+		}
+		if chain.Depth >= depth {
+			return chain, nil
+		}
+		time.Sleep(PollInterval)
+	}
+}
+
+// FetchChainBlock fetches the Block at index in the canonical chain of the node.
+func FetchChainBlock(c *Client, index uint64) (*figaro.Block, error) {
+	bhash, err := c.FetchChainBlock(index)
+	if err != nil {
+		return nil, err
+	}
+	return c.FetchBlock(bhash)
+}
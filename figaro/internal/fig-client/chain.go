@@ -6,11 +6,11 @@ import (
 	"reflect"
 
 	"github.com/figaro-tech/go-figaro/figaro"
-	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
 )
 
-// HandleReceiveBlock handles validating and syncing a new block created from the network
-func HandleReceiveBlock(db *figdb.DB, chain *figaro.Chain, block *figaro.BlockHeader, futureblocks *figaro.BlockHeap, engine figaro.ConsensusEngine) error {
+// HandleReceiveBlock handles validating and syncing a new block header received from the network.
+// Only headers are kept, so any data service that can save and fetch headers will do.
+func HandleReceiveBlock(db figaro.FullDataService, chain *figaro.Chain, block *figaro.BlockHeader, futureblocks *figaro.BlockHeap, engine figaro.ConsensusEngine) error {
 	if !block.VerifySignature() {
 		return figaro.ErrInvalidBlock
 	}
@@ -23,9 +23,17 @@ func HandleReceiveBlock(db *figdb.DB, chain *figaro.Chain, block *figaro.BlockHe
 	if block.Number != chain.Depth+1 {
 		return figaro.ErrInvalidBlock
 	}
-	if reflect.DeepEqual(block.ChainConfig, chain.ChainConfig) {
+	if !reflect.DeepEqual(block.ChainConfig, chain.ChainConfig) {
 		return figaro.ErrInvalidBlock
 	}
+	if !bytes.Equal(block.ParentBlock, chain.Head) {
+		var err error
+		chain, block, futureblocks, err = engine.ChainReorg(db, chain, block, futureblocks)
+		if err != nil {
+			return err
+		}
+	}
+	// The producer is checked against the parent, which after a reorg is the fork ancestor
 	next, err := engine.NextBlockProducer(db, chain.Head)
 	if err != nil {
 		return err
@@ -33,11 +41,9 @@ func HandleReceiveBlock(db *figdb.DB, chain *figaro.Chain, block *figaro.BlockHe
 	if !bytes.Equal(block.Producer, next) {
 		return figaro.ErrInvalidBlock
 	}
-	if !bytes.Equal(block.ParentBlock, chain.Head) {
-		chain, block, futureblocks, err = engine.ChainReorg(db, chain, block, futureblocks)
-		if err != nil {
-			return err
-		}
+	err = db.SaveBlock(&figaro.Block{BlockHeader: block})
+	if err != nil {
+		return err
 	}
 	err = chain.AppendBlock(db, block)
 	if err != nil {
@@ -1 +1,55 @@
 package internal
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/keystore"
+)
+
+// ErrCommitNotMined is returned when a commit was not mined into the block it was made for.
+var ErrCommitNotMined = errors.New("fig-client: commit not mined in commit block")
+
+// SendCommit prepares tx to be committed in the next block, signs it with the unlocked sender key,
+// and submits the commit. The CommitBlock is part of the tx hash, so the commit is only valid if it
+// is mined in that block.
+func SendCommit(c *Client, tx *figaro.Transaction, keys *keystore.KeyStore) error {
+	chain, err := c.FetchChain()
+	if err != nil {
+		return err
+	}
+	tx.CommitBlock = chain.Depth + 1
+	tx.ID, err = tx.ToHash()
+	if err != nil {
+		return err
+	}
+	err = keys.SignTx(tx)
+	if err != nil {
+		return err
+	}
+	commit, err := figaro.NewCommit(tx)
+	if err != nil {
+		return err
+	}
+	return c.SendCommit(figaro.Commit(commit))
+}
+
+// WaitCommit blocks until the commit block of tx is in the canonical chain, returning
+// ErrCommitNotMined if the commit was not mined into it.
+func WaitCommit(c *Client, tx *figaro.Transaction) error {
+	_, err := WaitChain(c, tx.CommitBlock)
+	if err != nil {
+		return err
+	}
+	bl, err := FetchChainBlock(c, tx.CommitBlock)
+	if err != nil {
+		return err
+	}
+	for _, commit := range bl.Commits {
+		if bytes.Equal(commit, tx.ID) {
+			return nil
+		}
+	}
+	return ErrCommitNotMined
+}
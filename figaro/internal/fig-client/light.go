@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+var (
+	// ErrInvalidProof is returned when the node serves data that does not match its Merkle proof.
+	ErrInvalidProof = errors.New("fig-client light: invalid proof")
+	// ErrInvalidHeader is returned when the node serves a header that does not match its hash.
+	ErrInvalidHeader = errors.New("fig-client light: invalid header")
+	// ErrUnknownHeader is returned when a block is not in the verified header chain.
+	ErrUnknownHeader = errors.New("fig-client light: block not in verified header chain")
+	// ErrTxNotIncluded is returned when a tx cannot be proven to be in the block the node claims.
+	ErrTxNotIncluded = errors.New("fig-client light: tx not included in block")
+)
+
+// LightClient follows the header chain of a full node, keeping only verified headers, and fetches
+// accounts, storage and transactions from the node with Merkle proofs, which are validated against
+// the verified headers. The node is trusted for nothing but availability.
+type LightClient struct {
+	c            *Client
+	data         *lightData
+	engine       figaro.ConsensusEngine
+	chain        *figaro.Chain
+	futureblocks *figaro.BlockHeap
+}
+
+// NewLightClient returns a LightClient that keeps its verified headers in db. The header chain
+// starts from the trusted genesis block, which must be given if db has no chain yet. The consensus
+// engine that verifies the producer schedule is built by newEngine, from the producers proven in
+// the genesis state.
+func NewLightClient(c *Client, db *figdb.DB, genesis figaro.BlockHash, newEngine func([]figaro.Address) figaro.ConsensusEngine) (*LightClient, error) {
+	data := &lightData{db, c}
+	chain, err := db.FetchChain()
+	if err != nil {
+		return nil, err
+	}
+	if chain == nil {
+		if len(genesis) == 0 {
+			return nil, ErrUnknownHeader
+		}
+		header, err := data.FetchBlockHeader(genesis)
+		if err != nil {
+			return nil, err
+		}
+		if header.Number != 0 {
+			return nil, ErrInvalidHeader
+		}
+		err = db.SaveBlock(&figaro.Block{BlockHeader: header})
+		if err != nil {
+			return nil, err
+		}
+		chain = &figaro.Chain{Depth: 0, Head: header.ID, ChainConfig: header.ChainConfig}
+		err = db.SaveChain(chain)
+		if err != nil {
+			return nil, err
+		}
+	}
+	lc := &LightClient{c: c, data: data, chain: chain, futureblocks: figaro.NewBlockHeap()}
+	header, err := lc.header(0)
+	if err != nil {
+		return nil, err
+	}
+	producers, err := figaro.GenesisProducers(data, header.StateRoot)
+	if err != nil {
+		return nil, err
+	}
+	lc.engine = newEngine(producers)
+	return lc, nil
+}
+
+// Chain returns the verified header chain.
+func (lc *LightClient) Chain() *figaro.Chain {
+	return lc.chain
+}
+
+// Sync verifies and appends the headers of the node canonical chain, until it has no more.
+func (lc *LightClient) Sync() error {
+	for {
+		header, err := lc.c.FetchChainBlockHeader(lc.chain.Depth + 1)
+		if rerr, ok := err.(*RPCError); ok && rerr.Code == rpcNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = verifyHeader(header, header.ID)
+		if err != nil {
+			return err
+		}
+		err = HandleReceiveBlock(lc.data, lc.chain, header, lc.futureblocks, lc.engine)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// FetchAccount fetches an account at the head of the verified header chain.
+func (lc *LightClient) FetchAccount(address figaro.Address) (*figaro.Account, error) {
+	header, err := lc.data.FetchBlockHeader(lc.chain.Head)
+	if err != nil {
+		return nil, err
+	}
+	return lc.data.FetchAccount(header.StateRoot, address)
+}
+
+// FetchAccountStorage fetches the value at key in the storage of an account at the head of the
+// verified header chain.
+func (lc *LightClient) FetchAccountStorage(address figaro.Address, key []byte) ([]byte, error) {
+	acc, err := lc.FetchAccount(address)
+	if err != nil {
+		return nil, err
+	}
+	return lc.data.FetchAccountStorage(acc, key)
+}
+
+// ProveTx proves that the tx was processed in a block of the verified header chain, returning
+// the tx along with its receipt. The node receipt locates the tx, and the TxBloom of the block
+// rules out a missing tx before its proof is fetched.
+func (lc *LightClient) ProveTx(txid figaro.TxHash) (*figaro.Transaction, *figaro.Receipt, error) {
+	hint, err := lc.c.FetchReceipt(txid)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := lc.header(hint.BlockNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	cb, err := lc.c.FetchCompBlock(header.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	has, err := cb.MayHaveTx(txid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !has {
+		return nil, nil, ErrTxNotIncluded
+	}
+	index := int(hint.Index)
+	txp, err := lc.c.ProveTransaction(header.ID, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The tx ID is derived from the tx fields when decoded, so it cannot be forged
+	if txp.Transaction == nil || !bytes.Equal(txp.Transaction.ID, txid) {
+		return nil, nil, ErrTxNotIncluded
+	}
+	if !lc.data.ValidateTransaction(header.TransactionsRoot, index, *txp.Transaction, txp.Proof) {
+		return nil, nil, ErrInvalidProof
+	}
+	rp, err := lc.c.ProveReceipt(header.ID, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rp.Receipt == nil || !lc.data.ValidateReceipt(header.ReceiptsRoot, index, *rp.Receipt, rp.Proof) {
+		return nil, nil, ErrInvalidProof
+	}
+	rp.Receipt.TxID = txid
+	return txp.Transaction, rp.Receipt, nil
+}
+
+// header fetches the header at number in the verified header chain.
+func (lc *LightClient) header(number uint64) (*figaro.BlockHeader, error) {
+	if number > lc.chain.Depth {
+		return nil, ErrUnknownHeader
+	}
+	id, err := lc.data.FetchChainBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) == 0 {
+		return nil, ErrUnknownHeader
+	}
+	return lc.data.FetchBlockHeader(id)
+}
+
+// verifyHeader verifies that header hashes to id, setting its ID.
+func verifyHeader(header *figaro.BlockHeader, id figaro.BlockHash) error {
+	hash, err := header.ToHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, id) {
+		return ErrInvalidHeader
+	}
+	header.ID = hash
+	return nil
+}
+
+// lightData is the data service of a light client. Headers and the chain are kept in the local db,
+// and anything else is fetched from the node and validated, so that the consensus engine can check
+// the producer schedule without state. It can retreive, but not save, accounts and storage.
+type lightData struct {
+	*figdb.DB
+	c *Client
+}
+
+// FetchBlockHeader fetches a header from the local db, or from the node if it is not known,
+// verifying it against id.
+func (d *lightData) FetchBlockHeader(id figaro.BlockHash) (*figaro.BlockHeader, error) {
+	header, err := d.DB.FetchBlockHeader(id)
+	if err == nil && header != nil && len(header.StateRoot) > 0 {
+		return header, nil
+	}
+	header, err = d.c.FetchBlockHeader(id)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyHeader(header, id)
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// FetchAccount fetches an account in the world state at root from the node, validating its proof.
+func (d *lightData) FetchAccount(root figaro.Root, address figaro.Address) (*figaro.Account, error) {
+	p, err := d.c.ProveAccount(root, address)
+	if err != nil {
+		return nil, err
+	}
+	if p.Account == nil {
+		return nil, ErrInvalidProof
+	}
+	p.Account.Address = address
+	if !d.DB.ValidateAccount(root, p.Account, p.Proof) {
+		return nil, ErrInvalidProof
+	}
+	return p.Account, nil
+}
+
+// FetchAccountStorage fetches the value at key in the account storage from the node, validating
+// its proof against the account StorageRoot.
+func (d *lightData) FetchAccountStorage(account *figaro.Account, key []byte) ([]byte, error) {
+	if len(account.StorageRoot) == 0 {
+		return nil, nil
+	}
+	p, err := d.c.ProveStorage(account.StorageRoot, key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p.Key, key) || !d.DB.ValidateAccountStorage(account, key, p.Value, p.Proof) {
+		return nil, ErrInvalidProof
+	}
+	return p.Value, nil
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// rpcNotFound is the JSON-RPC error code the node returns for missing data.
+const rpcNotFound = -32000
+
+// RPCError is an error returned by the node over JSON-RPC.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (e *RPCError) Error() string { return fmt.Sprintf("fig-client rpc: %s (%d)", e.Message, e.Code) }
+
+// Client is a JSON-RPC 2.0 client for a fig-node.
+type Client struct {
+	url    string
+	http   *http.Client
+	nextID uint64
+}
+
+// NewClient returns a Client for the fig-node JSON-RPC endpoint at url.
+func NewClient(url string) *Client {
+	return &Client{url: url, http: &http.Client{}}
+}
+
+// Call calls method with by-name params, decoding the result into result.
+func (c *Client) Call(method string, params, result interface{}) error {
+	req := struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params,omitempty"`
+		ID      uint64      `json:"id"`
+	}{"2.0", method, params, atomic.AddUint64(&c.nextID, 1)}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Post(c.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fig-client rpc: unexpected status %s", resp.Status)
+	}
+	res := struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// FetchChain fetches the canonical chain of the node.
+func (c *Client) FetchChain() (*figaro.Chain, error) {
+	chain := &figaro.Chain{}
+	err := c.Call("fig_fetchChain", nil, chain)
+	if err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// FetchChainBlock fetches the hash of the Block at index in the canonical chain.
+func (c *Client) FetchChainBlock(index uint64) (figaro.BlockHash, error) {
+	var bhash figaro.BlockHash
+	err := c.Call("fig_fetchChainBlock", map[string]uint64{"number": index}, &bhash)
+	return bhash, err
+}
+
+// FetchBlock fetches a Block by hash.
+func (c *Client) FetchBlock(id figaro.BlockHash) (*figaro.Block, error) {
+	bl := &figaro.Block{}
+	err := c.Call("fig_fetchBlock", map[string]figaro.BlockHash{"hash": id}, bl)
+	if err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+// FetchBlockHeader fetches a BlockHeader by hash.
+func (c *Client) FetchBlockHeader(id figaro.BlockHash) (*figaro.BlockHeader, error) {
+	header := &figaro.BlockHeader{}
+	err := c.Call("fig_fetchBlockHeader", map[string]figaro.BlockHash{"hash": id}, header)
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// FetchChainBlockHeader fetches the BlockHeader at index in the canonical chain.
+func (c *Client) FetchChainBlockHeader(index uint64) (*figaro.BlockHeader, error) {
+	header := &figaro.BlockHeader{}
+	err := c.Call("fig_fetchBlockHeader", map[string]uint64{"number": index}, header)
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// FetchCompBlock fetches a CompBlock by hash.
+func (c *Client) FetchCompBlock(id figaro.BlockHash) (*figaro.CompBlock, error) {
+	cb := &figaro.CompBlock{}
+	err := c.Call("fig_fetchCompBlock", map[string]figaro.BlockHash{"hash": id}, cb)
+	if err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+// FetchAccount fetches an account at the head of the canonical chain.
+func (c *Client) FetchAccount(address figaro.Address) (*figaro.Account, error) {
+	acc := &figaro.Account{}
+	err := c.Call("fig_fetchAccount", map[string]figaro.Address{"address": address}, acc)
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// ProveAccount fetches an account in the world state at root, along with its Merkle proof.
+func (c *Client) ProveAccount(root figaro.Root, address figaro.Address) (*figaro.AccountProof, error) {
+	params := struct {
+		Root    figaro.Root    `json:"root"`
+		Address figaro.Address `json:"address"`
+	}{root, address}
+	p := &figaro.AccountProof{}
+	err := c.Call("fig_proveAccount", params, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ProveStorage fetches the value at key in the account storage at storageroot, along with its Merkle proof.
+func (c *Client) ProveStorage(storageroot figaro.Root, key []byte) (*figaro.StorageProof, error) {
+	params := struct {
+		StorageRoot figaro.Root     `json:"storageRoot"`
+		Key         figaro.HexBytes `json:"key"`
+	}{storageroot, key}
+	p := &figaro.StorageProof{}
+	err := c.Call("fig_proveStorage", params, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ProveTransaction fetches the Transaction at index in a block, along with its Merkle proof.
+func (c *Client) ProveTransaction(id figaro.BlockHash, index int) (*figaro.TxProof, error) {
+	params := struct {
+		Hash  figaro.BlockHash `json:"hash"`
+		Index int              `json:"index"`
+	}{id, index}
+	p := &figaro.TxProof{}
+	err := c.Call("fig_proveTransaction", params, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ProveReceipt fetches the Receipt at index in a block, along with its Merkle proof.
+func (c *Client) ProveReceipt(id figaro.BlockHash, index int) (*figaro.ReceiptProof, error) {
+	params := struct {
+		Hash  figaro.BlockHash `json:"hash"`
+		Index int              `json:"index"`
+	}{id, index}
+	p := &figaro.ReceiptProof{}
+	err := c.Call("fig_proveReceipt", params, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// FetchReceipt fetches the Receipt of a processed transaction.
+func (c *Client) FetchReceipt(txid figaro.TxHash) (*figaro.Receipt, error) {
+	r := &figaro.Receipt{}
+	err := c.Call("fig_fetchReceipt", map[string]figaro.TxHash{"txId": txid}, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SendCommit submits a commit to the node commit pool.
+func (c *Client) SendCommit(commit figaro.Commit) error {
+	return c.Call("fig_sendCommit", map[string]figaro.Commit{"commit": commit}, nil)
+}
+
+// SendTransaction submits a signed transaction to the node transaction pool.
+func (c *Client) SendTransaction(tx *figaro.Transaction) error {
+	return c.Call("fig_sendTransaction", map[string]*figaro.Transaction{"tx": tx}, nil)
+}
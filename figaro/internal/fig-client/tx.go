@@ -1 +1,109 @@
 package internal
+
+import (
+	"errors"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/keystore"
+	"github.com/figaro-tech/go-figaro/figaro/vm"
+)
+
+// ErrTxExpired is returned when a tx was not processed within its reveal window.
+var ErrTxExpired = errors.New("fig-client: tx not processed in reveal window")
+
+// MaxCommitAttempts is how many times SendTx will commit a transaction before giving up.
+var MaxCommitAttempts = 3
+
+// NewTx builds an unsigned transaction from the sender, using the next account nonce. The tx pays
+// the chain MinGasPrice, with a GasLimit of its IntrinsicGas, plus enough gas for the max vm steps
+// if it runs contract code, up to the block GasLimit. A DeployTx must still have its To set to
+// the ContractAddress.
+func NewTx(c *Client, from, to figaro.Address, txtype figaro.TxType, value uint64, data []byte) (*figaro.Transaction, error) {
+	acc, err := c.FetchAccount(from)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := c.FetchChain()
+	if err != nil {
+		return nil, err
+	}
+	tx := &figaro.Transaction{
+		From:     from,
+		To:       to,
+		Nonce:    acc.Nonce,
+		Type:     txtype,
+		Value:    value,
+		GasPrice: chain.MinGasPrice,
+		Data:     data,
+	}
+	tx.GasLimit = figaro.IntrinsicGas(tx)
+	toAcc := &figaro.Account{}
+	if txtype != figaro.DeployTx {
+		toAcc, err = c.FetchAccount(to)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if figaro.RunsCode(tx, toAcc) {
+		tx.GasLimit += vm.MaxSteps * figaro.StepGas
+	}
+	if tx.GasLimit > chain.GasLimit {
+		tx.GasLimit = chain.GasLimit
+	}
+	return tx, nil
+}
+
+// SendTx commits tx, waits for the commit to be mined, and then reveals tx once WaitBlocks have
+// passed, so that it is processed in the reveal window. If the commit is not mined in its commit
+// block, it is committed again. The sender key must be unlocked in keys.
+func SendTx(c *Client, tx *figaro.Transaction, keys *keystore.KeyStore) error {
+	var err error
+	for attempt := 0; attempt < MaxCommitAttempts; attempt++ {
+		err = SendCommit(c, tx, keys)
+		if err != nil {
+			return err
+		}
+		err = WaitCommit(c, tx)
+		if err == ErrCommitNotMined {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// The tx is revealable once the next block is WaitBlocks past the commit block
+		chain, err := c.FetchChain()
+		if err != nil {
+			return err
+		}
+		_, err = WaitChain(c, tx.CommitBlock+uint64(chain.WaitBlocks)-1)
+		if err != nil {
+			return err
+		}
+		return c.SendTransaction(tx)
+	}
+	return err
+}
+
+// WaitReceipt blocks until the tx is processed, returning its Receipt.
+func WaitReceipt(c *Client, tx *figaro.Transaction) (*figaro.Receipt, error) {
+	for {
+		r, err := c.FetchReceipt(tx.ID)
+		if err == nil {
+			return r, nil
+		}
+		if rerr, ok := err.(*RPCError); !ok || rerr.Code != rpcNotFound {
+			return nil, err
+		}
+		chain, err := c.FetchChain()
+		if err != nil {
+			return nil, err
+		}
+		if chain.Depth > tx.CommitBlock+2*uint64(chain.WaitBlocks)+1 {
+			return nil, ErrTxExpired
+		}
+		_, err = WaitChain(c, chain.Depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -1,30 +1,28 @@
 package internal
 
 import (
-	"reflect"
+	"bytes"
+	"log"
 
 	"github.com/figaro-tech/go-figaro/figaro"
 	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
 )
 
-// VerifyTxSignatures will verify all tx signatures in the block
-// at once, returning whether even a single signature is fraudulent.
-func VerifyTxSignatures(bl *figaro.Block) bool {
-	// TODO: because we can do this without reference to the db,
-	// we can run this concurrently and on machines with multiple
-	// processors, in parallel
-	for _, tx := range bl.Transactions {
-		if !tx.VerifySignature() {
-			return false
-		}
-	}
-	return true
+// VerifyTxSignatures will verify all tx signatures in the block in parallel, returning
+// the indices of fraudulent signatures. Verification stops at the first fraudulent signature
+// found, so at most a few indices are returned. Signatures verified in the pool are skipped.
+func VerifyTxSignatures(sigs *SigVerifier, bl *figaro.Block) []int {
+	return sigs.VerifyTxs(bl.Transactions, true)
 }
 
 // SyncBlock will add all commits and transactions to the database, returning
 // whether the block header is valid for the block data. If the block is invalid,
-// it will unwind any changes.
+// it will unwind any changes. Transactions are executed in parallel where they do not conflict.
+// A block whose contents break a rule that a FraudProof can prove is invalid, whatever its state.
 func SyncBlock(db *figdb.DB, prev, bl *figaro.Block) error {
+	if !bl.ValidContents() {
+		return figaro.ErrInvalidBlock
+	}
 	db.FigDB.Store.Batch()
 	defer db.FigDB.Store.Discard() // noop if Write is called upon success, otherwise will discard
 
@@ -41,47 +39,141 @@ func SyncBlock(db *figdb.DB, prev, bl *figaro.Block) error {
 	}
 	btest.Commits = bl.Commits
 	btest.Transactions = make([]*figaro.Transaction, 0, len(bl.Transactions))
+	btest.Evidence = make([]*figaro.Evidence, 0, len(bl.Evidence))
 
-	for i, tx := range bl.Transactions {
-		cblockhash, err := db.FetchChainBlock(tx.CommitBlock)
+	err := ProcessTxs(db, btest, bl.Transactions)
+	if err != nil {
+		return err
+	}
+	for _, ev := range bl.Evidence {
+		err = ApplyEvidence(db, btest, ev)
 		if err != nil {
 			return err
 		}
-		cblock, err := db.FetchBlock(cblockhash)
+	}
+	err = btest.Seal(db)
+	if err != nil {
+		return err
+	}
+	btest.Timestamp = bl.Timestamp
+	id, err := btest.ToHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(id, bl.ID) {
+		return figaro.ErrInvalidBlock
+	}
+	return db.FigDB.Store.Write()
+}
+
+// ProcessTx validates and executes tx as the next transaction in the block, adding it to
+// the block along with its receipt and advancing the block StateRoot. The state must be an
+// overlay at the block StateRoot, which it is kept at. If the GasLimit of tx does not fit
+// in the block, ErrExceedsBlockLimit is returned and the block is unchanged.
+func ProcessTx(db *figdb.DB, state *figaro.StateOverlay, bl *figaro.Block, tx *figaro.Transaction) error {
+	cblock, err := fetchCommitBlock(db, tx)
+	if err != nil {
+		return err
+	}
+	return processCommittedTx(db, state, bl, tx, cblock)
+}
+
+// fetchCommitBlock fetches the canonical block at the CommitBlock of tx, returning
+// ErrUnknownCommitBlock if there is none.
+func fetchCommitBlock(db *figdb.DB, tx *figaro.Transaction) (*figaro.Block, error) {
+	cblockhash, err := db.FetchChainBlock(tx.CommitBlock)
+	if err != nil {
+		return nil, err
+	}
+	cblock, err := db.FetchBlock(cblockhash)
+	if err != nil {
+		return nil, err
+	}
+	if cblock == nil {
+		return nil, ErrUnknownCommitBlock
+	}
+	return cblock, nil
+}
+
+// processCommittedTx is ProcessTx for a tx whose commit block was already fetched.
+func processCommittedTx(db *figdb.DB, state *figaro.StateOverlay, bl *figaro.Block, tx *figaro.Transaction, cblock *figaro.Block) error {
+	if !bl.FitsGas(tx) {
+		return figaro.ErrExceedsBlockLimit
+	}
+	index := uint16(len(bl.Transactions))
+	var receipt *figaro.Receipt
+	valid, err := ValidateTx(state, tx, bl.BlockHeader, cblock)
+	if err != nil {
+		return err
+	}
+	if valid {
+		bl.StateRoot, receipt, err = ExecuteTx(db, state, tx, index, bl.BlockHeader, cblock.BlockHeader)
+	} else {
+		bl.StateRoot, receipt, err = ExecuteInvalidTx(state, tx, index, figaro.InvalidTxGas(tx), bl.BlockHeader, cblock.BlockHeader)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = bl.AddTx(tx, receipt)
+	return err
+}
+
+// ProduceBlock takes a freshly primed block and adds as many commits, transactions and evidence as possible,
+// based on the pending pools, before sealing the block and setting its ID. Transactions are added highest
+// GasPrice first, for as long as they fit in the block GasLimit. The block StateRoot must be set to the
+// StateRoot of the previous block. The block must still be signed by the producer. A pooled tx whose
+// commit block cannot be fetched is evicted from the pool, along with the rest of its sender's txs
+// for this block, rather than failing the block. So is pooled evidence that is no longer valid.
+func ProduceBlock(db *figdb.DB, pool *figaro.TxPool, bl *figaro.Block) error {
+	for _, c := range pool.PendingCommits(figaro.MaxCommitSize - len(bl.Commits)) {
+		_, err := bl.AddCommit(c)
 		if err != nil {
 			return err
 		}
-		var receipt *figaro.Receipt
-		valid, err := ValidateTx(db, tx, btest.BlockHeader, cblock)
+	}
+	txs, err := pool.Pending(db, bl.BlockHeader, figaro.MaxTxSize-len(bl.Transactions))
+	if err != nil {
+		return err
+	}
+	// Once a tx does not fit, later nonces of the same sender would be invalid, so skip them too
+	skipped := make(map[string]bool)
+	state := figaro.NewStateOverlay(db, bl.StateRoot)
+	for _, tx := range txs {
+		if skipped[string(tx.From)] {
+			continue
+		}
+		cblock, err := fetchCommitBlock(db, tx)
+		if err != nil {
+			log.Printf("fig-node: evicting tx %s: commit block %d: %v", tx.ID, tx.CommitBlock, err)
+			pool.RemoveTx(tx.ID)
+			skipped[string(tx.From)] = true
+			continue
+		}
+		err = processCommittedTx(db, state, bl, tx, cblock)
+		if err == figaro.ErrExceedsBlockLimit {
+			skipped[string(tx.From)] = true
+			continue
+		}
 		if err != nil {
 			return err
 		}
-		if valid {
-			btest.StateRoot, receipt, err = ExecuteTx(db, tx, uint16(i), btest.BlockHeader, cblock.BlockHeader)
-			if err != nil {
-				return err
-			}
-		} else {
-			btest.StateRoot, receipt, err = ExecuteInvalidTx(db, tx, uint16(i), btest.BlockHeader, cblock.BlockHeader)
-			if err != nil {
-				return err
-			}
+	}
+	for _, ev := range pool.PendingEvidence(figaro.MaxEvidenceSize - len(bl.Evidence)) {
+		err = ApplyEvidence(db, bl, ev)
+		if err == figaro.ErrInvalidEvidence {
+			// Evidence already penalized, or against a block we no longer follow, is dropped
+			// rather than retried with every block
+			pool.RemoveEvidence(ev)
+			continue
 		}
-		_, err = btest.AddTx(tx, receipt)
 		if err != nil {
 			return err
 		}
 	}
-	btest.Seal(db)
-	btest.Timestamp = bl.Timestamp
-	if !reflect.DeepEqual(btest, bl) {
-		return figaro.ErrInvalidBlock
+	err = bl.Seal(db)
+	if err != nil {
+		return err
 	}
-	return db.FigDB.Store.Write()
-}
-
-// ProduceBlock takes a freshly primed block and adds as many commits and transactions as possible, based
-// on the pending pools, before sealing and signing the block.
-func ProduceBlock(db *figdb.DB, bl *figaro.Block) {
-
+	bl.ID, err = bl.ToHash()
+	return err
 }
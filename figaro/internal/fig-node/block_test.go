@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+func TestProduceBlockEvictsInvalidEvidence(t *testing.T) {
+	db := figdb.NewMem(0, 16)
+	pool := figaro.NewTxPool(figaro.DefaultTxPoolConfig)
+	// The same header twice is no double sign
+	header := &figaro.BlockHeader{Number: 1, Producer: testAddress(1)}
+	err := pool.AddEvidence(&figaro.Evidence{Type: figaro.DoubleSignEvidence, Headers: [2]*figaro.BlockHeader{header, header}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := &figaro.Block{BlockHeader: &figaro.BlockHeader{Number: 2, ChainConfig: figaro.ChainConfig{GasLimit: 1 << 20}}}
+	err = ProduceBlock(db, pool, bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bl.Evidence) != 0 {
+		t.Errorf("block includes %d invalid evidence", len(bl.Evidence))
+	}
+	if pending := pool.PendingEvidence(figaro.MaxEvidenceSize); len(pending) != 0 {
+		t.Errorf("pool keeps %d invalid evidence", len(pending))
+	}
+}
@@ -3,77 +3,168 @@ package internal
 import (
 	"bytes"
 	"container/heap"
+	"errors"
+	"log"
 	"reflect"
+	"time"
 
 	"github.com/figaro-tech/go-figaro/figaro"
 	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	"github.com/figaro-tech/go-figaro/figaro/keystore"
 )
 
-// HandleReceiveBlock handles validating and syncing a new block received from the network
-func HandleReceiveBlock(db *figdb.DB, chain *figaro.Chain, block *figaro.Block, futureblocks *figaro.BlockHeap, engine figaro.ConsensusEngine) error {
-	// If the block is the future, we'll come back to it.
+var (
+	// ErrNotProducer is returned when this node is asked to produce a block out of turn.
+	ErrNotProducer = errors.New("fig-node: not the next block producer")
+	// ErrFraudulentBlock is returned when a block is signed by the next block producer, but includes
+	// transactions with invalid signatures.
+	ErrFraudulentBlock = errors.New("fig-node: block includes fraudulent transactions")
+	// ErrFutureBlock is returned when a block is ahead of the chain head, so it is kept until the
+	// gap is filled, but it is not yet validated, and must not be relayed.
+	ErrFutureBlock = errors.New("fig-node: block is ahead of the chain head")
+	// ErrUnscheduledBlock is returned when a future block is not signed by the producer scheduled
+	// at the chain head state. It may still be valid, since the producers may change in the gap.
+	ErrUnscheduledBlock = errors.New("fig-node: future block is not signed by the scheduled producer")
+	// ErrTooManyFutureBlocks is returned when a future block is received while MaxFutureBlocks are
+	// already kept.
+	ErrTooManyFutureBlocks = errors.New("fig-node: too many future blocks")
+	// ErrUnknownCommitBlock is returned when a tx commit block is not in the canonical chain.
+	ErrUnknownCommitBlock = errors.New("fig-node: unknown tx commit block")
+)
+
+// Producer is the identity this node uses to produce blocks. Blocks are signed with
+// the key for Address, which must be unlocked in Keys.
+type Producer struct {
+	Address     figaro.Address
+	Beneficiary figaro.Address
+	Keys        *keystore.KeyStore
+}
+
+// HandleReceiveBlock handles validating and syncing a new block received from the network.
+// A block ahead of the chain head is kept without being validated, returning ErrFutureBlock.
+// onReorg, if not nil, is called with each chain reorganization the block causes.
+func HandleReceiveBlock(db *figdb.DB, chain *figaro.Chain, pool *figaro.TxPool, block *figaro.Block, futureblocks *figaro.BlockHeap, engine figaro.ConsensusEngine, sigs *SigVerifier, onReorg func(*ReorgEvent)) error {
+	// If the block is the future, we'll come back to it once the Downloader fills the gap.
 	if block.Number > chain.Depth+1 {
-		err := db.SaveBlock(block)
+		err := db.ArchiveBlock(block)
 		if err != nil {
 			return err
 		}
 		// TODO: wrap all these heap methods for type safety in `figaro`
 		heap.Push(futureblocks, block.BlockHeader)
-		return nil
+		return ErrFutureBlock
 	}
 	// If the block is in the past, skip it, as we've already got a longer chain.
 	// NOTE: this skipped block could be canonical, but we'll wait until we encounter
-	// a longer chain and then request missing blocks from the network.
+	// a longer chain, whose missing blocks the Downloader requests from the network. We
+	// keep it, since it may be the ancestor of that longer chain.
 	if block.Number < chain.Depth+1 {
-		// TODO: maybe should probably delete the block, since we don't need it anymore
-		header := heap.Pop(futureblocks).(*figaro.BlockHeader)
-		block, err := db.HydrateBlock(header)
+		err := db.ArchiveBlock(block)
 		if err != nil {
 			return err
 		}
-		return HandleReceiveBlock(db, chain, block, futureblocks, engine)
+		// A producer that signs two blocks at the same height is penalized
+		err = ReportDoubleSign(db, chain, pool, block.BlockHeader)
+		if err != nil {
+			return err
+		}
+		return handleFutureBlocks(db, chain, pool, futureblocks, engine, sigs, onReorg)
 	}
-	err := HandleNextBlock(db, chain, block, engine)
+	err := HandleNextBlock(db, chain, pool, block, engine, sigs)
 	if err != nil && err != figaro.ErrReorgRequired {
 		return err
 	}
 	if err == figaro.ErrReorgRequired {
-		var header *figaro.BlockHeader
 		// This will also handle syncing the database after the reorg, so we'll have the block
 		// data available to us by the time this returns
-		chain, header, futureblocks, err = engine.ChainReorg(db, chain, block.BlockHeader, futureblocks)
+		ev, err := Reorg(db, chain, pool, block, futureblocks, engine, sigs)
 		if err != nil {
 			return err
 		}
-		block, err := db.HydrateBlock(header)
-		if err != nil {
-			return err
+		if onReorg != nil {
+			onReorg(ev)
 		}
-		return HandleReceiveBlock(db, chain, block, futureblocks, engine)
 	}
-	// TODO: try to process future blocks recursively
-	if futureblocks.PeekNextNumber() == chain.Depth+1 {
-		header := heap.Pop(futureblocks).(*figaro.BlockHeader)
-		block, err := db.HydrateBlock(header)
-		if err != nil {
-			return err
-		}
-		return HandleReceiveBlock(db, chain, block, futureblocks, engine)
+	return handleFutureBlocks(db, chain, pool, futureblocks, engine, sigs, onReorg)
+}
+
+// handleFutureBlocks processes the next block from the future blocks, if it is now due.
+func handleFutureBlocks(db *figdb.DB, chain *figaro.Chain, pool *figaro.TxPool, futureblocks *figaro.BlockHeap, engine figaro.ConsensusEngine, sigs *SigVerifier, onReorg func(*ReorgEvent)) error {
+	if futureblocks.Len() == 0 || futureblocks.PeekNextNumber() > chain.Depth+1 {
+		return nil
+	}
+	header := heap.Pop(futureblocks).(*figaro.BlockHeader)
+	block, err := db.HydrateBlock(header)
+	if err != nil {
+		return err
+	}
+	return HandleReceiveBlock(db, chain, pool, block, futureblocks, engine, sigs, onReorg)
+}
+
+// MaxFutureBlocks is the max number of future blocks kept when received unsolicited. Those
+// downloaded to fill the gap below them are not limited, since they link up to one of them.
+const MaxFutureBlocks = 1024
+
+// VerifyFutureBlock verifies a block ahead of the chain head, received unsolicited, before it is
+// kept in futureblocks. It must be signed by the producer scheduled for it. The state of its
+// parent is not synced yet, so the schedule is taken at the chain head state instead.
+func VerifyFutureBlock(db *figdb.DB, chain *figaro.Chain, header *figaro.BlockHeader, futureblocks *figaro.BlockHeap, engine figaro.ConsensusEngine) error {
+	if futureblocks.Len() >= MaxFutureBlocks {
+		return ErrTooManyFutureBlocks
+	}
+	if !header.VerifySignature() {
+		return figaro.ErrInvalidBlock
+	}
+	if !reflect.DeepEqual(header.ChainConfig, chain.ChainConfig) {
+		return figaro.ErrInvalidBlock
+	}
+	head, err := db.FetchBlockHeader(chain.Head)
+	if err != nil {
+		return err
+	}
+	parent := *head
+	parent.ID, parent.Number = header.ParentBlock, header.Number-1
+	next, err := engine.NextBlockProducer(&futureParentDB{db, &parent}, parent.ID)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(header.Producer, next) {
+		return ErrUnscheduledBlock
 	}
 	return nil
 }
 
+// futureParentDB serves parent, the stand-in for the unknown parent of a future block, in place
+// of the block header it names.
+type futureParentDB struct {
+	*figdb.DB
+	parent *figaro.BlockHeader
+}
+
+func (db *futureParentDB) FetchBlockHeader(id figaro.BlockHash) (*figaro.BlockHeader, error) {
+	if bytes.Equal(id, db.parent.ID) {
+		return db.parent, nil
+	}
+	return db.DB.FetchBlockHeader(id)
+}
+
 // HandleNextBlock handles validating and syncing the next block recevied from the network
-func HandleNextBlock(db *figdb.DB, chain *figaro.Chain, block *figaro.Block, engine figaro.ConsensusEngine) error {
+func HandleNextBlock(db *figdb.DB, chain *figaro.Chain, pool *figaro.TxPool, block *figaro.Block, engine figaro.ConsensusEngine, sigs *SigVerifier) error {
 	if !block.VerifySignature() {
 		return figaro.ErrInvalidBlock
 	}
 	if block.Number != chain.Depth+1 {
 		return figaro.ErrInvalidBlock
 	}
-	if reflect.DeepEqual(block.ChainConfig, chain.ChainConfig) {
+	if !reflect.DeepEqual(block.ChainConfig, chain.ChainConfig) {
 		return figaro.ErrInvalidBlock
 	}
+	// If there's a conflict, we'll get back a new chain, block, and futureblocks and can continue as normal
+	// This will also handle cleaning up invalid data from the non-canonical chain. The rest of the
+	// checks are made as the fork is replayed, against the state of its parent.
+	if !bytes.Equal(block.ParentBlock, chain.Head) {
+		return figaro.ErrReorgRequired
+	}
 	next, err := engine.NextBlockProducer(db, chain.Head)
 	if err != nil {
 		return err
@@ -81,17 +172,20 @@ func HandleNextBlock(db *figdb.DB, chain *figaro.Chain, block *figaro.Block, eng
 	if !bytes.Equal(block.Producer, next) {
 		return figaro.ErrInvalidBlock
 	}
-	if !VerifyTxSignatures(block) {
+	if invalid := VerifyTxSignatures(sigs, block); len(invalid) > 0 {
 		err := engine.HandleFraud(db, block.BlockHeader)
 		if err != nil {
 			return err
 		}
-		return figaro.ErrInvalidBlock
-	}
-	// If there's a conflict, we'll get back a new chain, block, and futureblocks and can continue as normal
-	// This will also handle cleaning up invalid data from the non-canonical chain
-	if !bytes.Equal(block.ParentBlock, chain.Head) {
-		return figaro.ErrReorgRequired
+		err = db.ArchiveBlock(block)
+		if err != nil {
+			return err
+		}
+		err = ReportTxSignatureFraud(db, pool, block, invalid[0])
+		if err != nil {
+			log.Printf("fig-node: unable to prove fraud in block %d: %v", block.Number, err)
+		}
+		return ErrFraudulentBlock
 	}
 	prevbl, err := db.FetchBlock(chain.Head)
 	if err != nil {
@@ -101,14 +195,59 @@ func HandleNextBlock(db *figdb.DB, chain *figaro.Chain, block *figaro.Block, eng
 	if err != nil {
 		return err
 	}
+	err = db.SaveBlock(block)
+	if err != nil {
+		return err
+	}
 	err = chain.AppendBlock(db, block.BlockHeader)
 	if err != nil {
 		return err
 	}
-	return nil
+	return pool.Update(db, block)
 }
 
-// HandleProduceBlock handles the case where it is this nodes turn to produce a block.
-func HandleProduceBlock() {
+// HandleProduceBlock handles the case where it is this nodes turn to produce a block. It builds
+// the next block from the pending pools, then saves it and appends it to the chain.
+func HandleProduceBlock(db *figdb.DB, chain *figaro.Chain, pool *figaro.TxPool, engine figaro.ConsensusEngine, producer *Producer) (*figaro.Block, error) {
+	next, err := engine.NextBlockProducer(db, chain.Head)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(producer.Address, next) {
+		return nil, ErrNotProducer
+	}
+	prev, err := db.FetchBlockHeader(chain.Head)
+	if err != nil {
+		return nil, err
+	}
+	block := chain.NextBlock()
+	block.Producer = producer.Address
+	block.Beneficiary = producer.Beneficiary
+	block.StateRoot = prev.StateRoot
+	block.Timestamp = time.Now().UTC()
+
+	db.FigDB.Store.Batch()
+	defer db.FigDB.Store.Discard() // noop if Write is called upon success, otherwise will discard
 
+	err = ProduceBlock(db, pool, block)
+	if err != nil {
+		return nil, err
+	}
+	err = producer.Keys.SignBlock(block.BlockHeader)
+	if err != nil {
+		return nil, err
+	}
+	err = db.SaveBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	err = chain.AppendBlock(db, block.BlockHeader)
+	if err != nil {
+		return nil, err
+	}
+	err = db.FigDB.Store.Write()
+	if err != nil {
+		return nil, err
+	}
+	return block, pool.Update(db, block)
 }
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"bytes"
+	"container/heap"
+	"testing"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/consensus"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+func TestVerifyFutureBlock(t *testing.T) {
+	var producers []figaro.Address
+	var privkeys [][]byte
+	for i := 0; i < 3; i++ {
+		pubkey, privkey, err := fastsig.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		producers, privkeys = append(producers, pubkey), append(privkeys, privkey)
+	}
+	engine := consensus.NewAuthorityEngine(producers)
+
+	db := figdb.NewMem(0, 16)
+	cfg := figaro.ChainConfig{GasLimit: 1 << 20}
+	head := &figaro.BlockHeader{Number: 1, ChainConfig: cfg}
+	var err error
+	head.ID, err = head.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SaveBlockHeader(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := &figaro.Chain{Depth: 1, Head: head.ID, ChainConfig: cfg}
+
+	// future returns block 5, whose parent is unknown, signed by producer i
+	future := func(i int, cfg figaro.ChainConfig) *figaro.BlockHeader {
+		header := &figaro.BlockHeader{Producer: producers[i], Number: 5, ParentBlock: figaro.BlockHash("unknown parent"), ChainConfig: cfg}
+		header.ID, err = header.ToHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = header.Sign(privkeys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return header
+	}
+	unsigned := future(2, cfg)
+	unsigned.Signature[0] ^= 1
+
+	tests := []struct {
+		name   string
+		header *figaro.BlockHeader
+		want   error
+	}{
+		{"scheduled", future(2, cfg), nil},
+		{"unscheduled", future(1, cfg), ErrUnscheduledBlock},
+		{"bad signature", unsigned, figaro.ErrInvalidBlock},
+		{"other config", future(2, figaro.ChainConfig{GasLimit: 1}), figaro.ErrInvalidBlock},
+	}
+	for _, tt := range tests {
+		err := VerifyFutureBlock(db, chain, tt.header, figaro.NewBlockHeap(), engine)
+		if err != tt.want {
+			t.Errorf("%s: VerifyFutureBlock() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	full := figaro.NewBlockHeap()
+	for i := 0; i < MaxFutureBlocks; i++ {
+		heap.Push(full, future(2, cfg))
+	}
+	if err := VerifyFutureBlock(db, chain, future(2, cfg), full, engine); err != ErrTooManyFutureBlocks {
+		t.Errorf("VerifyFutureBlock() with a full heap = %v, want %v", err, ErrTooManyFutureBlocks)
+	}
+}
+
+func TestDropFutureBlocks(t *testing.T) {
+	n := NewNode(figdb.NewMem(0, 16), nil, nil, nil)
+	dropped, kept := figaro.BlockHash("dropped"), figaro.BlockHash("kept")
+	for i, parent := range []figaro.BlockHash{dropped, kept, dropped} {
+		heap.Push(n.futureblocks, &figaro.BlockHeader{Number: uint64(10 - i), ParentBlock: parent})
+	}
+	n.DropFutureBlocks(dropped)
+	if n.futureblocks.Len() != 1 || !bytes.Equal(n.futureblocks.Peek().ParentBlock, kept) {
+		t.Errorf("futureblocks = %v, want the block of parent %x", *n.futureblocks, kept)
+	}
+}
+
+func TestPopNextBlock(t *testing.T) {
+	chain := &figaro.Chain{Depth: 4, Head: figaro.BlockHash("head")}
+	other := &figaro.BlockHeader{Number: 5, ParentBlock: figaro.BlockHash("other fork")}
+	child := &figaro.BlockHeader{Number: 5, ParentBlock: chain.Head}
+	later := &figaro.BlockHeader{Number: 6, ParentBlock: figaro.BlockHash("child")}
+	futureblocks := figaro.NewBlockHeap()
+	for _, header := range []*figaro.BlockHeader{other, later, child} {
+		heap.Push(futureblocks, header)
+	}
+	if got := popNextBlock(futureblocks, chain); got != child {
+		t.Fatalf("popNextBlock() = %+v, want the child of the head", got)
+	}
+	if futureblocks.Len() != 2 {
+		t.Errorf("futureblocks.Len() = %d, want the other blocks kept", futureblocks.Len())
+	}
+	chain.Depth, chain.Head = 5, figaro.BlockHash("unrelated")
+	if got := popNextBlock(futureblocks, chain); got != nil {
+		t.Errorf("popNextBlock() = %+v, want nil", got)
+	}
+}
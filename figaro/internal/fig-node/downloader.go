@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ErrUnlinkedHeaders is returned when a peer serves headers that do not link up to a future
+// block, as a peer on another fork does, so it is not penalized.
+var ErrUnlinkedHeaders = errors.New("fig-node sync: headers do not link up to the future block")
+
+// downloadInterval is how often the Downloader checks for a gap in the chain.
+const downloadInterval = 2 * time.Second
+
+// Downloader fills the gap between the chain head of a Node and its future blocks, which are
+// received before their ancestors, by requesting the missing blocks from peers.
+//
+// The gap is filled from the top down, at most MaxBlocksRequest blocks at a time. The headers are
+// requested by number, and must link up to the parent of the lowest future block, so that a peer
+// on another fork is caught before any block is downloaded. The blocks are then requested by hash
+// and handed to the Node, where they become future blocks themselves, until the gap is closed and
+// the chain advances through them. Each peer has syncRequestTimeout to serve a batch, and the
+// next peer is tried if it fails. If every peer serves headers that do not link up, the future
+// blocks above the gap are dropped, since no peer has their ancestors.
+type Downloader struct {
+	h     host.Host
+	peers *Peers
+	node  *Node
+}
+
+// NewDownloader returns a Downloader, ready to run, that downloads from the peers in peers.
+func NewDownloader(h host.Host, peers *Peers, node *Node) *Downloader {
+	return &Downloader{h: h, peers: peers, node: node}
+}
+
+// Run fills gaps as they appear, until ctx is done.
+func (d *Downloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(downloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if d.node.Syncing() {
+			continue
+		}
+		err := d.fill(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// fill downloads batches of missing blocks until there is no gap left, or a batch does not
+// shrink it.
+func (d *Downloader) fill(ctx context.Context) error {
+	var last figaro.BlockHash
+	for {
+		from, to, parent, ok := d.node.Gap()
+		if !ok || bytes.Equal(parent, last) {
+			return nil
+		}
+		last = parent
+		if to-from+1 > MaxBlocksRequest {
+			from = to - MaxBlocksRequest + 1
+		}
+		var blocks []*figaro.Block
+		var tried, unlinked int
+		err := fromPeers(ctx, d.h, d.peers, to, func(ctx context.Context, p peer.ID) error {
+			var err error
+			blocks, err = d.download(ctx, p, from, to, parent)
+			tried++
+			if err == ErrUnlinkedHeaders {
+				unlinked++
+			}
+			return err
+		})
+		if err == ErrNoSyncPeers && unlinked > 0 && unlinked == tried {
+			log.Printf("fig-node sync: dropping future blocks of unknown parent %x", parent)
+			d.node.DropFutureBlocks(parent)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			err = d.node.receiveBlock(block, false)
+			if err != nil && err != ErrFutureBlock {
+				return err
+			}
+		}
+	}
+}
+
+// download requests the blocks from number from to number to from a peer, verifying that they
+// link up to parent.
+func (d *Downloader) download(ctx context.Context, p peer.ID, from, to uint64, parent figaro.BlockHash) ([]*figaro.Block, error) {
+	count := to - from + 1
+	headers, err := RequestHeaders(ctx, d.h, p, from, count)
+	if err != nil {
+		return nil, err
+	}
+	// A peer behind us, or one that reorgs while serving, may serve fewer or unlinked headers
+	if uint64(len(headers)) != count {
+		return nil, io.ErrUnexpectedEOF
+	}
+	for i := len(headers) - 1; i >= 0; i-- {
+		if headers[i].Number != from+uint64(i) {
+			return nil, ErrInvalidHeaderChain
+		}
+		if !bytes.Equal(headers[i].ID, parent) {
+			return nil, ErrUnlinkedHeaders
+		}
+		parent = headers[i].ParentBlock
+	}
+	blocks := make([]*figaro.Block, len(headers))
+	for i, header := range headers {
+		blocks[i], err = RequestBlock(ctx, d.h, p, header.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,591 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// PivotDistance is how far behind the head of the best peer the pivot block is chosen, so that
+// the pivot is unlikely to be reorganized away while its state is downloaded.
+const PivotDistance = 64
+
+// syncRequestTimeout bounds a sync request to a peer, from opening the stream to reading the whole
+// response, and how long a peer has to send and read a request that we serve.
+const syncRequestTimeout = 30 * time.Second
+
+var (
+	// ErrNoSyncPeers is returned when no peer could serve the next step of a sync.
+	ErrNoSyncPeers = errors.New("fig-node sync: no peers to sync from")
+	// ErrInvalidHeaderChain is returned when a peer serves headers that do not extend the header chain.
+	ErrInvalidHeaderChain = errors.New("fig-node sync: invalid header chain")
+	// ErrInvalidBlockContents is returned when a peer serves block contents that do not match the header.
+	ErrInvalidBlockContents = errors.New("fig-node sync: block contents do not match header")
+)
+
+// Each item of the state sync queue is the hash of a state trie node, prefixed with the kind of
+// trie it belongs to, since the leaves of the account trie lead on to the storage tries.
+const (
+	accountTrieNode byte = iota
+	storageTrieNode
+)
+
+// FastSync syncs a fresh node from its peers without executing every block from genesis. It
+// downloads and verifies the header chain up to a pivot block, downloads the world state at
+// the pivot, verified against the pivot StateRoot, and then executes the blocks after the pivot
+// in full. Progress is saved as it advances, so that a sync resumes after a restart.
+//
+// Headers before the pivot are verified for linkage, ChainConfig, producer signature, and the
+// producer schedule of the consensus engine. Where the schedule depends on state, as with stake,
+// the state it reads at the parent of each header is downloaded node by node from the peer serving
+// the headers, and verified against the parent StateRoot.
+//
+// The world state at the pivot is downloaded as trie nodes, each verified against the hash it was
+// requested by, starting from the pivot StateRoot. The nodes left to download are saved along with
+// each batch, so that the download resumes where it left off.
+type FastSync struct {
+	db     *figdb.DB
+	h      host.Host
+	peers  *Peers
+	pool   *figaro.TxPool
+	engine figaro.ConsensusEngine
+	sigs   *SigVerifier
+
+	mu       sync.RWMutex
+	progress figaro.SyncProgress
+}
+
+// NewFastSync returns a FastSync, ready to run, that downloads from the peers in peers.
+func NewFastSync(h host.Host, peers *Peers, db *figdb.DB, pool *figaro.TxPool, engine figaro.ConsensusEngine, sigs *SigVerifier) *FastSync {
+	return &FastSync{db: db, h: h, peers: peers, pool: pool, engine: engine, sigs: sigs}
+}
+
+// Progress returns the current sync progress.
+func (fs *FastSync) Progress() figaro.SyncProgress {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.progress
+}
+
+// Run fast syncs the node, resuming any sync in progress. A new sync is only started on a node
+// that has nothing but its genesis block, and only if the best peer is more than PivotDistance
+// blocks ahead. Run returns once the node has caught up with its peers. It can be called again
+// after an error to resume.
+func (fs *FastSync) Run(ctx context.Context) error {
+	progress, err := fs.db.FetchSyncProgress()
+	if err != nil {
+		return err
+	}
+	if progress == nil {
+		progress, err = fs.start(ctx)
+		if err != nil || progress == nil {
+			return err
+		}
+	}
+	fs.setProgress(*progress)
+	for {
+		progress := fs.Progress()
+		log.Printf("fig-node: fast sync %s stage, pivot %d, target %d", progress.Stage, progress.Pivot, progress.Target)
+		switch progress.Stage {
+		case figaro.SyncHeaders:
+			err = fs.syncHeaders(ctx)
+		case figaro.SyncState:
+			err = fs.syncState(ctx)
+		case figaro.SyncBlocks:
+			err = fs.syncBlocks(ctx)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// start chooses the pivot for a new sync, or returns nil if the node should not fast sync.
+func (fs *FastSync) start(ctx context.Context) (*figaro.SyncProgress, error) {
+	chain, err := fs.db.FetchChain()
+	if err != nil {
+		return nil, err
+	}
+	if chain == nil || chain.Depth > 0 {
+		return nil, nil
+	}
+	best := fs.bestDepth(1)
+	if best <= chain.Depth+PivotDistance {
+		return nil, nil
+	}
+	progress := &figaro.SyncProgress{
+		Stage:   figaro.SyncHeaders,
+		Pivot:   best - PivotDistance,
+		Headers: chain.Depth,
+		Target:  best,
+	}
+	return progress, fs.db.SaveSyncProgress(progress)
+}
+
+// syncHeaders downloads and verifies the header chain up to the pivot, indexing each header
+// in the canonical chain without moving the chain head.
+func (fs *FastSync) syncHeaders(ctx context.Context) error {
+	for {
+		progress := fs.Progress()
+		if progress.Headers >= progress.Pivot {
+			break
+		}
+		prev, err := fs.canonicalHeader(progress.Headers)
+		if err != nil {
+			return err
+		}
+		count := progress.Pivot - progress.Headers
+		if count > MaxHeadersRequest {
+			count = MaxHeadersRequest
+		}
+		err = fromPeers(ctx, fs.h, fs.peers, progress.Pivot, func(ctx context.Context, p peer.ID) error {
+			headers, err := RequestHeaders(ctx, fs.h, p, prev.Number+1, count)
+			if err != nil {
+				return err
+			}
+			if len(headers) == 0 {
+				return ErrInvalidHeaderChain
+			}
+			return fs.saveHeaders(fs.syncData(ctx, p), prev, headers)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return fs.advance(func(p *figaro.SyncProgress) { p.Stage = figaro.SyncState })
+}
+
+// saveHeaders verifies that headers extend the header chain from prev, and saves them. The headers
+// are all verified before any is saved, since the state nodes downloaded to verify them are saved
+// as they are needed, and must be readable by the next header.
+func (fs *FastSync) saveHeaders(data *syncData, prev *figaro.BlockHeader, headers []*figaro.BlockHeader) error {
+	parent := prev
+	for _, header := range headers {
+		err := fs.verifyHeader(data, parent, header)
+		if err != nil {
+			return err
+		}
+		data.headers[string(header.ID)] = header
+		parent = header
+	}
+
+	fs.db.FigDB.Store.Batch()
+	defer fs.db.FigDB.Store.Discard() // noop if Write is called upon success, otherwise will discard
+
+	for _, header := range headers {
+		err := fs.db.SaveBlockHeader(header)
+		if err != nil {
+			return err
+		}
+		err = fs.db.SaveChainBlock(header.Number, header.ID)
+		if err != nil {
+			return err
+		}
+		prev = header
+	}
+	progress := fs.Progress()
+	progress.Headers = prev.Number
+	err := fs.db.SaveSyncProgress(&progress)
+	if err != nil {
+		return err
+	}
+	err = fs.db.FigDB.Store.Write()
+	if err != nil {
+		return err
+	}
+	fs.setProgress(progress)
+	return nil
+}
+
+// verifyHeader verifies that header is signed by the producer scheduled after prev, and follows
+// prev in the header chain.
+func (fs *FastSync) verifyHeader(data *syncData, prev, header *figaro.BlockHeader) error {
+	if header.Number != prev.Number+1 || !bytes.Equal(header.ParentBlock, prev.ID) {
+		return ErrInvalidHeaderChain
+	}
+	if !reflect.DeepEqual(header.ChainConfig, prev.ChainConfig) {
+		return ErrInvalidHeaderChain
+	}
+	if !header.VerifySignature() {
+		return ErrInvalidHeaderChain
+	}
+	producer, err := fs.engine.NextBlockProducer(data, header.ParentBlock)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(producer, header.Producer) {
+		return ErrInvalidHeaderChain
+	}
+	return nil
+}
+
+// syncData returns the data service that headers from p are verified against, which downloads the
+// state it reads from p.
+func (fs *FastSync) syncData(ctx context.Context, p peer.ID) *syncData {
+	return &syncData{
+		DB:      fs.db,
+		headers: make(map[string]*figaro.BlockHeader),
+		fetch: func(hash []byte) ([]byte, error) {
+			nodes, err := RequestStateNodes(ctx, fs.h, p, [][]byte{hash})
+			if err != nil {
+				return nil, err
+			}
+			if len(nodes) == 0 {
+				return nil, figdb.ErrMissingStateNode
+			}
+			return nodes[0], nil
+		},
+	}
+}
+
+// syncData is the data service of the header stage of a fast sync. Headers that are verified but
+// not yet saved are kept in memory, and the state paths that are read are downloaded first, with
+// each node verified against its hash, so that the consensus engine can check the producer
+// schedule before the world state is available.
+type syncData struct {
+	*figdb.DB
+	headers map[string]*figaro.BlockHeader
+	fetch   func(hash []byte) ([]byte, error)
+}
+
+// FetchBlockHeader fetches a header verified in this request, or from the db.
+func (d *syncData) FetchBlockHeader(id figaro.BlockHash) (*figaro.BlockHeader, error) {
+	if header, ok := d.headers[string(id)]; ok {
+		return header, nil
+	}
+	return d.DB.FetchBlockHeader(id)
+}
+
+// FetchAccount fetches an account, downloading the path to it in the state trie at root first.
+func (d *syncData) FetchAccount(root figaro.Root, address figaro.Address) (*figaro.Account, error) {
+	err := d.SyncStatePath(root, address, d.fetch)
+	if err != nil {
+		return nil, err
+	}
+	return d.DB.FetchAccount(root, address)
+}
+
+// FetchAccountStorage fetches a value in the account storage, downloading the path to it in the
+// storage trie first.
+func (d *syncData) FetchAccountStorage(account *figaro.Account, key []byte) ([]byte, error) {
+	err := d.SyncStatePath(account.StorageRoot, key, d.fetch)
+	if err != nil {
+		return nil, err
+	}
+	return d.DB.FetchAccountStorage(account, key)
+}
+
+// syncState downloads the blocks before the pivot that the blocks after it can reveal commits
+// from, and the world state at the pivot, before making the pivot the chain head. Block contents
+// already saved are downloaded again after a restart, but state nodes are not.
+func (fs *FastSync) syncState(ctx context.Context) error {
+	progress := fs.Progress()
+	pivot, err := fs.canonicalHeader(progress.Pivot)
+	if err != nil {
+		return err
+	}
+	// The first block after the pivot can reveal commits from up to 2*WaitBlocks+1 blocks before it
+	from := uint64(1)
+	if window := 2 * uint64(pivot.WaitBlocks); pivot.Number > window {
+		from = pivot.Number - window
+	}
+	for from <= pivot.Number {
+		err = fromPeers(ctx, fs.h, fs.peers, pivot.Number, func(ctx context.Context, p peer.ID) error {
+			count := pivot.Number - from + 1
+			if count > MaxBlocksRequest {
+				count = MaxBlocksRequest
+			}
+			blocks, err := RequestBlocks(ctx, fs.h, p, from, count)
+			if err != nil {
+				return err
+			}
+			if len(blocks) == 0 {
+				return ErrInvalidBlockContents
+			}
+			for _, block := range blocks {
+				err = fs.saveBlockContents(block)
+				if err != nil {
+					return err
+				}
+				from++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = fs.syncStateNodes(ctx, pivot)
+	if err != nil {
+		return err
+	}
+	chain := &figaro.Chain{Depth: pivot.Number, Head: pivot.ID, ChainConfig: pivot.ChainConfig}
+	return fs.advance(func(p *figaro.SyncProgress) {
+		p.Stage = figaro.SyncBlocks
+		p.Blocks = pivot.Number
+	}, func() error {
+		return fs.db.SaveChain(chain)
+	}, fs.db.DeleteStateSyncQueue)
+}
+
+// syncStateNodes downloads the account and storage tries at the pivot StateRoot depth first, up to
+// MaxStateNodesRequest nodes at a time. Nodes already in the db are not downloaded again, but are
+// still walked for their children, since a node is saved before its children are.
+func (fs *FastSync) syncStateNodes(ctx context.Context, pivot *figaro.BlockHeader) error {
+	queue, ok, err := fs.db.FetchStateSyncQueue()
+	if err != nil {
+		return err
+	}
+	if !ok && len(pivot.StateRoot) > 0 {
+		queue = [][]byte{stateSyncItem(accountTrieNode, pivot.StateRoot)}
+	}
+	for len(queue) > 0 {
+		n := len(queue)
+		if n > MaxStateNodesRequest {
+			n = MaxStateNodesRequest
+		}
+		items := queue[len(queue)-n:]
+		queue = append([][]byte{}, queue[:len(queue)-n]...)
+
+		nodes := make([][]byte, len(items))
+		var missing []int
+		for i, item := range items {
+			nodes[i], err = fs.db.FetchStateNode(item[1:])
+			if err == figdb.ErrMissingStateNode {
+				missing = append(missing, i)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		fetched := missing
+		if len(missing) > 0 {
+			err = fromPeers(ctx, fs.h, fs.peers, pivot.Number, func(ctx context.Context, p peer.ID) error {
+				hashes := make([][]byte, len(missing))
+				for i, j := range missing {
+					hashes[i] = items[j][1:]
+				}
+				got, err := RequestStateNodes(ctx, fs.h, p, hashes)
+				if err != nil {
+					return err
+				}
+				for i, b := range got {
+					nodes[missing[i]] = b
+				}
+				// The rest are requested from the next peer
+				missing = missing[len(got):]
+				if len(missing) > 0 {
+					return figdb.ErrMissingStateNode
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for i, b := range nodes {
+			children, values, err := figdb.StateNodeChildren(b)
+			if err != nil {
+				return err
+			}
+			kind := items[i][0]
+			for _, child := range children {
+				queue = append(queue, stateSyncItem(kind, child))
+			}
+			if kind != accountTrieNode {
+				continue
+			}
+			for _, value := range values {
+				account := &figaro.Account{}
+				err = account.Decode(value)
+				if err != nil {
+					return err
+				}
+				if len(account.StorageRoot) > 0 {
+					queue = append(queue, stateSyncItem(storageTrieNode, account.StorageRoot))
+				}
+			}
+		}
+		err = fs.advance(func(p *figaro.SyncProgress) {}, func() error {
+			for _, i := range fetched {
+				_, err := fs.db.SaveStateNode(nodes[i])
+				if err != nil {
+					return err
+				}
+			}
+			return fs.db.SaveStateSyncQueue(queue)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateSyncItem returns the state sync queue item for the node with hash in a trie of kind.
+func stateSyncItem(kind byte, hash []byte) []byte {
+	item := make([]byte, 0, 1+len(hash))
+	item = append(item, kind)
+	return append(item, hash...)
+}
+
+// saveBlockContents verifies a block from a peer against the canonical header of the same
+// number, and archives its contents.
+func (fs *FastSync) saveBlockContents(block *figaro.Block) error {
+	fs.db.FigDB.Store.Batch()
+	defer fs.db.FigDB.Store.Discard() // noop if Write is called upon success, otherwise will discard
+
+	id, err := fs.db.FetchChainBlock(block.Number)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(block.ID, id) {
+		return ErrInvalidHeaderChain
+	}
+	croot, err := fs.db.ArchiveCommits(block.Commits)
+	if err != nil {
+		return err
+	}
+	txroot, err := fs.db.ArchiveTransactions(block.Transactions)
+	if err != nil {
+		return err
+	}
+	evroot, err := fs.db.ArchiveEvidence(block.Evidence)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(croot, block.CommitsRoot) || !bytes.Equal(txroot, block.TransactionsRoot) || !bytes.Equal(evroot, block.EvidenceRoot) {
+		return ErrInvalidBlockContents
+	}
+	if !block.ValidContents() {
+		return ErrInvalidBlockContents
+	}
+	// The blooms are not covered by the block hash, so they are rebuilt rather than trusted
+	err = block.SetBlooms()
+	if err != nil {
+		return err
+	}
+	err = fs.db.SaveBlock(block)
+	if err != nil {
+		return err
+	}
+	err = fs.db.FigDB.Store.Write()
+	if err != nil {
+		return err
+	}
+	// The pool needs to know where commits were mined to reveal their transactions
+	return fs.pool.Update(fs.db, block)
+}
+
+// syncBlocks executes the blocks after the chain head, as received from the network, until no
+// peer is ahead.
+func (fs *FastSync) syncBlocks(ctx context.Context) error {
+	for {
+		chain, err := fs.db.FetchChain()
+		if err != nil {
+			return err
+		}
+		best := fs.bestDepth(chain.Depth + 1)
+		if best == 0 {
+			break
+		}
+		err = fromPeers(ctx, fs.h, fs.peers, chain.Depth+1, func(ctx context.Context, p peer.ID) error {
+			blocks, err := RequestBlocks(ctx, fs.h, p, chain.Depth+1, MaxBlocksRequest)
+			if err != nil {
+				return err
+			}
+			if len(blocks) == 0 {
+				return ErrInvalidBlockContents
+			}
+			for _, block := range blocks {
+				err = HandleNextBlock(fs.db, chain, fs.pool, block, fs.engine, fs.sigs)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = fs.advance(func(p *figaro.SyncProgress) {
+			p.Blocks = chain.Depth
+			if best > p.Target {
+				p.Target = best
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return fs.advance(func(p *figaro.SyncProgress) { p.Stage = figaro.SyncDone })
+}
+
+// advance updates the progress, saving it along with any other writes in a single batch.
+func (fs *FastSync) advance(update func(p *figaro.SyncProgress), writes ...func() error) error {
+	fs.db.FigDB.Store.Batch()
+	defer fs.db.FigDB.Store.Discard() // noop if Write is called upon success, otherwise will discard
+
+	progress := fs.Progress()
+	update(&progress)
+	for _, write := range writes {
+		err := write()
+		if err != nil {
+			return err
+		}
+	}
+	err := fs.db.SaveSyncProgress(&progress)
+	if err != nil {
+		return err
+	}
+	err = fs.db.FigDB.Store.Write()
+	if err != nil {
+		return err
+	}
+	fs.setProgress(progress)
+	return nil
+}
+
+func (fs *FastSync) setProgress(progress figaro.SyncProgress) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.progress = progress
+}
+
+// bestDepth returns the deepest chain advertised by a peer, or 0 if no peer advertised a chain
+// at least depth blocks deep.
+func (fs *FastSync) bestDepth(depth uint64) uint64 {
+	best := fs.peers.Best(depth)
+	if len(best) == 0 {
+		return 0
+	}
+	status := fs.peers.Status(best[0])
+	if status == nil {
+		return 0
+	}
+	return status.Depth
+}
+
+// canonicalHeader fetches the header at number in the canonical, or header, chain.
+func (fs *FastSync) canonicalHeader(number uint64) (*figaro.BlockHeader, error) {
+	id, err := fs.db.FetchChainBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) == 0 {
+		return nil, ErrInvalidHeaderChain
+	}
+	return fs.db.FetchBlockHeader(id)
+}
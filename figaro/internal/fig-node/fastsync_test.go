@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// sealedTestBlock returns block number with txs, sealed in a db of its own, as a peer serves it.
+func sealedTestBlock(t *testing.T, number uint64, txs []*figaro.Transaction) *figaro.Block {
+	bl := &figaro.Block{BlockHeader: &figaro.BlockHeader{Number: number}, Transactions: txs}
+	for _, tx := range txs {
+		bl.Commits = append(bl.Commits, figaro.Commit(tx.ID))
+	}
+	err := bl.Seal(figdb.NewMem(0, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl.ID, err = bl.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bl
+}
+
+func TestFastSyncSaveBlockContents(t *testing.T) {
+	honest := sealedTestBlock(t, 5, []*figaro.Transaction{signedTestTx(t, 1, 1), signedTestTx(t, 1, 2)})
+	// Its contents match its roots, but a tx cannot be committed in the block that includes it
+	invalid := sealedTestBlock(t, 6, []*figaro.Transaction{signedTestTx(t, 6, 3)})
+
+	db := figdb.NewMem(0, 16)
+	for _, bl := range []*figaro.Block{honest, invalid} {
+		err := db.SaveChainBlock(bl.Number, bl.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := NewFastSync(nil, nil, db, figaro.NewTxPool(figaro.DefaultTxPoolConfig), nil, nil)
+
+	// The txs of another block under the canonical header
+	swapped := *honest
+	swapped.Transactions = []*figaro.Transaction{honest.Transactions[0], signedTestTx(t, 1, 4)}
+	if err := fs.saveBlockContents(&swapped); err != ErrInvalidBlockContents {
+		t.Errorf("saveBlockContents() of swapped txs = %v, want %v", err, ErrInvalidBlockContents)
+	}
+	// A block that is not the canonical block of its number
+	fork := sealedTestBlock(t, 5, honest.Transactions[:1])
+	if err := fs.saveBlockContents(fork); err != ErrInvalidHeaderChain {
+		t.Errorf("saveBlockContents() of a fork = %v, want %v", err, ErrInvalidHeaderChain)
+	}
+	if err := fs.saveBlockContents(invalid); err != ErrInvalidBlockContents {
+		t.Errorf("saveBlockContents() of invalid contents = %v, want %v", err, ErrInvalidBlockContents)
+	}
+	bl, err := db.FetchBlock(honest.ID)
+	if err == nil && bl != nil {
+		t.Fatal("block saved before its contents were verified")
+	}
+
+	err = fs.saveBlockContents(honest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl, err = db.FetchBlock(honest.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bl == nil || len(bl.Transactions) != 2 || !bl.HasCommit(honest.Transactions[1].ID) {
+		t.Errorf("saved block = %+v, want the honest block", bl)
+	}
+}
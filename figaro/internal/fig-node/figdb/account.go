@@ -27,6 +27,7 @@ func (db *DB) SaveAccount(root figaro.Root, account *figaro.Account) (newroot fi
 
 // FetchAccount returns an account from the database
 func (db *DB) FetchAccount(root figaro.Root, address figaro.Address) (account *figaro.Account, err error) {
+	account = &figaro.Account{}
 	var buf []byte
 	buf, err = db.State.Get(root, address)
 	if err != nil {
@@ -44,6 +45,7 @@ func (db *DB) FetchAccount(root figaro.Root, address figaro.Address) (account *f
 
 // ProveAccount returns an account from the database, along with a proof
 func (db *DB) ProveAccount(root figaro.Root, address figaro.Address) (account *figaro.Account, proof [][][]byte, err error) {
+	account = &figaro.Account{}
 	var buf []byte
 	buf, proof, err = db.State.GetAndProve(root, address)
 	if err != nil {
@@ -59,29 +61,47 @@ func (db *DB) ProveAccount(root figaro.Root, address figaro.Address) (account *f
 	return
 }
 
-// ValidateAccount validates an account against a proof.
+// ValidateAccount validates an account against a proof. An empty account is also valid if
+// the proof shows that there is no account at its address, as FetchAccount returns.
 func (db *DB) ValidateAccount(root figaro.Root, account *figaro.Account, proof [][][]byte) bool {
 	buf, err := account.Encode()
 	if err != nil {
 		return false
 	}
-	return fdb.ValidateState(root, account.Address, buf, proof)
+	if fdb.ValidateState(root, account.Address, buf, proof) {
+		return true
+	}
+	return isEmptyAccount(account) && fdb.ValidateState(root, account.Address, nil, proof)
+}
+
+func isEmptyAccount(account *figaro.Account) bool {
+	return account.Nonce == 0 && !account.Bonded && account.Stake == 0 && account.Balance == 0 &&
+		len(account.StorageRoot) == 0 && len(account.Code) == 0
 }
 
 // SaveAccountStorage saves binary key/value pair to the account's storage.
 // Requires passing the world state root as the first param, and returns the new
 // world state root created as a result of the account storage root change.
 func (db *DB) SaveAccountStorage(root figaro.Root, account *figaro.Account, key, data []byte) (newroot figaro.Root, err error) {
-	var storageroot []byte
-	storageroot, err = db.State.Set(account.StorageRoot, key, data)
+	err = db.SetAccountStorage(account, key, data)
 	if err != nil {
 		return
 	}
-	account.StorageRoot = storageroot
 	newroot, err = db.SaveAccount(root, account)
 	return
 }
 
+// SetAccountStorage saves binary key/value pair to the account's storage, updating the
+// account StorageRoot without saving the account to the world state.
+func (db *DB) SetAccountStorage(account *figaro.Account, key, data []byte) error {
+	storageroot, err := db.State.Set(account.StorageRoot, key, data)
+	if err != nil {
+		return err
+	}
+	account.StorageRoot = storageroot
+	return nil
+}
+
 // FetchAccountStorage fetches a value at key in the account storage root.
 func (db *DB) FetchAccountStorage(account *figaro.Account, key []byte) ([]byte, error) {
 	return db.State.Get(account.StorageRoot, key)
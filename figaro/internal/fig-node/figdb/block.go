@@ -44,13 +44,42 @@ func (db *DB) SaveBlock(block *figaro.Block) error {
 	return nil
 }
 
+// SaveBlockHeader saves just a block header, for a block whose contents are not known. Unlike
+// SaveBlock, the header is not cached, since it cannot be fetched as a full block.
+func (db *DB) SaveBlockHeader(header *figaro.BlockHeader) error {
+	value, err := header.Encode()
+	if err != nil {
+		return err
+	}
+	return db.Store.Set(hasher.Hash256(blockprefix[:], header.ID), value)
+}
+
+// ArchiveBlock saves a block received from the network along with its commits and transactions,
+// so that it can be hydrated before it has been synced.
+func (db *DB) ArchiveBlock(block *figaro.Block) error {
+	_, err := db.ArchiveCommits(block.Commits)
+	if err != nil {
+		return err
+	}
+	_, err = db.ArchiveTransactions(block.Transactions)
+	if err != nil {
+		return err
+	}
+	_, err = db.ArchiveEvidence(block.Evidence)
+	if err != nil {
+		return err
+	}
+	return db.SaveBlock(block)
+}
+
 // FetchBlockHeader fetches just the block header by ID.
 func (db *DB) FetchBlockHeader(id figaro.BlockHash) (header *figaro.BlockHeader, err error) {
 	// First check the cache for a BigBlock
 	// and just return its header if it exists
 	key := hasher.Hash256(blockprefix[:], id)
 	if item, ok := db.blockcache.Get(key); ok {
-		*header = item.(*blockCacheItem).header
+		h := item.(*blockCacheItem).header
+		header = &h
 		return
 	}
 	// Fetch the header from the store
@@ -59,6 +88,7 @@ func (db *DB) FetchBlockHeader(id figaro.BlockHash) (header *figaro.BlockHeader,
 	if err != nil {
 		return
 	}
+	header = &figaro.BlockHeader{ID: id}
 	err = header.Decode(b)
 	if err != nil {
 		return
@@ -71,7 +101,8 @@ func (db *DB) FetchCompBlock(id figaro.BlockHash) (cblock *figaro.CompBlock, err
 	// First check the cache for a BigBlock
 	key := hasher.Hash256(blockprefix[:], id)
 	if item, ok := db.blockcache.Get(key); ok {
-		*cblock = item.(*blockCacheItem).comp
+		c := item.(*blockCacheItem).comp
+		cblock = &c
 		return
 	}
 	// Get the full block and then compress it
@@ -92,7 +123,8 @@ func (db *DB) FetchRefBlock(id figaro.BlockHash) (rblock *figaro.RefBlock, err e
 	// First check the cache for a BigBlock
 	key := hasher.Hash256(blockprefix[:], id)
 	if item, ok := db.blockcache.Get(key); ok {
-		*rblock = item.(*blockCacheItem).ref
+		r := item.(*blockCacheItem).ref
+		rblock = &r
 		return
 	}
 	// Otherwise fetch and hydrate the block
@@ -115,7 +147,8 @@ func (db *DB) FetchBlock(id figaro.BlockHash) (block *figaro.Block, err error) {
 	// First check the cache for a BigBlock
 	key := hasher.Hash256(blockprefix[:], id)
 	if item, ok := db.blockcache.Get(key); ok {
-		*block = item.(*blockCacheItem).block
+		b := item.(*blockCacheItem).block
+		block = &b
 		return
 	}
 	header, err := db.FetchBlockHeader(id)
@@ -129,7 +162,7 @@ func (db *DB) FetchBlock(id figaro.BlockHash) (block *figaro.Block, err error) {
 // HydrateBlock creates a block from a BlockHeader by retreiving missing
 // data from the database.
 func (db *DB) HydrateBlock(header *figaro.BlockHeader) (block *figaro.Block, err error) {
-	block.BlockHeader = header
+	block = &figaro.Block{BlockHeader: header}
 	block.Commits, err = db.RetrieveCommits(block.CommitsRoot)
 	if err != nil {
 		return
@@ -138,6 +171,10 @@ func (db *DB) HydrateBlock(header *figaro.BlockHeader) (block *figaro.Block, err
 	if err != nil {
 		return
 	}
+	block.Evidence, err = db.RetrieveEvidence(block.EvidenceRoot)
+	if err != nil {
+		return
+	}
 	err = block.SetBlooms()
 	return
 }
@@ -3,6 +3,7 @@ package figdb
 
 import (
 	"crypto/md5"
+	"encoding/binary"
 
 	"github.com/figaro-tech/go-fig-crypto/hasher"
 	"github.com/figaro-tech/go-figaro/figaro"
@@ -26,8 +27,7 @@ func (db *DB) SaveChain(chain *figaro.Chain) error {
 	if err != nil {
 		return err
 	}
-	key := hasher.Hash256(chainprefix[:], []byte(string(chain.Depth)))
-	return db.Store.Set(key, chain.Head)
+	return db.Store.Set(chainIndexKey(chain.Depth), chain.Head)
 }
 
 // FetchChain fetches the canonical chain.
@@ -37,13 +37,32 @@ func (db *DB) FetchChain() (chain *figaro.Chain, err error) {
 	if err != nil || len(b) == 0 {
 		return
 	}
-	chain.Decode(b)
+	chain = &figaro.Chain{}
+	err = chain.Decode(b)
 	return
 }
 
 // FetchChainBlock fetches the Block at index in the canonical chain.
 func (db *DB) FetchChainBlock(index uint64) (bhash figaro.BlockHash, err error) {
-	key := hasher.Hash256(chainprefix[:], []byte(string(index)))
-	bhash, err = db.Store.Get(key)
+	bhash, err = db.Store.Get(chainIndexKey(index))
 	return
 }
+
+// SaveChainBlock saves the Block at index in the canonical chain, without moving the chain head.
+// This is used to index a verified header chain before its blocks are synced.
+func (db *DB) SaveChainBlock(index uint64, bhash figaro.BlockHash) error {
+	return db.Store.Set(chainIndexKey(index), bhash)
+}
+
+// DeleteChainBlock deletes the Block at index from the canonical chain index, without moving the
+// chain head. This is used to drop the blocks of an abandoned branch above the chain head.
+func (db *DB) DeleteChainBlock(index uint64) error {
+	return db.Store.Delete(chainIndexKey(index))
+}
+
+// chainIndexKey returns the key for the canonical block hash at index.
+func chainIndexKey(index uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], index)
+	return hasher.Hash256(chainprefix[:], b[:])
+}
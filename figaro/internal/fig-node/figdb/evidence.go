@@ -0,0 +1,40 @@
+// Package figdb implements figaro domain specific wrappers for figdb
+package figdb
+
+import (
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// ArchiveEvidence archives Evidence, returning the merkle root of the archive.
+func (db *DB) ArchiveEvidence(evidence []*figaro.Evidence) (root figaro.Root, err error) {
+	encoded := make([][]byte, len(evidence))
+	var e []byte
+	for i, ev := range evidence {
+		e, err = ev.Encode()
+		if err != nil {
+			return
+		}
+		encoded[i] = e
+	}
+	root, err = db.Archive.Save(encoded)
+	return
+}
+
+// RetrieveEvidence retrieves an archive of Evidence from a merkle root.
+func (db *DB) RetrieveEvidence(root figaro.Root) (evidence []*figaro.Evidence, err error) {
+	var encoded [][]byte
+	encoded, err = db.Archive.Retrieve(root)
+	if err != nil {
+		return
+	}
+	evidence = make([]*figaro.Evidence, len(encoded))
+	for i, e := range encoded {
+		ev := &figaro.Evidence{}
+		err = ev.Decode(e)
+		if err != nil {
+			return
+		}
+		evidence[i] = ev
+	}
+	return
+}
@@ -0,0 +1,25 @@
+// Package figdb implements figaro domain specific wrappers for figdb
+package figdb
+
+import (
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+var fraudprefix = hasher.Hash256([]byte("figaro/fraud"))
+
+// SaveFraudBlock records that the block for id is fraudulent.
+func (db *DB) SaveFraudBlock(id figaro.BlockHash) error {
+	return db.Store.Set(fraudKey(id), []byte{1})
+}
+
+// HasFraudBlock returns whether the block for id was recorded as fraudulent.
+func (db *DB) HasFraudBlock(id figaro.BlockHash) (bool, error) {
+	b, err := db.Store.Get(fraudKey(id))
+	return len(b) > 0, err
+}
+
+// fraudKey returns the key for the fraud record of the block for id.
+func fraudKey(id figaro.BlockHash) []byte {
+	return hasher.Hash256(fraudprefix, id)
+}
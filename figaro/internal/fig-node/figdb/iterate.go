@@ -0,0 +1,317 @@
+// Package figdb implements figaro domain specific wrappers for figdb
+package figdb
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/figaro-tech/go-fig-buf"
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+var (
+	// ErrInvalidStateNode is returned when a state trie node cannot be decoded.
+	ErrInvalidStateNode = errors.New("figdb state: invalid state trie node")
+	// ErrMissingStateNode is returned when a state trie node is not in the Store.
+	ErrMissingStateNode = errors.New("figdb state: missing state trie node")
+)
+
+// The state tries are Merkle Patricia tries, whose nodes are saved in the Store under their
+// hasher.Hash256, each encoded as a figbuf list of byte strings. A branch node has a child for
+// each of the 16 nibbles, followed by the value of the key ending at the branch, if any. A short
+// node has a hex-prefix encoded key path, followed by either a value, for a leaf, or a child, for
+// an extension. A child reference shorter than a hash is the encoded child node itself.
+const (
+	branchNodeSize = 17
+	shortNodeSize  = 2
+	stateHashSize  = 32
+
+	hexPrefixLeaf = 2
+	hexPrefixOdd  = 1
+)
+
+// IterateAccounts calls fn for every account in the world state at root, in address order,
+// stopping at the first error.
+func (db *DB) IterateAccounts(root figaro.Root, fn func(account *figaro.Account) error) error {
+	return db.iterateState(root, func(key, value []byte) error {
+		account := &figaro.Account{}
+		err := account.Decode(value)
+		if err != nil {
+			return err
+		}
+		account.Address = append(figaro.Address{}, key...)
+		return fn(account)
+	})
+}
+
+// IterateAccountStorage calls fn for every key/value pair in the account storage, in key order,
+// stopping at the first error.
+func (db *DB) IterateAccountStorage(account *figaro.Account, fn func(key, value []byte) error) error {
+	return db.iterateState(account.StorageRoot, fn)
+}
+
+// iterateState walks the state trie at root depth first, calling fn for every key/value pair in
+// key order.
+func (db *DB) iterateState(root []byte, fn func(key, value []byte) error) error {
+	if len(root) == 0 {
+		return nil
+	}
+	return db.walkStateNode(root, nil, fn)
+}
+
+// walkStateNode walks the subtrie at ref, whose keys all start with the nibbles in path.
+func (db *DB) walkStateNode(ref []byte, path []byte, fn func(key, value []byte) error) error {
+	node, err := db.resolveStateNode(ref)
+	if err != nil {
+		return err
+	}
+	switch len(node) {
+	case branchNodeSize:
+		if len(node[branchNodeSize-1]) > 0 {
+			key, err := nibblesToKey(path)
+			if err != nil {
+				return err
+			}
+			err = fn(key, node[branchNodeSize-1])
+			if err != nil {
+				return err
+			}
+		}
+		for i, child := range node[:branchNodeSize-1] {
+			if len(child) == 0 {
+				continue
+			}
+			err = db.walkStateNode(child, appendNibbles(path, byte(i)), fn)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case shortNodeSize:
+		nibbles, leaf, err := decodeHexPrefix(node[0])
+		if err != nil {
+			return err
+		}
+		path = appendNibbles(path, nibbles...)
+		if !leaf {
+			return db.walkStateNode(node[1], path, fn)
+		}
+		key, err := nibblesToKey(path)
+		if err != nil {
+			return err
+		}
+		return fn(key, node[1])
+	default:
+		return ErrInvalidStateNode
+	}
+}
+
+// resolveStateNode returns the decoded node for a child reference, fetching it from the Store
+// unless it is inlined.
+func (db *DB) resolveStateNode(ref []byte) ([][]byte, error) {
+	if len(ref) < stateHashSize {
+		return decodeStateNode(ref)
+	}
+	b, err := db.FetchStateNode(ref)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStateNode(b)
+}
+
+// FetchStateNode fetches the encoded state trie node saved under hash, returning
+// ErrMissingStateNode if it is not in the Store.
+func (db *DB) FetchStateNode(hash []byte) ([]byte, error) {
+	b, err := db.Store.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, ErrMissingStateNode
+	}
+	return b, nil
+}
+
+// HasStateNode returns whether the state trie node saved under hash is in the Store.
+func (db *DB) HasStateNode(hash []byte) (bool, error) {
+	_, err := db.FetchStateNode(hash)
+	if err == ErrMissingStateNode {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SaveStateNode saves an encoded state trie node under its hash, returning the hash. The node
+// must decode, so that its children can be walked.
+func (db *DB) SaveStateNode(b []byte) ([]byte, error) {
+	_, err := decodeStateNode(b)
+	if err != nil {
+		return nil, err
+	}
+	hash := hasher.Hash256(b)
+	return hash, db.Store.Set(hash, b)
+}
+
+// SyncStatePath makes sure that every node on the path to key in the state trie at root is in
+// the Store, so that key can be read, or proven absent. Missing nodes are requested with fetch,
+// and saved once they match the hash they were requested by.
+func (db *DB) SyncStatePath(root, key []byte, fetch func(hash []byte) ([]byte, error)) error {
+	if len(root) == 0 {
+		return nil
+	}
+	path := make([]byte, 0, 2*len(key))
+	for _, c := range key {
+		path = append(path, c>>4, c&0x0f)
+	}
+	ref := root
+	for {
+		var node [][]byte
+		var err error
+		if len(ref) < stateHashSize {
+			node, err = decodeStateNode(ref)
+		} else {
+			node, err = db.syncStateNode(ref, fetch)
+		}
+		if err != nil {
+			return err
+		}
+		switch len(node) {
+		case branchNodeSize:
+			if len(path) == 0 || len(node[path[0]]) == 0 {
+				return nil
+			}
+			ref, path = node[path[0]], path[1:]
+		case shortNodeSize:
+			nibbles, leaf, err := decodeHexPrefix(node[0])
+			if err != nil {
+				return err
+			}
+			if leaf || len(nibbles) > len(path) || !bytes.Equal(nibbles, path[:len(nibbles)]) {
+				return nil
+			}
+			ref, path = node[1], path[len(nibbles):]
+		}
+	}
+}
+
+// syncStateNode returns the decoded node saved under hash, requesting it with fetch if it is not
+// in the Store.
+func (db *DB) syncStateNode(hash []byte, fetch func(hash []byte) ([]byte, error)) ([][]byte, error) {
+	b, err := db.FetchStateNode(hash)
+	if err == ErrMissingStateNode {
+		b, err = fetch(hash)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(hasher.Hash256(b), hash) {
+			return nil, ErrInvalidStateNode
+		}
+		_, err = db.SaveStateNode(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeStateNode(b)
+}
+
+// StateNodeChildren returns the hashes of the children of an encoded state trie node that are
+// saved in the Store, and the leaf values of the node, including those of its inlined children.
+func StateNodeChildren(b []byte) (children [][]byte, values [][]byte, err error) {
+	node, err := decodeStateNode(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	var refs [][]byte
+	switch len(node) {
+	case branchNodeSize:
+		refs = node[:branchNodeSize-1]
+		if len(node[branchNodeSize-1]) > 0 {
+			values = append(values, node[branchNodeSize-1])
+		}
+	case shortNodeSize:
+		_, leaf, err := decodeHexPrefix(node[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		if leaf {
+			values = append(values, node[1])
+		} else {
+			refs = node[1:]
+		}
+	}
+	for _, ref := range refs {
+		switch {
+		case len(ref) == 0:
+		case len(ref) < stateHashSize:
+			c, v, err := StateNodeChildren(ref)
+			if err != nil {
+				return nil, nil, err
+			}
+			children = append(children, c...)
+			values = append(values, v...)
+		default:
+			children = append(children, ref)
+		}
+	}
+	return children, values, nil
+}
+
+func decodeStateNode(b []byte) ([][]byte, error) {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	var node [][]byte
+	err := dec.DecodeList(b, func(r []byte) []byte {
+		var item []byte
+		for len(r) > 0 {
+			item, r = dec.DecodeNextBytes(r)
+			node = append(node, item)
+		}
+		return r
+	})
+	if err != nil || (len(node) != branchNodeSize && len(node) != shortNodeSize) {
+		return nil, ErrInvalidStateNode
+	}
+	return node, nil
+}
+
+// decodeHexPrefix decodes a hex-prefix encoded key path into its nibbles, and whether it ends
+// in a leaf.
+func decodeHexPrefix(b []byte) (nibbles []byte, leaf bool, err error) {
+	if len(b) == 0 {
+		return nil, false, ErrInvalidStateNode
+	}
+	flags := b[0] >> 4
+	if flags > hexPrefixLeaf|hexPrefixOdd {
+		return nil, false, ErrInvalidStateNode
+	}
+	leaf = flags&hexPrefixLeaf != 0
+	nibbles = make([]byte, 0, 2*len(b))
+	if flags&hexPrefixOdd != 0 {
+		nibbles = append(nibbles, b[0]&0x0f)
+	}
+	for _, c := range b[1:] {
+		nibbles = append(nibbles, c>>4, c&0x0f)
+	}
+	return nibbles, leaf, nil
+}
+
+// appendNibbles returns a new path of path followed by nibbles, leaving path untouched.
+func appendNibbles(path []byte, nibbles ...byte) []byte {
+	p := make([]byte, 0, len(path)+len(nibbles))
+	p = append(p, path...)
+	return append(p, nibbles...)
+}
+
+// nibblesToKey packs a path of nibbles into the key bytes it spells.
+func nibblesToKey(nibbles []byte) ([]byte, error) {
+	if len(nibbles)%2 != 0 {
+		return nil, ErrInvalidStateNode
+	}
+	key := make([]byte, len(nibbles)/2)
+	for i := range key {
+		key[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+	return key, nil
+}
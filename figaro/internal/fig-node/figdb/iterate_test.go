@@ -0,0 +1,44 @@
+package figdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeHexPrefix(t *testing.T) {
+	tests := []struct {
+		in      []byte
+		nibbles []byte
+		leaf    bool
+		err     error
+	}{
+		{[]byte{0x00, 0x12}, []byte{1, 2}, false, nil},
+		{[]byte{0x13, 0x45}, []byte{3, 4, 5}, false, nil},
+		{[]byte{0x20, 0xab}, []byte{0xa, 0xb}, true, nil},
+		{[]byte{0x3c}, []byte{0xc}, true, nil},
+		{[]byte{0x20}, []byte{}, true, nil},
+		{[]byte{0x40}, nil, false, ErrInvalidStateNode},
+		{nil, nil, false, ErrInvalidStateNode},
+	}
+	for _, tt := range tests {
+		nibbles, leaf, err := decodeHexPrefix(tt.in)
+		if err != tt.err {
+			t.Errorf("decodeHexPrefix(%x) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && (!bytes.Equal(nibbles, tt.nibbles) || leaf != tt.leaf) {
+			t.Errorf("decodeHexPrefix(%x) = %x, %v, want %x, %v", tt.in, nibbles, leaf, tt.nibbles, tt.leaf)
+		}
+	}
+}
+
+func TestNibblesToKey(t *testing.T) {
+	key, err := nibblesToKey(appendNibbles([]byte{0xa, 0xb}, 0xc, 0xd))
+	if err != nil || !bytes.Equal(key, []byte{0xab, 0xcd}) {
+		t.Errorf("nibblesToKey = %x, %v, want abcd", key, err)
+	}
+	_, err = nibblesToKey([]byte{1, 2, 3})
+	if err != ErrInvalidStateNode {
+		t.Errorf("nibblesToKey of an odd path error = %v, want %v", err, ErrInvalidStateNode)
+	}
+}
@@ -0,0 +1,30 @@
+// Package figdb implements figaro domain specific wrappers for figdb
+package figdb
+
+import (
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+var peerbans = hasher.Hash256([]byte("figaro/peerbans"))
+
+// SavePeerBans saves the list of banned peers, replacing any previous list. Bans are saved apart
+// from the chain, in a DB of their own, since they are written while the chain is batched.
+func (db *DB) SavePeerBans(bans figaro.PeerBans) error {
+	b, err := bans.Encode()
+	if err != nil {
+		return err
+	}
+	return db.Store.Set(peerbans, b)
+}
+
+// FetchPeerBans fetches the list of banned peers, which is empty if none were saved.
+func (db *DB) FetchPeerBans() (bans figaro.PeerBans, err error) {
+	var b []byte
+	b, err = db.Store.Get(peerbans)
+	if err != nil || len(b) == 0 {
+		return
+	}
+	err = bans.Decode(b)
+	return
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 
 	"github.com/figaro-tech/go-fig-crypto/hasher"
+	fdb "github.com/figaro-tech/go-fig-db"
 	"github.com/figaro-tech/go-figaro/figaro"
 )
 
@@ -31,6 +32,7 @@ func (db *DB) FetchReceipt(txid figaro.TxHash) (r *figaro.Receipt, err error) {
 	if err != nil || len(b) == 0 {
 		return
 	}
+	r = &figaro.Receipt{}
 	err = r.Decode(b)
 	if err != nil {
 		return
@@ -38,3 +40,40 @@ func (db *DB) FetchReceipt(txid figaro.TxHash) (r *figaro.Receipt, err error) {
 	r.TxID = txid
 	return
 }
+
+// ArchiveReceipts archives the Receipts of a block, returning the merkle root of the archive.
+func (db *DB) ArchiveReceipts(receipts []*figaro.Receipt) (root figaro.Root, err error) {
+	encoded := make([][]byte, len(receipts))
+	var e []byte
+	for i, r := range receipts {
+		e, err = r.Encode()
+		if err != nil {
+			return
+		}
+		encoded[i] = e
+	}
+	root, err = db.Archive.Save(encoded)
+	return
+}
+
+// GetAndProveReceipt gets the Receipt at index in from the archive in the merkle root, providing a merkle proof.
+// The TxID is not part of the archive, and is left unset.
+func (db *DB) GetAndProveReceipt(root figaro.Root, index int) (r *figaro.Receipt, proof [][]byte, err error) {
+	var e []byte
+	e, proof, err = db.Archive.GetAndProve(root, index)
+	if err != nil || len(e) == 0 {
+		return
+	}
+	r = &figaro.Receipt{}
+	err = r.Decode(e)
+	return
+}
+
+// ValidateReceipt validates whether a proof is valid for a given Receipt in root at index.
+func (db *DB) ValidateReceipt(root figaro.Root, index int, r figaro.Receipt, proof [][]byte) bool {
+	e, err := r.Encode()
+	if err != nil {
+		return false
+	}
+	return fdb.ValidateArchive(root, index, e, proof)
+}
@@ -0,0 +1,80 @@
+// Package figdb implements figaro domain specific wrappers for figdb
+package figdb
+
+import (
+	"github.com/figaro-tech/go-fig-buf"
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+var (
+	syncprogress   = hasher.Hash256([]byte("figaro/syncprogress"))
+	statesyncqueue = hasher.Hash256([]byte("figaro/statesyncqueue"))
+)
+
+// SaveSyncProgress saves the fast sync progress.
+func (db *DB) SaveSyncProgress(progress *figaro.SyncProgress) error {
+	b, err := progress.Encode()
+	if err != nil {
+		return err
+	}
+	return db.Store.Set(syncprogress, b)
+}
+
+// FetchSyncProgress fetches the fast sync progress, or nil if no fast sync was started.
+func (db *DB) FetchSyncProgress() (progress *figaro.SyncProgress, err error) {
+	var b []byte
+	b, err = db.Store.Get(syncprogress)
+	if err != nil || len(b) == 0 {
+		return
+	}
+	progress = &figaro.SyncProgress{}
+	err = progress.Decode(b)
+	return
+}
+
+// SaveStateSyncQueue saves the queue of state trie nodes left to download by a fast sync,
+// replacing any previous queue.
+func (db *DB) SaveStateSyncQueue(queue [][]byte) error {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	b, err := enc.EncodeList(func(buf []byte) []byte {
+		for _, item := range queue {
+			buf = enc.EncodeNextBytes(buf, item)
+		}
+		return buf
+	})
+	if err != nil {
+		return err
+	}
+	return db.Store.Set(statesyncqueue, b)
+}
+
+// FetchStateSyncQueue fetches the queue of state trie nodes left to download by a fast sync.
+// ok is false if no queue was saved.
+func (db *DB) FetchStateSyncQueue() (queue [][]byte, ok bool, err error) {
+	var b []byte
+	b, err = db.Store.Get(statesyncqueue)
+	if err != nil || len(b) == 0 {
+		return
+	}
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	queue = [][]byte{}
+	err = dec.DecodeList(b, func(r []byte) []byte {
+		var item []byte
+		for len(r) > 0 {
+			item, r = dec.DecodeNextBytes(r)
+			queue = append(queue, item)
+		}
+		return r
+	})
+	return queue, err == nil, err
+}
+
+// DeleteStateSyncQueue deletes the queue of state trie nodes left to download by a fast sync.
+func (db *DB) DeleteStateSyncQueue() error {
+	return db.Store.Delete(statesyncqueue)
+}
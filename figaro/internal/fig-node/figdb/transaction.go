@@ -47,6 +47,7 @@ func (db *DB) GetTransaction(root figaro.Root, index int) (tx *figaro.Transactio
 	if err != nil || len(e) == 0 {
 		return
 	}
+	tx = &figaro.Transaction{}
 	err = tx.Decode(e)
 	return
 }
@@ -58,6 +59,7 @@ func (db *DB) GetAndProveTransaction(root figaro.Root, index int) (tx *figaro.Tr
 	if err != nil || len(e) == 0 {
 		return
 	}
+	tx = &figaro.Transaction{}
 	err = tx.Decode(e)
 	return
 }
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"bytes"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// ProveTxSignatureFraud builds a fraud proof for the transaction at index in the block,
+// which should have an invalid signature. The block contents must be archived in db.
+func ProveTxSignatureFraud(db *figdb.DB, header *figaro.BlockHeader, index int) (*figaro.FraudProof, error) {
+	tx, proof, err := db.GetAndProveTransaction(header.TransactionsRoot, index)
+	if err != nil {
+		return nil, err
+	}
+	return &figaro.FraudProof{
+		Type:    figaro.FraudTxSignature,
+		Header:  header,
+		Index:   uint64(index),
+		Tx:      tx,
+		TxProof: proof,
+	}, nil
+}
+
+// ProveCommitsRootFraud builds a fraud proof for the invalid commit at index in the block.
+// The block contents must be archived in db.
+func ProveCommitsRootFraud(db *figdb.DB, header *figaro.BlockHeader, index int) (*figaro.FraudProof, error) {
+	commit, proof, err := db.GetAndProveCommit(header.CommitsRoot, index)
+	if err != nil {
+		return nil, err
+	}
+	return &figaro.FraudProof{
+		Type:        figaro.FraudCommitsRoot,
+		Header:      header,
+		Index:       uint64(index),
+		Commit:      commit,
+		CommitProof: proof,
+	}, nil
+}
+
+// ProveTransactionsRootFraud builds a fraud proof for the transaction at index in the block,
+// which cannot be included in the block. The block contents must be archived in db.
+func ProveTransactionsRootFraud(db *figdb.DB, header *figaro.BlockHeader, index int) (*figaro.FraudProof, error) {
+	fp, err := ProveTxSignatureFraud(db, header, index)
+	if err != nil {
+		return nil, err
+	}
+	fp.Type = figaro.FraudTransactionsRoot
+	return fp, nil
+}
+
+// ProveReceiptsRootFraud builds a fraud proof for the receipt at index in the block, which disagrees with
+// the block or with the receipt before it. The receipts of the block must be archived in db.
+func ProveReceiptsRootFraud(db *figdb.DB, header *figaro.BlockHeader, index int) (*figaro.FraudProof, error) {
+	receipt, proof, err := db.GetAndProveReceipt(header.ReceiptsRoot, index)
+	if err != nil {
+		return nil, err
+	}
+	fp := &figaro.FraudProof{
+		Type:         figaro.FraudReceiptsRoot,
+		Header:       header,
+		Index:        uint64(index),
+		Receipt:      receipt,
+		ReceiptProof: proof,
+	}
+	if index > 0 {
+		fp.PrevReceipt, fp.PrevReceiptProof, err = db.GetAndProveReceipt(header.ReceiptsRoot, index-1)
+	} else {
+		fp.ParentHeader, err = db.FetchBlockHeader(header.ParentBlock)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// ProveStateRootFraud builds a fraud proof for the state transition of the transaction at index in the block.
+// The block contents and receipts, and the states before and after the transaction, must be available in db.
+func ProveStateRootFraud(db *figdb.DB, header *figaro.BlockHeader, index int) (*figaro.FraudProof, error) {
+	tx, txproof, err := db.GetAndProveTransaction(header.TransactionsRoot, index)
+	if err != nil {
+		return nil, err
+	}
+	receipt, rproof, err := db.GetAndProveReceipt(header.ReceiptsRoot, index)
+	if err != nil {
+		return nil, err
+	}
+	cblockhash, err := db.FetchChainBlock(tx.CommitBlock)
+	if err != nil {
+		return nil, err
+	}
+	cblock, err := db.FetchBlock(cblockhash)
+	if err != nil {
+		return nil, err
+	}
+	fp := &figaro.FraudProof{
+		Type:         figaro.FraudStateRoot,
+		Header:       header,
+		Index:        uint64(index),
+		Tx:           tx,
+		TxProof:      txproof,
+		Receipt:      receipt,
+		ReceiptProof: rproof,
+		CommitHeader: cblock.BlockHeader,
+	}
+	for i, c := range cblock.Commits {
+		if bytes.Equal(c, tx.ID) {
+			fp.TxCommitIndex = uint64(i)
+			_, fp.TxCommitProof, err = db.GetAndProveCommit(cblock.CommitsRoot, i)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	addresses := []figaro.Address{tx.From, tx.To}
+	if !cblock.Beneficiary.IsZeroAddress() {
+		addresses = append(addresses, cblock.Beneficiary)
+	}
+	if !header.Beneficiary.IsZeroAddress() {
+		addresses = append(addresses, header.Beneficiary)
+	}
+	fp.PreAccounts, err = proveAccounts(db, receipt.PrevStateRoot, addresses)
+	if err != nil {
+		return nil, err
+	}
+	fp.PostAccounts, err = proveAccounts(db, receipt.StateRoot, addresses)
+	if err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// proveAccounts proves the accounts at addresses in root.
+func proveAccounts(db *figdb.DB, root figaro.Root, addresses []figaro.Address) ([]figaro.AccountProof, error) {
+	proofs := make([]figaro.AccountProof, len(addresses))
+	for i, addr := range addresses {
+		acc, proof, err := db.ProveAccount(root, addr)
+		if err != nil {
+			return nil, err
+		}
+		proofs[i] = figaro.AccountProof{Account: acc, Proof: proof}
+	}
+	return proofs, nil
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"errors"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// ErrChainExists is returned when initializing a database that already has a chain.
+var ErrChainExists = errors.New("fig-node: chain already initialized")
+
+// InitGenesis builds and persists the genesis block and chain from the genesis spec,
+// returning the genesis block. It will refuse to initialize a database that already has a chain.
+func InitGenesis(db *figdb.DB, g *figaro.Genesis) (*figaro.Block, error) {
+	chain, err := db.FetchChain()
+	if err != nil {
+		return nil, err
+	}
+	if chain != nil {
+		return nil, ErrChainExists
+	}
+	db.FigDB.Store.Batch()
+	defer db.FigDB.Store.Discard() // noop if Write is called upon success, otherwise will discard
+
+	bl, err := g.ToBlock(db)
+	if err != nil {
+		return nil, err
+	}
+	err = db.SaveBlock(bl)
+	if err != nil {
+		return nil, err
+	}
+	err = db.SaveChain(g.Chain(bl.BlockHeader))
+	if err != nil {
+		return nil, err
+	}
+	return bl, db.FigDB.Store.Write()
+}
+
+// FetchGenesis fetches the genesis block hash of the canonical chain.
+func FetchGenesis(db *figdb.DB) (figaro.BlockHash, error) {
+	genesis, err := db.FetchChainBlock(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(genesis) == 0 {
+		return nil, figaro.ErrGenesisMismatch
+	}
+	return genesis, nil
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+func TestInitGenesisDeterministic(t *testing.T) {
+	g := &figaro.Genesis{
+		Config:    figaro.ChainConfig{GasLimit: 1 << 20},
+		Timestamp: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		Producers: []string{testAddress(1).Human()},
+		Alloc:     []figaro.GenesisAccount{{Address: testAddress(2).Human(), Balance: 1000, Stake: 10, Bonded: true}},
+	}
+	var ids []figaro.BlockHash
+	for i := 0; i < 2; i++ {
+		bl, err := InitGenesis(figdb.NewMem(0, 16), g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bl.Timestamp.Equal(g.Timestamp) {
+			t.Errorf("Timestamp = %v, want %v", bl.Timestamp, g.Timestamp)
+		}
+		ids = append(ids, bl.ID)
+		// Seal must not see the same clock twice
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(ids[0], ids[1]) {
+		t.Errorf("genesis IDs differ: %x, %x", ids[0], ids[1])
+	}
+}
@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+)
+
+// The gossip protocols announce new blocks, commits and transactions to every connected peer.
+// Each announcement is a single stream carrying one message, framed as in the sync protocols.
+// A peer relays a message to its own peers only once it has validated it, and only the first
+// time it sees it.
+//
+// Blocks are announced as a RefBlock, which the receiver rebuilds from the transactions in its
+// pool, requesting only the missing ones from the sender over TxsProtocol. If that fails, the full
+// block is requested over BlockProtocol.
+const (
+	// BlockGossipProtocol announces an encoded RefBlock.
+	BlockGossipProtocol protocol.ID = "/figaro/gossip/block/1.0.0"
+	// CommitGossipProtocol announces a Commit.
+	CommitGossipProtocol protocol.ID = "/figaro/gossip/commit/1.0.0"
+	// TxGossipProtocol announces an encoded, signed Transaction.
+	TxGossipProtocol protocol.ID = "/figaro/gossip/tx/1.0.0"
+)
+
+// DefaultSeenCacheSize is the number of gossip messages remembered by a Gossip, enough to cover
+// the commits and transactions of a full TxPool.
+var DefaultSeenCacheSize = 2 * figaro.DefaultTxPoolConfig.MaxTxs
+
+// gossipTimeout bounds how long we wait to announce a message to a single peer, and how long a
+// peer has to send us one.
+const gossipTimeout = 10 * time.Second
+
+// ErrInvalidGossipMessage is a self-explantory error.
+var ErrInvalidGossipMessage = errors.New("fig-node gossip: invalid gossip message")
+
+// Gossip relays blocks, commits and transactions between the node and its peers. Blocks are
+// delivered to the Node, and commits and transactions to the pool.
+type Gossip struct {
+	ctx   context.Context
+	h     host.Host
+	peers *Peers
+	db    *figdb.DB
+	pool  *figaro.TxPool
+	sigs  *SigVerifier
+	node  *Node
+	seen  *seenCache
+}
+
+// NewGossip returns a Gossip that serves the gossip protocols on h, to the peers in peers.
+// Messages from other peers are ignored.
+func NewGossip(ctx context.Context, h host.Host, peers *Peers, db *figdb.DB, pool *figaro.TxPool, sigs *SigVerifier, node *Node) *Gossip {
+	g := &Gossip{ctx: ctx, h: h, peers: peers, db: db, pool: pool, sigs: sigs, node: node, seen: newSeenCache(DefaultSeenCacheSize)}
+	h.SetStreamHandler(BlockGossipProtocol, g.handler(BlockGossipProtocol, maxRefBlockMessageSize, g.receiveBlock))
+	h.SetStreamHandler(CommitGossipProtocol, g.handler(CommitGossipProtocol, figaro.TxHashSize, g.receiveCommit))
+	h.SetStreamHandler(TxGossipProtocol, g.handler(TxGossipProtocol, maxTxMessageSize, g.receiveTx))
+	return g
+}
+
+// BroadcastBlock announces a block to all peers.
+func (g *Gossip) BroadcastBlock(block *figaro.Block) error {
+	b, err := block.Ref().Encode()
+	if err != nil {
+		return err
+	}
+	g.broadcast(BlockGossipProtocol, b)
+	return nil
+}
+
+// BroadcastCommit announces a commit to all peers.
+func (g *Gossip) BroadcastCommit(commit figaro.Commit) {
+	g.broadcast(CommitGossipProtocol, commit)
+}
+
+// BroadcastTx announces a signed transaction to all peers.
+func (g *Gossip) BroadcastTx(tx *figaro.Transaction) error {
+	b, err := tx.Encode()
+	if err != nil {
+		return err
+	}
+	g.broadcast(TxGossipProtocol, b)
+	return nil
+}
+
+// handler returns a stream handler that reads a message of up to max bytes, and relays it once
+// receive accepts it.
+// Messages already seen are dropped before they are validated, as are messages from throttled
+// peers. The sender is scored by the outcome of receive.
+func (g *Gossip) handler(pid protocol.ID, max int, receive func(from peer.ID, b []byte) error) inet.StreamHandler {
+	return func(s inet.Stream) {
+		defer s.Close()
+		from := s.Conn().RemotePeer()
+		if !g.peers.Has(from) || g.peers.Throttled(from) {
+			return
+		}
+		err := s.SetReadDeadline(time.Now().Add(gossipTimeout))
+		if err != nil {
+			s.Reset()
+			return
+		}
+		b, err := readSyncMessage(s, max)
+		if err == ErrInvalidSyncMessage {
+			// Only an oversized message is the fault of the peer, rather than of the link
+			g.peers.Report(from, err)
+		}
+		if err != nil {
+			return
+		}
+		if !g.seen.Add(b) {
+			return
+		}
+		err = receive(from, b)
+		if err != nil {
+			g.peers.Report(from, err)
+			return
+		}
+		g.peers.Reward(from)
+		g.relay(pid, b, from)
+	}
+}
+
+func (g *Gossip) receiveBlock(from peer.ID, b []byte) error {
+	rf := &figaro.RefBlock{}
+	err := rf.Decode(b)
+	if err != nil {
+		return ErrInvalidGossipMessage
+	}
+	for _, txid := range rf.TxIDs {
+		if !txid.Valid() {
+			return ErrInvalidGossipMessage
+		}
+	}
+	rf.ID, err = rf.ToHash()
+	if err != nil {
+		return err
+	}
+	if !rf.VerifySignature() {
+		return figaro.ErrInvalidBlock
+	}
+	g.peers.Advance(from, rf.Number, rf.ID)
+	if g.node.Syncing() {
+		return ErrSyncing
+	}
+	block, err := g.rebuildBlock(from, rf)
+	if err == nil {
+		err = g.node.ReceiveBlock(block)
+		if err == nil || err == ErrSyncing || err == ErrFutureBlock || err == ErrUnscheduledBlock || err == ErrTooManyFutureBlocks || err == figaro.ErrForkRejected {
+			return err
+		}
+	}
+	// The rebuilt block may differ from the original in the signatures of its pooled transactions,
+	// which are not covered by their IDs, so it is given a second chance as the full block.
+	ctx, cancel := context.WithTimeout(g.ctx, gossipTimeout)
+	defer cancel()
+	block, err = RequestBlock(ctx, g.h, from, rf.ID)
+	if err != nil {
+		return err
+	}
+	return g.node.ReceiveBlock(block)
+}
+
+// rebuildBlock rebuilds the block for a RefBlock from the pool, requesting the missing
+// transactions from the peer that sent it.
+func (g *Gossip) rebuildBlock(from peer.ID, rf *figaro.RefBlock) (*figaro.Block, error) {
+	txs := make([]*figaro.Transaction, len(rf.TxIDs))
+	var missing []figaro.TxHash
+	var indexes []int
+	for i, txid := range rf.TxIDs {
+		txs[i] = g.pool.GetTx(txid)
+		if txs[i] == nil {
+			missing = append(missing, txid)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(missing) > 0 {
+		ctx, cancel := context.WithTimeout(g.ctx, gossipTimeout)
+		defer cancel()
+		fetched, err := RequestTxs(ctx, g.h, from, rf.ID, missing)
+		if err != nil {
+			return nil, err
+		}
+		for i, tx := range fetched {
+			txs[indexes[i]] = tx
+		}
+	}
+	return rf.Fill(txs)
+}
+
+func (g *Gossip) receiveCommit(from peer.ID, b []byte) error {
+	commit := figaro.Commit(b)
+	if !figaro.TxHash(commit).Valid() {
+		return ErrInvalidGossipMessage
+	}
+	return g.pool.AddCommit(&figaro.ReceivedCommit{Commit: commit, Received: time.Now()})
+}
+
+func (g *Gossip) receiveTx(from peer.ID, b []byte) error {
+	tx := &figaro.Transaction{}
+	err := tx.Decode(b)
+	if err != nil {
+		return ErrInvalidGossipMessage
+	}
+	return AddTx(g.db, g.pool, g.sigs, tx)
+}
+
+// broadcast marks a message as seen, and relays it to all peers.
+func (g *Gossip) broadcast(pid protocol.ID, b []byte) {
+	g.seen.Add(b)
+	g.relay(pid, b, "")
+}
+
+// relay sends a message to all peers, except the one it came from.
+func (g *Gossip) relay(pid protocol.ID, b []byte, from peer.ID) {
+	for _, p := range g.peers.IDs() {
+		if p == from {
+			continue
+		}
+		go g.send(p, pid, b)
+	}
+}
+
+func (g *Gossip) send(p peer.ID, pid protocol.ID, b []byte) {
+	ctx, cancel := context.WithTimeout(g.ctx, gossipTimeout)
+	defer cancel()
+
+	s, err := newStream(ctx, g.h, p, pid)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	writeSyncMessage(s, b)
+}
+
+// seenCache remembers the hashes of gossip messages, up to a fixed size, forgetting the oldest
+// first. It is safe for concurrent use.
+type seenCache struct {
+	mu   sync.Mutex
+	set  map[string]bool
+	ring []string
+	next int
+}
+
+func newSeenCache(size int) *seenCache {
+	if size < 1 {
+		size = 1
+	}
+	return &seenCache{set: make(map[string]bool, size), ring: make([]string, size)}
+}
+
+// Add remembers a message, returning false if it was already seen.
+func (c *seenCache) Add(b []byte) bool {
+	key := string(hasher.Hash256(b))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.set[key] {
+		return false
+	}
+	if old := c.ring[c.next]; old != "" {
+		delete(c.set, old)
+	}
+	c.ring[c.next] = key
+	c.next = (c.next + 1) % len(c.ring)
+	c.set[key] = true
+	return true
+}
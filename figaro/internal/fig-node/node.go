@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// ErrSyncing is returned when a block is received from the network before the node has synced.
+var ErrSyncing = errors.New("fig-node: node is syncing")
+
+// Node advances the chain in db with the blocks received from the network and the blocks
+// produced by this node, handling a single block at a time. It is safe for concurrent use.
+type Node struct {
+	db     *figdb.DB
+	pool   *figaro.TxPool
+	engine figaro.ConsensusEngine
+	sigs   *SigVerifier
+
+	mu           sync.Mutex
+	futureblocks *figaro.BlockHeap
+	onReorg      func(*ReorgEvent)
+	syncing      int32
+}
+
+// NewNode returns a Node for the chain in db, verifying tx signatures with sigs.
+func NewNode(db *figdb.DB, pool *figaro.TxPool, engine figaro.ConsensusEngine, sigs *SigVerifier) *Node {
+	return &Node{db: db, pool: pool, engine: engine, sigs: sigs, futureblocks: figaro.NewBlockHeap()}
+}
+
+// OnReorg sets fn to be called with each chain reorganization caused by a received block. It is
+// called while the node handles the block, so it must not call back into the node.
+func (n *Node) OnReorg(fn func(*ReorgEvent)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onReorg = fn
+}
+
+// SetSyncing sets whether the node is syncing, in which case received blocks are refused, since
+// the sync writes to the chain on its own.
+func (n *Node) SetSyncing(syncing bool) {
+	var v int32
+	if syncing {
+		v = 1
+	}
+	atomic.StoreInt32(&n.syncing, v)
+}
+
+// Syncing returns whether the node is syncing.
+func (n *Node) Syncing() bool {
+	return atomic.LoadInt32(&n.syncing) == 1
+}
+
+// ReceiveBlock validates and syncs a block received from the network. A block ahead of the chain
+// head is kept only if VerifyFutureBlock passes.
+func (n *Node) ReceiveBlock(block *figaro.Block) error {
+	return n.receiveBlock(block, true)
+}
+
+// receiveBlock validates and syncs block, verifying it first if it is unsolicited and ahead of the
+// chain head. Blocks requested by the Downloader are not, since they link up to a future block.
+func (n *Node) receiveBlock(block *figaro.Block, unsolicited bool) error {
+	if n.Syncing() {
+		return ErrSyncing
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	chain, err := n.db.FetchChain()
+	if err != nil {
+		return err
+	}
+	if unsolicited && block.Number > chain.Depth+1 {
+		err = VerifyFutureBlock(n.db, chain, block.BlockHeader, n.futureblocks, n.engine)
+		if err != nil {
+			return err
+		}
+	}
+	return HandleReceiveBlock(n.db, chain, n.pool, block, n.futureblocks, n.engine, n.sigs, n.onReorg)
+}
+
+// Gap returns the range of block numbers missing between the chain head and the next future
+// block, along with the parent hash of that block. ok is false if there is no gap.
+func (n *Node) Gap() (from, to uint64, parent figaro.BlockHash, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	chain, err := n.db.FetchChain()
+	if err != nil || chain == nil {
+		return
+	}
+	next := n.futureblocks.Peek()
+	if next == nil || next.Number <= chain.Depth+1 {
+		return
+	}
+	return chain.Depth + 1, next.Number - 1, next.ParentBlock, true
+}
+
+// DropFutureBlocks drops the future blocks whose parent is parent, once peers have disagreed that
+// it is in the chain.
+func (n *Node) DropFutureBlocks(parent figaro.BlockHash) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	kept := (*n.futureblocks)[:0]
+	for _, header := range *n.futureblocks {
+		if !bytes.Equal(header.ParentBlock, parent) {
+			kept = append(kept, header)
+		}
+	}
+	*n.futureblocks = kept
+	heap.Init(n.futureblocks)
+}
+
+// ProduceBlock produces the next block, if producer is the next block producer.
+func (n *Node) ProduceBlock(producer *Producer) (*figaro.Block, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	chain, err := n.db.FetchChain()
+	if err != nil {
+		return nil, err
+	}
+	return HandleProduceBlock(n.db, chain, n.pool, n.engine, producer)
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// speculation is the result of executing a tx against the state before the block, rather than
+// the state after the txs before it.
+type speculation struct {
+	// serial is set if the tx must be executed in order, with ProcessTx
+	serial bool
+	cblock *figaro.BlockHeader
+	accs   *figaro.TxAccounts
+	valid  bool
+	// cfee and txfee are the balances credited to the beneficiaries, which commute with
+	// everything except reading the beneficiary as the From or To of a later tx
+	cfee, txfee uint64
+	gasUsed     uint64
+	totalFees   uint64
+}
+
+// ProcessTxs executes txs as the next transactions in the block, with the same result as calling
+// ProcessTx for each in turn. Transactions are first executed speculatively, in parallel, against
+// the block StateRoot. They are then committed in order, and a tx that reads an account written by
+// an earlier tx in the block is re-executed in order instead. Transactions that run contract code,
+// bond their sender, or touch the same account in more than one role, are always executed in order.
+// The speculative reads run in parallel too. Nothing writes to db until they are done, since the
+// Node handles a single block at a time, and the Store already serves concurrent reads, from the
+// RPC and gossip handlers, while a block is synced.
+func ProcessTxs(db *figdb.DB, bl *figaro.Block, txs []*figaro.Transaction) error {
+	specs := make([]*speculation, len(txs))
+	workers := runtime.NumCPU()
+	if workers > len(txs) {
+		workers = len(txs)
+	}
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(txs) {
+					return
+				}
+				specs[i] = speculate(db, bl.BlockHeader, txs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	state := figaro.NewStateOverlay(db, bl.StateRoot)
+	written := make(map[string]bool)
+	for i, tx := range txs {
+		s := specs[i]
+		if s.serial || written[string(tx.From)] || (s.valid && written[string(tx.To)]) {
+			err := ProcessTx(db, state, bl, tx)
+			if err != nil {
+				return err
+			}
+			written[string(tx.From)], written[string(tx.To)] = true, true
+			written[string(bl.Beneficiary)] = true
+			if s.cblock != nil {
+				written[string(s.cblock.Beneficiary)] = true
+			}
+			if tx.Type == figaro.BondTx {
+				// The bonded list is in the storage of the ZeroAddress account
+				written[string(figaro.ZeroAddress)] = true
+			}
+			continue
+		}
+		err := commitSpeculation(state, bl, tx, s)
+		if err != nil {
+			return err
+		}
+		for _, acc := range s.accs.Ordered() {
+			written[string(acc.Address)] = true
+		}
+	}
+	return nil
+}
+
+// speculate executes tx against the StateRoot of txblock. Any error is left to ProcessTx to
+// report, in order.
+func speculate(db *figdb.DB, txblock *figaro.BlockHeader, tx *figaro.Transaction) *speculation {
+	cblock, err := fetchCommitBlock(db, tx)
+	if err != nil {
+		return &speculation{serial: true}
+	}
+	s := &speculation{cblock: cblock.BlockHeader}
+	// Each tx has its own overlay, since overlays are not safe for concurrent use
+	s.accs, err = FetchTxAccounts(figaro.NewStateOverlay(db, txblock.StateRoot), tx, txblock, cblock.BlockHeader)
+	if err != nil || sharesAccount(s.accs) {
+		s.serial = true
+		return s
+	}
+	s.valid = figaro.CheckTx(tx, s.accs.From, txblock, cblock.BlockHeader, cblock.HasCommit(tx.ID))
+	if s.valid && (figaro.RunsCode(tx, s.accs.To) || tx.Type == figaro.BondTx) {
+		s.serial = true
+		return s
+	}
+	var cbalance, txbalance uint64
+	if s.accs.CommitBeneficiary != nil {
+		cbalance = s.accs.CommitBeneficiary.Balance
+	}
+	if s.accs.TxBeneficiary != nil {
+		txbalance = s.accs.TxBeneficiary.Balance
+	}
+	if s.valid {
+		s.gasUsed = figaro.IntrinsicGas(tx)
+		s.totalFees, err = figaro.ApplyTx(tx, s.accs, s.gasUsed, txblock, cblock.BlockHeader)
+		if err != nil {
+			s.serial = true
+			return s
+		}
+	} else {
+		s.gasUsed = figaro.InvalidTxGas(tx)
+		s.totalFees = figaro.ApplyInvalidTx(tx, s.accs, s.gasUsed, txblock, cblock.BlockHeader)
+	}
+	if s.accs.CommitBeneficiary != nil {
+		s.cfee = s.accs.CommitBeneficiary.Balance - cbalance
+	}
+	if s.accs.TxBeneficiary != nil {
+		s.txfee = s.accs.TxBeneficiary.Balance - txbalance
+	}
+	return s
+}
+
+// sharesAccount returns whether the same account appears in more than one role.
+func sharesAccount(accs *figaro.TxAccounts) bool {
+	all := []*figaro.Account{accs.From, accs.To, accs.CommitBeneficiary, accs.TxBeneficiary}
+	for i, a := range all {
+		for _, b := range all[i+1:] {
+			if a != nil && b != nil && bytes.Equal(a.Address, b.Address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commitSpeculation applies the speculative result of tx to the block and state, crediting the fees
+// to the current beneficiary accounts.
+func commitSpeculation(state *figaro.StateOverlay, bl *figaro.Block, tx *figaro.Transaction, s *speculation) error {
+	if !bl.FitsGas(tx) {
+		return figaro.ErrExceedsBlockLimit
+	}
+	accounts := []*figaro.Account{s.accs.From}
+	if s.accs.To != nil {
+		accounts = append(accounts, s.accs.To)
+	}
+	for _, credit := range []struct {
+		acc *figaro.Account
+		fee uint64
+	}{{s.accs.CommitBeneficiary, s.cfee}, {s.accs.TxBeneficiary, s.txfee}} {
+		if credit.acc == nil {
+			continue
+		}
+		acc, err := state.FetchAccount(credit.acc.Address)
+		if err != nil {
+			return err
+		}
+		acc.Balance += credit.fee
+		accounts = append(accounts, acc)
+	}
+	prevroot := state.Root()
+	newroot, err := saveAccounts(state, accounts)
+	if err != nil {
+		return err
+	}
+	receipt := &figaro.Receipt{
+		TxID:          tx.ID,
+		BlockNum:      bl.Number,
+		Index:         uint16(len(bl.Transactions)),
+		PrevStateRoot: prevroot,
+		StateRoot:     newroot,
+		GasUsed:       s.gasUsed,
+		TotalFees:     s.totalFees,
+		Success:       s.valid,
+	}
+	bl.StateRoot = newroot
+	_, err = bl.AddTx(tx, receipt)
+	return err
+}
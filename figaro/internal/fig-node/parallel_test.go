@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	"github.com/figaro-tech/go-figaro/figaro/vm"
+)
+
+// testAddress returns a valid address filled with b.
+func testAddress(b byte) figaro.Address {
+	return bytes.Repeat([]byte{b}, figaro.AddressSize)
+}
+
+// newProcessTxsDB returns a db with the accounts, and the beneficiaries, funded, and the commit
+// block, at block 1, of every tx but the last. It returns the next block, whose StateRoot is the
+// state of the accounts.
+func newProcessTxsDB(t testing.TB, txs []*figaro.Transaction, accounts []figaro.Address) (*figdb.DB, *figaro.Block) {
+	db := figdb.NewMem(0, 16)
+	cfg := figaro.ChainConfig{CommitFee: 3, GasLimit: 1 << 20}
+	cblock := &figaro.Block{BlockHeader: &figaro.BlockHeader{Number: 1, Beneficiary: testAddress(0xcb), ChainConfig: cfg}}
+	for _, tx := range txs[:len(txs)-1] {
+		_, err := cblock.AddCommit(figaro.Commit(tx.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cblock.SetBlooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cblock.ID, err = cblock.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.ArchiveBlock(cblock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SaveChainBlock(1, cblock.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var root figaro.Root
+	for _, addr := range append(accounts, testAddress(0xbb), testAddress(0xcb)) {
+		root, err = db.SaveAccount(root, &figaro.Account{Address: addr, Balance: 1000000})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	bl := &figaro.Block{BlockHeader: &figaro.BlockHeader{Number: 2, Beneficiary: testAddress(0xbb), StateRoot: root, ChainConfig: cfg}}
+	return db, bl
+}
+
+func TestProcessTxsMatchesProcessTx(t *testing.T) {
+	var nonces = make(map[string]uint64)
+	newTx := func(typ figaro.TxType, from, to figaro.Address, value uint64, data []byte) *figaro.Transaction {
+		tx := &figaro.Transaction{
+			Type:        typ,
+			From:        from,
+			To:          to,
+			Nonce:       nonces[string(from)],
+			CommitBlock: 1,
+			Value:       value,
+			GasLimit:    100000,
+			GasPrice:    1,
+			Data:        data,
+			// Signatures are verified before txs are processed
+			Signature: make([]byte, fastsig.SignatureSize),
+		}
+		nonces[string(from)]++
+		var err error
+		tx.ID, err = tx.ToHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	alice, bob, carol, dave, erin, frank := testAddress(1), testAddress(2), testAddress(3), testAddress(4), testAddress(5), testAddress(6)
+	benef, cbenef := testAddress(0xbb), testAddress(0xcb)
+
+	// The contract stores the caller under itself
+	deploy, err := figaro.DeployData{Code: []byte{vm.CALLER, vm.SELF, vm.SSTORE, vm.STOP}}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := figaro.ContractAddress(dave, nonces[string(dave)])
+
+	txs := []*figaro.Transaction{
+		// The same sender twice
+		newTx(figaro.BalanceTx, alice, bob, 10, nil),
+		newTx(figaro.BalanceTx, alice, carol, 20, nil),
+		// A new sender to an account written before
+		newTx(figaro.BalanceTx, frank, bob, 5, nil),
+		// Independent of everything before
+		newTx(figaro.StakeTx, carol, carol, 0, nil),
+		// The beneficiaries, credited by every tx, as the From and To of later txs
+		newTx(figaro.BalanceTx, benef, bob, 30, nil),
+		newTx(figaro.BalanceTx, carol, cbenef, 40, nil),
+		newTx(figaro.BalanceTx, cbenef, alice, 50, nil),
+		// Invalid, for sending more than the balance
+		newTx(figaro.BalanceTx, bob, alice, 1<<40, nil),
+		// A contract deployed, then called
+		newTx(figaro.DeployTx, dave, contract, 0, deploy),
+		newTx(figaro.BalanceTx, erin, contract, 1, nil),
+		// Invalid, for not being committed
+		newTx(figaro.BalanceTx, dave, bob, 1, nil),
+	}
+
+	accounts := []figaro.Address{alice, bob, carol, dave, erin, frank}
+	serialdb, serial := newProcessTxsDB(t, txs, accounts)
+	state := figaro.NewStateOverlay(serialdb, serial.StateRoot)
+	for _, tx := range txs {
+		err := ProcessTx(serialdb, state, serial, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	paralleldb, parallel := newProcessTxsDB(t, txs, accounts)
+	err = ProcessTxs(paralleldb, parallel, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = serial.Seal(serialdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = parallel.Seal(paralleldb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parallel.StateRoot, serial.StateRoot) {
+		t.Errorf("StateRoot = %x, want %x", parallel.StateRoot, serial.StateRoot)
+	}
+	if !bytes.Equal(parallel.ReceiptsRoot, serial.ReceiptsRoot) {
+		t.Errorf("ReceiptsRoot = %x, want %x", parallel.ReceiptsRoot, serial.ReceiptsRoot)
+	}
+	if parallel.GasUsed != serial.GasUsed {
+		t.Errorf("GasUsed = %d, want %d", parallel.GasUsed, serial.GasUsed)
+	}
+	for i, tx := range txs {
+		want, err := serialdb.FetchReceipt(tx.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := paralleldb.FetchReceipt(tx.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := got.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := want.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Errorf("tx %d: receipt %+v, want %+v", i, got, want)
+		}
+	}
+	// The txs above must not all be trivially valid or invalid
+	for i, valid := range map[int]bool{0: true, 7: false, 8: true, 9: true, 10: false} {
+		r, err := serialdb.FetchReceipt(txs[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Success != valid {
+			t.Errorf("tx %d: Success = %v, want %v", i, r.Success, valid)
+		}
+	}
+}
+
+// BenchmarkProcessTxs compares ProcessTxs with ProcessTx for each tx in turn, over a block of
+// independent balance transfers.
+func BenchmarkProcessTxs(b *testing.B) {
+	const n = 1000
+	var senders []figaro.Address
+	var txs []*figaro.Transaction
+	for i := 0; i < n; i++ {
+		from, to := make(figaro.Address, figaro.AddressSize), make(figaro.Address, figaro.AddressSize)
+		binary.BigEndian.PutUint32(from, uint32(i+1))
+		binary.BigEndian.PutUint32(to[4:], uint32(i+1))
+		tx := &figaro.Transaction{
+			Type:        figaro.BalanceTx,
+			From:        from,
+			To:          to,
+			CommitBlock: 1,
+			Value:       1,
+			GasLimit:    figaro.TxGas,
+			GasPrice:    1,
+			Signature:   make([]byte, fastsig.SignatureSize),
+		}
+		var err error
+		tx.ID, err = tx.ToHash()
+		if err != nil {
+			b.Fatal(err)
+		}
+		senders, txs = append(senders, from), append(txs, tx)
+	}
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db, bl := newProcessTxsDB(b, txs, senders)
+			state := figaro.NewStateOverlay(db, bl.StateRoot)
+			b.StartTimer()
+			for _, tx := range txs {
+				err := ProcessTx(db, state, bl, tx)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db, bl := newProcessTxsDB(b, txs, senders)
+			b.StartTimer()
+			err := ProcessTxs(db, bl, txs)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+)
+
+// HandshakeProtocol is the protocol over which peers exchange their Status when they connect.
+const HandshakeProtocol protocol.ID = "/figaro/handshake/1.0.0"
+
+// handshakeTimeout bounds how long we wait for a peer to send its Status.
+const handshakeTimeout = 10 * time.Second
+
+// ErrInvalidHandshake is returned when a peer answers the handshake with a Status that cannot be
+// decoded.
+var ErrInvalidHandshake = errors.New("fig-node handshake: invalid status")
+
+// Peers is the set of connected peers that completed the handshake, along with the chain head
+// each of them last advertised, and their Reputation. It is safe for concurrent use.
+type Peers struct {
+	rep *Reputation
+
+	mu     sync.RWMutex
+	status map[peer.ID]*figaro.Status
+}
+
+// Handshake serves our Status to peers, and requests theirs when they connect. Banned peers, and
+// peers on another network or chain, or with an incompatible protocol version, are disconnected.
+// The others are added to the returned Peers until they disconnect. Peers that do not speak the
+// protocol, such as bootstrap nodes, are left connected but not added, since they will never
+// serve chain data.
+func Handshake(ctx context.Context, h host.Host, db *figdb.DB, rep *Reputation, networkID uint64, genesis figaro.BlockHash) *Peers {
+	ps := &Peers{rep: rep, status: make(map[peer.ID]*figaro.Status)}
+	ours := func() (*figaro.Status, error) {
+		chain, err := db.FetchChain()
+		if err != nil {
+			return nil, err
+		}
+		s := &figaro.Status{NetworkID: networkID, Version: figaro.ProtocolVersion, Genesis: genesis}
+		if chain != nil {
+			s.Depth, s.Head = chain.Depth, chain.Head
+		}
+		return s, nil
+	}
+	h.SetStreamHandler(HandshakeProtocol, func(s inet.Stream) {
+		defer s.Close()
+		err := s.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+		if err != nil {
+			return
+		}
+		status, err := ours()
+		if err != nil {
+			return
+		}
+		b, err := status.Encode()
+		if err != nil {
+			return
+		}
+		writeSyncMessage(s, b)
+	})
+	h.Network().Notify(&inet.NotifyBundle{
+		ConnectedF: func(n inet.Network, c inet.Conn) {
+			if rep.Banned(c.RemotePeer()) {
+				go n.ClosePeer(c.RemotePeer())
+				return
+			}
+			go ps.handshake(ctx, h, c.RemotePeer(), ours)
+		},
+		DisconnectedF: func(n inet.Network, c inet.Conn) {
+			if len(n.ConnsToPeer(c.RemotePeer())) == 0 {
+				ps.remove(c.RemotePeer())
+			}
+		},
+	})
+	return ps
+}
+
+// handshake requests the Status of p, adding it to the set if it is compatible with ours, and
+// disconnecting otherwise.
+func (ps *Peers) handshake(ctx context.Context, h host.Host, p peer.ID, ours func() (*figaro.Status, error)) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	s, err := newStream(ctx, h, p, HandshakeProtocol)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	b, err := readSyncMessage(s, maxStatusMessageSize)
+	if err != nil {
+		return
+	}
+	theirs := &figaro.Status{}
+	err = theirs.Decode(b)
+	if err != nil {
+		ps.rep.Report(p, ErrInvalidHandshake)
+		err = ErrInvalidHandshake
+	}
+	if err == nil {
+		var status *figaro.Status
+		status, err = ours()
+		if err != nil {
+			return
+		}
+		err = status.Compatible(theirs)
+	}
+	if err != nil {
+		log.Printf("fig-node: refusing peer %s: %v", p.Pretty(), err)
+		h.Network().ClosePeer(p)
+		return
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.status[p] = theirs
+}
+
+func (ps *Peers) remove(p peer.ID) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.status, p)
+}
+
+// Report penalizes p for err, if it is the fault of p.
+func (ps *Peers) Report(p peer.ID, err error) {
+	ps.rep.Report(p, err)
+}
+
+// Reward raises the score of p for a valid message.
+func (ps *Peers) Reward(p peer.ID) {
+	ps.rep.Reward(p)
+}
+
+// Throttled returns whether messages from p should be dropped.
+func (ps *Peers) Throttled(p peer.ID) bool {
+	return ps.rep.Throttled(p)
+}
+
+// Has returns whether p completed the handshake.
+func (ps *Peers) Has(p peer.ID) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	_, ok := ps.status[p]
+	return ok
+}
+
+// Status returns a copy of the Status last advertised by p, or nil if it is not in the set.
+func (ps *Peers) Status(p peer.ID) *figaro.Status {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	s, ok := ps.status[p]
+	if !ok {
+		return nil
+	}
+	status := *s
+	return &status
+}
+
+// Advance records that p has a block deeper than the chain head it advertised, such as a block
+// it announced.
+func (ps *Peers) Advance(p peer.ID, depth uint64, head figaro.BlockHash) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	s, ok := ps.status[p]
+	if !ok || depth <= s.Depth {
+		return
+	}
+	s.Depth, s.Head = depth, head
+}
+
+// IDs returns the peers in the set.
+func (ps *Peers) IDs() []peer.ID {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	ids := make([]peer.ID, 0, len(ps.status))
+	for p := range ps.status {
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+// Best returns the peers that advertised a chain at least depth blocks deep, deepest first.
+// Throttled peers are left out.
+func (ps *Peers) Best(depth uint64) []peer.ID {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	var ids []peer.ID
+	for p, s := range ps.status {
+		if s.Depth >= depth && !ps.rep.Throttled(p) {
+			ids = append(ids, p)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ps.status[ids[i]].Depth > ps.status[ids[j]].Depth })
+	return ids
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+)
+
+// statusHost is a closeHost whose streams serve a fixed handshake message.
+type statusHost struct {
+	*closeHost
+	msg []byte
+}
+
+func (h *statusHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (inet.Stream, error) {
+	var buf bytes.Buffer
+	err := writeSyncMessage(&buf, h.msg)
+	if err != nil {
+		return nil, err
+	}
+	return &statusStream{r: bytes.NewReader(buf.Bytes())}, nil
+}
+
+type statusStream struct {
+	inet.Stream
+	r *bytes.Reader
+}
+
+func (s *statusStream) Read(b []byte) (int, error)  { return s.r.Read(b) }
+func (s *statusStream) SetDeadline(time.Time) error { return nil }
+func (s *statusStream) Close() error                { return nil }
+
+func TestHandshakeRefusesOtherChains(t *testing.T) {
+	genesis := figaro.BlockHash("genesis")
+	ours := func() (*figaro.Status, error) {
+		return &figaro.Status{NetworkID: 7, Version: figaro.ProtocolVersion, Genesis: genesis}, nil
+	}
+	encode := func(s figaro.Status) []byte {
+		b, err := s.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		msg    []byte
+		accept bool
+	}{
+		{"same chain", encode(figaro.Status{NetworkID: 7, Version: figaro.ProtocolVersion, Genesis: genesis, Depth: 3}), true},
+		{"other network", encode(figaro.Status{NetworkID: 8, Version: figaro.ProtocolVersion, Genesis: genesis}), false},
+		{"other genesis", encode(figaro.Status{NetworkID: 7, Version: figaro.ProtocolVersion, Genesis: figaro.BlockHash("other")}), false},
+		{"old version", encode(figaro.Status{NetworkID: 7, Version: figaro.MinProtocolVersion - 1, Genesis: genesis}), false},
+		{"garbage", []byte("not a status"), false},
+	}
+	for _, tt := range tests {
+		h := &statusHost{closeHost: newCloseHost(), msg: tt.msg}
+		rep, err := NewReputation(h, figdb.NewMem(0, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps := &Peers{rep: rep, status: make(map[peer.ID]*figaro.Status)}
+		p := peer.ID("peer")
+		ps.handshake(context.Background(), h, p, ours)
+
+		if ps.Has(p) != tt.accept {
+			t.Errorf("%s: Has() = %v, want %v", tt.name, ps.Has(p), tt.accept)
+		}
+		select {
+		case <-h.net.closed:
+			if tt.accept {
+				t.Errorf("%s: compatible peer disconnected", tt.name)
+			}
+		default:
+			if !tt.accept {
+				t.Errorf("%s: incompatible peer left connected", tt.name)
+			}
+		}
+	}
+}
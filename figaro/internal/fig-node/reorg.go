@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"container/heap"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// ReorgEvent reports a chain reorganization.
+type ReorgEvent struct {
+	Ancestor       figaro.BlockHash
+	AncestorNumber uint64
+	OldHead        figaro.BlockHash
+	NewHead        figaro.BlockHash
+	// Depth is the number of canonical blocks that were orphaned.
+	Depth    uint64
+	Orphaned []figaro.BlockHash
+	Applied  []figaro.BlockHash
+}
+
+// Reorg reorganizes the chain onto the branch ending with forkblock. The engine decides whether
+// the fork is canonical and rewinds the chain to the common ancestor, whose StateRoot is where
+// the new branch starts. The orphaned blocks are rolled back into the pending pools and the new
+// branch is replayed through SyncBlock, rewriting the chain index. If the new branch does not
+// replay to a longer chain, the original chain is restored.
+func Reorg(db *figdb.DB, chain *figaro.Chain, pool *figaro.TxPool, forkblock *figaro.Block, futureblocks *figaro.BlockHeap, engine figaro.ConsensusEngine, sigs *SigVerifier) (*ReorgEvent, error) {
+	err := db.ArchiveBlock(forkblock)
+	if err != nil {
+		return nil, err
+	}
+	old := *chain
+	newchain, next, futureblocks, err := engine.ChainReorg(db, chain, forkblock.BlockHeader, futureblocks)
+	if err != nil {
+		return nil, err
+	}
+	*chain = *newchain
+	ancestor := *chain
+	ev := &ReorgEvent{
+		Ancestor:       chain.Head,
+		AncestorNumber: chain.Depth,
+		OldHead:        old.Head,
+		Depth:          old.Depth - chain.Depth,
+	}
+
+	// Roll back the orphaned blocks, highest first
+	orphaned := make([]*figaro.Block, 0, ev.Depth)
+	for n := old.Depth; n > chain.Depth; n-- {
+		bhash, err := db.FetchChainBlock(n)
+		if err != nil {
+			return nil, err
+		}
+		bl, err := db.FetchBlock(bhash)
+		if err != nil {
+			return nil, err
+		}
+		pool.Reinject(bl)
+		orphaned = append(orphaned, bl)
+		ev.Orphaned = append(ev.Orphaned, bhash)
+	}
+
+	// Replay the new branch on top of the ancestor. Future blocks from other forks are left for later
+	var applied []*figaro.Block
+	header := next
+	for header != nil {
+		bl, err := db.HydrateBlock(header)
+		if err == nil {
+			err = HandleNextBlock(db, chain, pool, bl, engine, sigs)
+		}
+		if err != nil {
+			return nil, restoreChain(db, chain, pool, &ancestor, orphaned, applied, err)
+		}
+		applied = append(applied, bl)
+		ev.Applied = append(ev.Applied, bl.ID)
+		header = popNextBlock(futureblocks, chain)
+	}
+	if chain.Depth < old.Depth {
+		return nil, restoreChain(db, chain, pool, &ancestor, orphaned, applied, figaro.ErrForkRejected)
+	}
+	ev.NewHead = chain.Head
+	return ev, nil
+}
+
+// popNextBlock removes and returns the future block that is the child of the chain head, or nil if
+// there is none.
+func popNextBlock(futureblocks *figaro.BlockHeap, chain *figaro.Chain) *figaro.BlockHeader {
+	for i, header := range *futureblocks {
+		if header.Number == chain.Depth+1 && bytes.Equal(header.ParentBlock, chain.Head) {
+			return heap.Remove(futureblocks, i).(*figaro.BlockHeader)
+		}
+	}
+	return nil
+}
+
+// restoreChain rewinds to the ancestor and reapplies the orphaned blocks after a failed reorg,
+// returning cause. The blocks applied from the abandoned branch are rolled back into the pending
+// pools, and their chain index entries above the restored head are deleted.
+func restoreChain(db *figdb.DB, chain *figaro.Chain, pool *figaro.TxPool, ancestor *figaro.Chain, orphaned, applied []*figaro.Block, cause error) error {
+	depth := chain.Depth
+	for i := len(applied) - 1; i >= 0; i-- {
+		pool.Reinject(applied[i])
+	}
+	*chain = *ancestor
+	err := db.SaveChain(chain)
+	if err != nil {
+		return err
+	}
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		err = chain.AppendBlock(db, orphaned[i].BlockHeader)
+		if err != nil {
+			return err
+		}
+		err = pool.Update(db, orphaned[i])
+		if err != nil {
+			return err
+		}
+	}
+	for n := chain.Depth + 1; n <= depth; n++ {
+		err = db.DeleteChainBlock(n)
+		if err != nil {
+			return err
+		}
+	}
+	return cause
+}
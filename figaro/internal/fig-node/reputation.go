@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// Peers start with a score of 0, which rises with valid messages up to MaxScore, and falls with
+// each misbehavior by its penalty. A negative score recovers by a point every scoreRecovery.
+const (
+	// MaxScore is the highest score a peer can earn.
+	MaxScore = 100
+	// ThrottleScore is the score below which messages from a peer are dropped, and it is not asked
+	// for sync data.
+	ThrottleScore = -50
+	// BanScore is the score below which a peer is disconnected and banned.
+	BanScore = -100
+	// BanDuration is how long a peer stays banned.
+	BanDuration = 24 * time.Hour
+
+	scoreRecovery = time.Minute
+)
+
+// The penalties for each misbehavior. A fraudulent block bans its sender outright.
+const (
+	penaltyFraud          = -BanScore + 1
+	penaltyInvalidBlock   = 50
+	penaltyInvalidMessage = 25
+	penaltyInvalidTx      = 10
+	penaltyTimeout        = 2
+	penaltySpam           = 1
+)
+
+// penalty returns the penalty for a peer that caused err, or 0 if err is not provably the fault of
+// the peer. Stream resets and missing data are never penalized, since an honest peer on a slow
+// link, or behind our chain, causes them too. Neither are rejected forks, nor messages we already
+// have, which honest peers send as a matter of course. A timeout costs little, so that a peer which
+// stalls every request is throttled, while one on a slow link recovers.
+func penalty(err error) int {
+	if isTimeout(err) {
+		return penaltyTimeout
+	}
+	switch err {
+	case ErrFraudulentBlock:
+		return penaltyFraud
+	case figaro.ErrInvalidBlock, ErrInvalidHeaderChain, ErrInvalidBlockContents:
+		return penaltyInvalidBlock
+	case ErrInvalidGossipMessage, ErrInvalidSyncMessage, ErrInvalidHandshake:
+		return penaltyInvalidMessage
+	case figaro.ErrInvalidTransaction, figaro.ErrInvalidTxHashData:
+		return penaltyInvalidTx
+	case figaro.ErrUnderpriced, figaro.ErrNonceConflict:
+		return penaltySpam
+	default:
+		return 0
+	}
+}
+
+// isTimeout returns whether err is a timeout, of a request context or of a stream deadline.
+func isTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	terr, ok := err.(interface{ Timeout() bool })
+	return ok && terr.Timeout()
+}
+
+// PeerScore is the reputation of a peer, as reported by the admin API.
+type PeerScore struct {
+	ID          string     `json:"id"`
+	Score       int        `json:"score"`
+	Throttled   bool       `json:"throttled"`
+	BannedUntil *time.Time `json:"bannedUntil,omitempty"`
+}
+
+type peerScore struct {
+	score   int
+	updated time.Time
+}
+
+// Reputation scores peers by the outcome of validating what they send, throttling and then
+// banning those that misbehave. Bans are saved to db, so that they last across restarts. db must
+// not be the chain db, whose writes are batched while a block is handled, so that a ban is
+// neither discarded nor committed along with the block. It is safe for concurrent use.
+type Reputation struct {
+	h  host.Host
+	db *figdb.DB
+
+	mu     sync.Mutex
+	scores map[peer.ID]*peerScore
+	bans   map[peer.ID]time.Time
+}
+
+// NewReputation returns a Reputation, with the bans saved in db, which it must own.
+func NewReputation(h host.Host, db *figdb.DB) (*Reputation, error) {
+	saved, err := db.FetchPeerBans()
+	if err != nil {
+		return nil, err
+	}
+	r := &Reputation{h: h, db: db, scores: make(map[peer.ID]*peerScore), bans: make(map[peer.ID]time.Time)}
+	for _, ban := range saved {
+		r.bans[peer.ID(ban.Peer)] = ban.Until
+	}
+	return r, nil
+}
+
+// Report penalizes p for err, if it is the fault of p, banning it if its score drops below BanScore.
+func (r *Reputation) Report(p peer.ID, err error) {
+	penalty := penalty(err)
+	if penalty == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.score(p)
+	s.score -= penalty
+	if s.score >= BanScore {
+		return
+	}
+	until := time.Now().Add(BanDuration)
+	r.bans[p] = until
+	delete(r.scores, p)
+	log.Printf("fig-node: banning peer %s until %s: %v", p.Pretty(), until.Format(time.RFC3339), err)
+	go r.h.Network().ClosePeer(p)
+	serr := r.saveBans()
+	if serr != nil {
+		log.Println(serr)
+	}
+}
+
+// Reward raises the score of p for a valid message.
+func (r *Reputation) Reward(p peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.score(p)
+	if s.score < MaxScore {
+		s.score++
+	}
+}
+
+// Throttled returns whether messages from p should be dropped.
+func (r *Reputation) Throttled(p peer.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.banned(p) {
+		return true
+	}
+	s, ok := r.scores[p]
+	return ok && r.current(s) < ThrottleScore
+}
+
+// Banned returns whether p is banned.
+func (r *Reputation) Banned(p peer.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.banned(p)
+}
+
+// Scores returns the scores of the connected and banned peers, lowest first.
+func (r *Reputation) Scores() []PeerScore {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var scores []PeerScore
+	for _, p := range r.h.Network().Peers() {
+		if r.banned(p) {
+			continue
+		}
+		score := 0
+		if s, ok := r.scores[p]; ok {
+			score = r.current(s)
+		}
+		scores = append(scores, PeerScore{ID: p.Pretty(), Score: score, Throttled: score < ThrottleScore})
+	}
+	for p, until := range r.bans {
+		if !r.banned(p) {
+			continue
+		}
+		until := until
+		scores = append(scores, PeerScore{ID: p.Pretty(), Score: BanScore, Throttled: true, BannedUntil: &until})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score < scores[j].Score
+		}
+		return scores[i].ID < scores[j].ID
+	})
+	return scores
+}
+
+// score returns the score of p, bringing it up to date.
+func (r *Reputation) score(p peer.ID) *peerScore {
+	s, ok := r.scores[p]
+	if !ok {
+		s = &peerScore{updated: time.Now()}
+		r.scores[p] = s
+	}
+	s.score = r.current(s)
+	s.updated = time.Now()
+	return s
+}
+
+// current returns the score of s, after the recovery of a negative score since it was updated.
+func (r *Reputation) current(s *peerScore) int {
+	if s.score >= 0 {
+		return s.score
+	}
+	score := s.score + int(time.Since(s.updated)/scoreRecovery)
+	if score > 0 {
+		return 0
+	}
+	return score
+}
+
+// banned returns whether p is banned, forgetting an expired ban.
+func (r *Reputation) banned(p peer.ID) bool {
+	until, ok := r.bans[p]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(r.bans, p)
+	return false
+}
+
+// saveBans saves the bans that have not expired.
+func (r *Reputation) saveBans() error {
+	var bans figaro.PeerBans
+	now := time.Now()
+	for p, until := range r.bans {
+		if now.Before(until) {
+			bans = append(bans, figaro.PeerBan{Peer: string(p), Until: until})
+		}
+	}
+	return r.db.SavePeerBans(bans)
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// closeHost is a host whose network only records the peers it is asked to close.
+type closeHost struct {
+	host.Host
+	net *closeNetwork
+}
+
+func (h *closeHost) Network() inet.Network { return h.net }
+
+type closeNetwork struct {
+	inet.Network
+	closed chan peer.ID
+}
+
+func (n *closeNetwork) ClosePeer(p peer.ID) error {
+	n.closed <- p
+	return nil
+}
+
+func (n *closeNetwork) Peers() []peer.ID { return nil }
+
+func newCloseHost() *closeHost {
+	return &closeHost{net: &closeNetwork{closed: make(chan peer.ID, 16)}}
+}
+
+func TestReputationBanSavedAndReloaded(t *testing.T) {
+	h := newCloseHost()
+	db := figdb.NewMem(0, 16)
+	r, err := NewReputation(h, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := peer.ID("fraudster")
+	r.Report(p, ErrFraudulentBlock)
+	if !r.Banned(p) {
+		t.Fatal("peer not banned for a fraudulent block")
+	}
+	select {
+	case closed := <-h.net.closed:
+		if closed != p {
+			t.Errorf("closed peer %q, want %q", closed, p)
+		}
+	case <-time.After(time.Second):
+		t.Error("banned peer not disconnected")
+	}
+
+	r, err = NewReputation(h, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Banned(p) {
+		t.Error("ban not reloaded")
+	}
+	if r.Banned(peer.ID("honest")) {
+		t.Error("unreported peer banned")
+	}
+}
+
+func TestReputationBanExpires(t *testing.T) {
+	db := figdb.NewMem(0, 16)
+	expired, current := peer.ID("expired"), peer.ID("current")
+	err := db.SavePeerBans(figaro.PeerBans{
+		{Peer: string(expired), Until: time.Now().Add(-time.Minute)},
+		{Peer: string(current), Until: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReputation(newCloseHost(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Banned(expired) || r.Throttled(expired) {
+		t.Error("expired ban still applies")
+	}
+	if !r.Banned(current) {
+		t.Error("current ban not applied")
+	}
+
+	// Saving the bans drops the expired ones
+	r.mu.Lock()
+	r.bans[expired] = time.Now().Add(-time.Minute)
+	err = r.saveBans()
+	r.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bans, err := db.FetchPeerBans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].Peer != string(current) {
+		t.Errorf("saved bans = %+v, want only %q", bans, current)
+	}
+}
+
+func TestReputationPenalties(t *testing.T) {
+	r, err := NewReputation(newCloseHost(), figdb.NewMem(0, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{ErrUnlinkedHeaders, 0},
+		{figaro.ErrForkRejected, 0},
+		{context.DeadlineExceeded, -penaltyTimeout},
+		{ErrInvalidSyncMessage, -penaltyInvalidMessage},
+		{figaro.ErrInvalidBlock, -penaltyInvalidBlock},
+	}
+	for i, tt := range tests {
+		p := peer.ID(string(rune('a' + i)))
+		r.Report(p, tt.err)
+		r.mu.Lock()
+		var got int
+		if s, ok := r.scores[p]; ok {
+			got = s.score
+		}
+		r.mu.Unlock()
+		if got != tt.want {
+			t.Errorf("score after %v = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
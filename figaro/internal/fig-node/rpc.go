@@ -0,0 +1,597 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// JSON-RPC 2.0 error codes. Codes above -32000 are defined by the spec, the rest
+// are our own.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCNotFound       = -32000
+	RPCInvalidTx      = -32001
+	RPCPoolRejected   = -32002
+)
+
+// maxRPCRequestSize is the max size, in bytes, of a JSON-RPC request body.
+const maxRPCRequestSize = 1 << 20
+
+var (
+	// ErrRPCNotFound is a self-explantory error.
+	ErrRPCNotFound = errors.New("fig-node rpc: not found")
+	// ErrRPCInvalidParams is a self-explantory error.
+	ErrRPCInvalidParams = errors.New("fig-node rpc: invalid params")
+)
+
+// RPCRequest is a JSON-RPC 2.0 request.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// RPCResponse is a JSON-RPC 2.0 response.
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// RPCError is a JSON-RPC 2.0 error object.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (e *RPCError) Error() string { return e.Message }
+
+// RPCServer serves the node data services over JSON-RPC 2.0 on HTTP. The admin methods are
+// served by a separate RPCServer, so that they can be kept off the public listener.
+type RPCServer struct {
+	db      *figdb.DB
+	pool    *figaro.TxPool
+	sigs    *SigVerifier
+	gossip  *Gossip
+	rep     *Reputation
+	methods map[string]func(json.RawMessage) (interface{}, error)
+}
+
+// NewRPCServer returns an RPCServer of the public fig_* methods, ready to serve. Commits and
+// transactions sent to the server are verified with sigs, and broadcast over gossip, unless it is nil.
+func NewRPCServer(db *figdb.DB, pool *figaro.TxPool, sigs *SigVerifier, gossip *Gossip) *RPCServer {
+	s := &RPCServer{db: db, pool: pool, sigs: sigs, gossip: gossip}
+	s.methods = map[string]func(json.RawMessage) (interface{}, error){
+		"fig_fetchChain":          s.fetchChain,
+		"fig_fetchChainBlock":     s.fetchChainBlock,
+		"fig_fetchBlock":          s.fetchBlock,
+		"fig_fetchBlockHeader":    s.fetchBlockHeader,
+		"fig_fetchCompBlock":      s.fetchCompBlock,
+		"fig_fetchAccount":        s.fetchAccount,
+		"fig_fetchAccountStorage": s.fetchAccountStorage,
+		"fig_fetchReceipt":        s.fetchReceipt,
+		"fig_proveAccount":        s.proveAccount,
+		"fig_proveStorage":        s.proveStorage,
+		"fig_proveTransaction":    s.proveTransaction,
+		"fig_proveReceipt":        s.proveReceipt,
+		"fig_fetchSyncProgress":   s.fetchSyncProgress,
+		"fig_sendCommit":          s.sendCommit,
+		"fig_sendTransaction":     s.sendTransaction,
+	}
+	return s
+}
+
+// NewAdminRPCServer returns an RPCServer of the admin_* methods, ready to serve. It exposes
+// the node internals, so it must only listen where the node operator can reach it. Peer scores
+// are served from rep.
+func NewAdminRPCServer(rep *Reputation) *RPCServer {
+	s := &RPCServer{rep: rep}
+	s.methods = map[string]func(json.RawMessage) (interface{}, error){
+		"admin_peers": s.peers,
+	}
+	return s
+}
+
+// ServeHTTP implements http.Handler. Batch requests are supported.
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRPCRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []RPCRequest
+		err = json.Unmarshal(body, &reqs)
+		if err != nil || len(reqs) == 0 {
+			json.NewEncoder(w).Encode(rpcErrorResponse(nil, RPCParseError, "parse error"))
+			return
+		}
+		resps := make([]*RPCResponse, 0, len(reqs))
+		for _, req := range reqs {
+			resp := s.handle(&req)
+			if req.ID != nil {
+				resps = append(resps, resp)
+			}
+		}
+		if len(resps) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(resps)
+		return
+	}
+	req := &RPCRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		json.NewEncoder(w).Encode(rpcErrorResponse(nil, RPCParseError, "parse error"))
+		return
+	}
+	resp := s.handle(req)
+	if req.ID == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handle dispatches a single request to its method.
+func (s *RPCServer) handle(req *RPCRequest) *RPCResponse {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, RPCInvalidRequest, "invalid request")
+	}
+	method, ok := s.methods[req.Method]
+	if !ok {
+		return rpcErrorResponse(req.ID, RPCMethodNotFound, "method not found")
+	}
+	result, err := method(req.Params)
+	if err != nil {
+		code := rpcErrorCode(err)
+		if code == RPCInternalError {
+			// Internal and db errors are logged, but not leaked to the client
+			log.Printf("fig-node rpc: %s: %v", req.Method, err)
+			return rpcErrorResponse(req.ID, code, "internal error")
+		}
+		return rpcErrorResponse(req.ID, code, err.Error())
+	}
+	return &RPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) *RPCResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &RPCResponse{JSONRPC: "2.0", Error: &RPCError{Code: code, Message: message}, ID: id}
+}
+
+// rpcErrorCode maps errors to well-defined JSON-RPC error codes.
+func rpcErrorCode(err error) int {
+	switch err {
+	case ErrRPCInvalidParams:
+		return RPCInvalidParams
+	case ErrRPCNotFound:
+		return RPCNotFound
+	case figaro.ErrInvalidTransaction, figaro.ErrInvalidTxHashData:
+		return RPCInvalidTx
+	case figaro.ErrTxPoolFull, figaro.ErrKnownCommit, figaro.ErrKnownTx, figaro.ErrNonceConflict, figaro.ErrUnderpriced:
+		return RPCPoolRejected
+	default:
+		return RPCInternalError
+	}
+}
+
+// decodeParams decodes by-name params into v.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return ErrRPCInvalidParams
+	}
+	err := json.Unmarshal(params, v)
+	if err != nil {
+		return ErrRPCInvalidParams
+	}
+	return nil
+}
+
+// blockRef selects a block by hash or by number in the canonical chain.
+// If neither is given, the chain head is selected.
+type blockRef struct {
+	Hash   figaro.BlockHash `json:"hash,omitempty"`
+	Number *uint64          `json:"number,omitempty"`
+}
+
+// stateRef selects a state root, either directly or by the block that sealed it.
+type stateRef struct {
+	blockRef
+	Root figaro.Root `json:"root,omitempty"`
+}
+
+func (s *RPCServer) resolveBlock(ref blockRef) (figaro.BlockHash, error) {
+	if len(ref.Hash) > 0 {
+		return ref.Hash, nil
+	}
+	if ref.Number != nil {
+		bhash, err := s.db.FetchChainBlock(*ref.Number)
+		if err != nil {
+			return nil, err
+		}
+		if len(bhash) == 0 {
+			return nil, ErrRPCNotFound
+		}
+		return bhash, nil
+	}
+	chain, err := s.db.FetchChain()
+	if err != nil {
+		return nil, err
+	}
+	if chain == nil {
+		return nil, ErrRPCNotFound
+	}
+	return chain.Head, nil
+}
+
+func (s *RPCServer) resolveRoot(ref stateRef) (figaro.Root, error) {
+	if len(ref.Root) > 0 {
+		return ref.Root, nil
+	}
+	bhash, err := s.resolveBlock(ref.blockRef)
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.db.FetchBlockHeader(bhash)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, ErrRPCNotFound
+	}
+	return header.StateRoot, nil
+}
+
+func (s *RPCServer) fetchChain(params json.RawMessage) (interface{}, error) {
+	chain, err := s.db.FetchChain()
+	if err != nil {
+		return nil, err
+	}
+	if chain == nil {
+		return nil, ErrRPCNotFound
+	}
+	return chain, nil
+}
+
+func (s *RPCServer) fetchSyncProgress(params json.RawMessage) (interface{}, error) {
+	progress, err := s.db.FetchSyncProgress()
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		return nil, ErrRPCNotFound
+	}
+	return progress, nil
+}
+
+func (s *RPCServer) peers(params json.RawMessage) (interface{}, error) {
+	return s.rep.Scores(), nil
+}
+
+func (s *RPCServer) fetchChainBlock(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Number uint64 `json:"number"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	bhash, err := s.db.FetchChainBlock(p.Number)
+	if err != nil {
+		return nil, err
+	}
+	if len(bhash) == 0 {
+		return nil, ErrRPCNotFound
+	}
+	return bhash, nil
+}
+
+func (s *RPCServer) fetchBlock(params json.RawMessage) (interface{}, error) {
+	p := blockRef{}
+	if len(params) > 0 {
+		err := decodeParams(params, &p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bhash, err := s.resolveBlock(p)
+	if err != nil {
+		return nil, err
+	}
+	bl, err := s.db.FetchBlock(bhash)
+	if err != nil {
+		return nil, err
+	}
+	if bl == nil {
+		return nil, ErrRPCNotFound
+	}
+	return bl, nil
+}
+
+func (s *RPCServer) fetchBlockHeader(params json.RawMessage) (interface{}, error) {
+	p := blockRef{}
+	if len(params) > 0 {
+		err := decodeParams(params, &p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bhash, err := s.resolveBlock(p)
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.db.FetchBlockHeader(bhash)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, ErrRPCNotFound
+	}
+	return header, nil
+}
+
+func (s *RPCServer) fetchCompBlock(params json.RawMessage) (interface{}, error) {
+	p := blockRef{}
+	if len(params) > 0 {
+		err := decodeParams(params, &p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bhash, err := s.resolveBlock(p)
+	if err != nil {
+		return nil, err
+	}
+	cb, err := s.db.FetchCompBlock(bhash)
+	if err != nil {
+		return nil, err
+	}
+	if cb == nil {
+		return nil, ErrRPCNotFound
+	}
+	return cb, nil
+}
+
+func (s *RPCServer) fetchAccount(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		stateRef
+		Address figaro.Address `json:"address"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Address.Valid() {
+		return nil, ErrRPCInvalidParams
+	}
+	root, err := s.resolveRoot(p.stateRef)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.FetchAccount(root, p.Address)
+}
+
+func (s *RPCServer) fetchAccountStorage(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		stateRef
+		Address figaro.Address  `json:"address"`
+		Key     figaro.HexBytes `json:"key"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Address.Valid() || len(p.Key) == 0 {
+		return nil, ErrRPCInvalidParams
+	}
+	root, err := s.resolveRoot(p.stateRef)
+	if err != nil {
+		return nil, err
+	}
+	acc, err := s.db.FetchAccount(root, p.Address)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.db.FetchAccountStorage(acc, p.Key)
+	if err != nil {
+		return nil, err
+	}
+	return figaro.HexBytes(data), nil
+}
+
+func (s *RPCServer) proveAccount(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		stateRef
+		Address figaro.Address `json:"address"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Address.Valid() {
+		return nil, ErrRPCInvalidParams
+	}
+	root, err := s.resolveRoot(p.stateRef)
+	if err != nil {
+		return nil, err
+	}
+	acc, proof, err := s.db.ProveAccount(root, p.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &figaro.AccountProof{Account: acc, Proof: proof}, nil
+}
+
+func (s *RPCServer) proveStorage(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		StorageRoot figaro.Root     `json:"storageRoot"`
+		Key         figaro.HexBytes `json:"key"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.StorageRoot) == 0 || len(p.Key) == 0 {
+		return nil, ErrRPCInvalidParams
+	}
+	data, proof, err := s.db.ProveAccountStorage(&figaro.Account{StorageRoot: p.StorageRoot}, p.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &figaro.StorageProof{Key: p.Key, Value: data, Proof: proof}, nil
+}
+
+func (s *RPCServer) proveTransaction(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		blockRef
+		Index int `json:"index"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.resolveHeader(p.blockRef, p.Index)
+	if err != nil {
+		return nil, err
+	}
+	tx, proof, err := s.db.GetAndProveTransaction(header.TransactionsRoot, p.Index)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, ErrRPCNotFound
+	}
+	return &figaro.TxProof{Transaction: tx, Index: p.Index, Proof: proof}, nil
+}
+
+func (s *RPCServer) proveReceipt(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		blockRef
+		Index int `json:"index"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.resolveHeader(p.blockRef, p.Index)
+	if err != nil {
+		return nil, err
+	}
+	r, proof, err := s.db.GetAndProveReceipt(header.ReceiptsRoot, p.Index)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, ErrRPCNotFound
+	}
+	return &figaro.ReceiptProof{Receipt: r, Index: p.Index, Proof: proof}, nil
+}
+
+// resolveHeader resolves the header of a block, for a proof of the item at index in one of its archives.
+func (s *RPCServer) resolveHeader(ref blockRef, index int) (*figaro.BlockHeader, error) {
+	if index < 0 || index >= figaro.MaxTxSize {
+		return nil, ErrRPCInvalidParams
+	}
+	bhash, err := s.resolveBlock(ref)
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.db.FetchBlockHeader(bhash)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, ErrRPCNotFound
+	}
+	return header, nil
+}
+
+func (s *RPCServer) fetchReceipt(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		TxID figaro.TxHash `json:"txId"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !p.TxID.Valid() {
+		return nil, ErrRPCInvalidParams
+	}
+	r, err := s.db.FetchReceipt(p.TxID)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, ErrRPCNotFound
+	}
+	return r, nil
+}
+
+func (s *RPCServer) sendCommit(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Commit figaro.Commit `json:"commit"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !figaro.TxHash(p.Commit).Valid() {
+		return nil, ErrRPCInvalidParams
+	}
+	err = s.pool.AddCommit(&figaro.ReceivedCommit{Commit: p.Commit, Received: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	if s.gossip != nil {
+		s.gossip.BroadcastCommit(p.Commit)
+	}
+	return p.Commit, nil
+}
+
+func (s *RPCServer) sendTransaction(params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Tx *figaro.Transaction `json:"tx"`
+	}{}
+	err := decodeParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Tx == nil {
+		return nil, ErrRPCInvalidParams
+	}
+	err = AddTx(s.db, s.pool, s.sigs, p.Tx)
+	if err != nil {
+		return nil, err
+	}
+	if s.gossip != nil {
+		s.gossip.BroadcastTx(p.Tx)
+	}
+	return figaro.TxHash(p.Tx.ID), nil
+}
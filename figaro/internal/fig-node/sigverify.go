@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// DefaultSigCacheSize is the number of verified signatures remembered by the SigVerifier of a
+// node, enough to cover a full TxPool.
+var DefaultSigCacheSize = figaro.DefaultTxPoolConfig.MaxTxs
+
+// SigCache remembers transactions with verified signatures, up to a fixed size, forgetting the
+// oldest first. It is safe for concurrent use.
+type SigCache struct {
+	mu   sync.Mutex
+	set  map[string]bool
+	ring []string
+	next int
+}
+
+// NewSigCache returns a SigCache that remembers up to size transactions.
+func NewSigCache(size int) *SigCache {
+	if size < 1 {
+		size = 1
+	}
+	return &SigCache{set: make(map[string]bool, size), ring: make([]string, size)}
+}
+
+// Has returns whether tx has been verified with its current signature.
+func (c *SigCache) Has(tx *figaro.Transaction) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.set[sigKey(tx)]
+}
+
+// Add remembers that tx has been verified with its current signature.
+func (c *SigCache) Add(tx *figaro.Transaction) {
+	key := sigKey(tx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.set[key] {
+		return
+	}
+	if old := c.ring[c.next]; old != "" {
+		delete(c.set, old)
+	}
+	c.ring[c.next] = key
+	c.next = (c.next + 1) % len(c.ring)
+	c.set[key] = true
+}
+
+// sigKey keys a tx by ID and signature, since the ID does not cover the signature, and a
+// tx can be replayed with a forged signature under the same ID.
+func sigKey(tx *figaro.Transaction) string {
+	return string(tx.ID) + string(tx.Signature)
+}
+
+// SigVerifier verifies transaction signatures on a pool of workers, skipping those
+// already in its cache. A node shares a single SigVerifier, so that transactions verified as
+// they enter the pool are not verified again when their block is synced.
+type SigVerifier struct {
+	cache *SigCache
+	jobs  chan sigJob
+}
+
+type sigJob struct {
+	batch *sigBatch
+	index int
+}
+
+// sigBatch is a single VerifyTxs call, shared by the workers verifying it.
+type sigBatch struct {
+	txs      []*figaro.Transaction
+	failFast bool
+	failed   int32
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	invalid []int
+}
+
+// NewSigVerifier starts a SigVerifier with the given number of workers, or one per CPU if
+// workers is not positive. The cache may be nil.
+func NewSigVerifier(workers int, cache *SigCache) *SigVerifier {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	v := &SigVerifier{cache: cache, jobs: make(chan sigJob, workers)}
+	for i := 0; i < workers; i++ {
+		go v.work()
+	}
+	return v
+}
+
+// VerifyTx verifies the signature of a single tx, caching it if valid.
+func (v *SigVerifier) VerifyTx(tx *figaro.Transaction) bool {
+	if v.cache != nil && v.cache.Has(tx) {
+		return true
+	}
+	if !tx.VerifySignature() {
+		return false
+	}
+	if v.cache != nil {
+		v.cache.Add(tx)
+	}
+	return true
+}
+
+// VerifyTxs verifies the signatures of txs in parallel, returning the indices of the txs with
+// invalid signatures, in order. With failFast, verification is cancelled on the first failure,
+// so only the failures found by then are returned. Valid signatures are cached.
+func (v *SigVerifier) VerifyTxs(txs []*figaro.Transaction, failFast bool) []int {
+	b := &sigBatch{txs: txs, failFast: failFast}
+	for i := range txs {
+		if b.cancelled() {
+			break
+		}
+		b.wg.Add(1)
+		v.jobs <- sigJob{b, i}
+	}
+	b.wg.Wait()
+	sort.Ints(b.invalid)
+	return b.invalid
+}
+
+func (v *SigVerifier) work() {
+	for job := range v.jobs {
+		b := job.batch
+		if !b.cancelled() && !v.VerifyTx(b.txs[job.index]) {
+			b.fail(job.index)
+		}
+		b.wg.Done()
+	}
+}
+
+func (b *sigBatch) cancelled() bool {
+	return b.failFast && atomic.LoadInt32(&b.failed) != 0
+}
+
+func (b *sigBatch) fail(index int) {
+	atomic.StoreInt32(&b.failed, 1)
+	b.mu.Lock()
+	b.invalid = append(b.invalid, index)
+	b.mu.Unlock()
+}
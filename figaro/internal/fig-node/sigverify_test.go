@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// signedTestTx returns a tx of value, committed in commitblock, signed by a new sender.
+func signedTestTx(t *testing.T, commitblock, value uint64) *figaro.Transaction {
+	from, privkey, err := fastsig.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &figaro.Transaction{Type: figaro.BalanceTx, From: from, To: testAddress(0xff), CommitBlock: commitblock, Value: value, GasLimit: figaro.TxGas}
+	tx.ID, err = tx.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Sign(privkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestVerifyTxs(t *testing.T) {
+	var txs []*figaro.Transaction
+	for i := 0; i < 20; i++ {
+		txs = append(txs, signedTestTx(t, 1, uint64(i)))
+	}
+	for _, i := range []int{3, 11, 17} {
+		txs[i].Signature[0] ^= 1
+	}
+	v := NewSigVerifier(4, nil)
+	if got, want := v.VerifyTxs(txs, false), []int{3, 11, 17}; !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyTxs() = %v, want %v", got, want)
+	}
+	got := v.VerifyTxs(txs, true)
+	if len(got) == 0 {
+		t.Fatal("VerifyTxs() with failFast found no invalid signature")
+	}
+	for _, i := range got {
+		if i != 3 && i != 11 && i != 17 {
+			t.Errorf("VerifyTxs() with failFast = %v, reports a valid signature", got)
+		}
+	}
+	if got := v.VerifyTxs(txs[:3], false); len(got) != 0 {
+		t.Errorf("VerifyTxs() of valid txs = %v, want none", got)
+	}
+}
+
+func TestSigCacheCoversSignature(t *testing.T) {
+	v := NewSigVerifier(1, NewSigCache(16))
+	tx := signedTestTx(t, 1, 1)
+	if !v.VerifyTx(tx) {
+		t.Fatal("VerifyTx() = false for a valid signature")
+	}
+	if !v.cache.Has(tx) {
+		t.Fatal("valid signature not cached")
+	}
+	// The same ID with another signature must be verified again
+	forged := *tx
+	forged.Signature = append([]byte(nil), tx.Signature...)
+	forged.Signature[0] ^= 1
+	if v.cache.Has(&forged) {
+		t.Error("cache vouches for a forged signature")
+	}
+	if v.VerifyTx(&forged) {
+		t.Error("VerifyTx() = true for a forged signature of a cached tx")
+	}
+	if got := v.VerifyTxs([]*figaro.Transaction{tx, &forged}, false); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("VerifyTxs() = %v, want [1]", got)
+	}
+	if v.cache.Has(&forged) {
+		t.Error("forged signature cached")
+	}
+}
+
+func TestSigCacheEvictsOldest(t *testing.T) {
+	c := NewSigCache(2)
+	txs := []*figaro.Transaction{signedTestTx(t, 1, 1), signedTestTx(t, 1, 2), signedTestTx(t, 1, 3)}
+	for _, tx := range txs {
+		c.Add(tx)
+	}
+	if c.Has(txs[0]) || !c.Has(txs[1]) || !c.Has(txs[2]) {
+		t.Errorf("Has() = %v, %v, %v, want false, true, true", c.Has(txs[0]), c.Has(txs[1]), c.Has(txs[2]))
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"bytes"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+// ApplyEvidence verifies evidence of a producer offence and slashes the offender as part of the block,
+// adding the evidence to the block and advancing the block StateRoot. Offences are recorded in the
+// storage of the ZeroAddress account, so that each is only penalized once. It returns
+// figaro.ErrInvalidEvidence if the evidence does not prove an offence, or was already penalized.
+func ApplyEvidence(db *figdb.DB, bl *figaro.Block, ev *figaro.Evidence) error {
+	if !ev.Verify(db) {
+		return figaro.ErrInvalidEvidence
+	}
+	if ev.Type == figaro.FraudEvidence && ev.Fraud.Type == figaro.FraudStateRoot {
+		canonical, err := db.FetchChainBlock(ev.Fraud.Tx.CommitBlock)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(canonical, ev.Fraud.CommitHeader.ID) {
+			return figaro.ErrInvalidEvidence
+		}
+	}
+	key := ev.OffenceKey()
+	registry, err := db.FetchAccount(bl.StateRoot, figaro.ZeroAddress)
+	if err != nil {
+		return err
+	}
+	seen, err := db.FetchAccountStorage(registry, key)
+	if err != nil {
+		return err
+	}
+	if len(seen) > 0 {
+		return figaro.ErrInvalidEvidence
+	}
+	bl.StateRoot, err = db.SaveAccountStorage(bl.StateRoot, registry, key, []byte{1})
+	if err != nil {
+		return err
+	}
+	offender, err := db.FetchAccount(bl.StateRoot, ev.Offender())
+	if err != nil {
+		return err
+	}
+	ev.Slash(offender)
+	bl.StateRoot, err = db.SaveAccount(bl.StateRoot, offender)
+	if err != nil {
+		return err
+	}
+	_, err = bl.AddEvidence(ev)
+	return err
+}
+
+// ReportTxSignatureFraud adds evidence of the invalid signature of the transaction at index in the
+// block to the pool, as found by VerifyTxSignatures. Only one offence is reported per block. The
+// block contents must be archived in db.
+func ReportTxSignatureFraud(db *figdb.DB, pool *figaro.TxPool, bl *figaro.Block, index int) error {
+	fp, err := ProveTxSignatureFraud(db, bl.BlockHeader, index)
+	if err != nil {
+		return err
+	}
+	ev := &figaro.Evidence{Type: figaro.FraudEvidence, Fraud: fp}
+	if !ev.Verify(db) {
+		return nil
+	}
+	err = pool.AddEvidence(ev)
+	if err != nil && err != figaro.ErrKnownEvidence {
+		return err
+	}
+	return nil
+}
+
+// ReportDoubleSign adds evidence to the pool if the header was signed by the same producer as
+// a different canonical block with the same Number.
+func ReportDoubleSign(db *figdb.DB, chain *figaro.Chain, pool *figaro.TxPool, header *figaro.BlockHeader) error {
+	if header.Number > chain.Depth || !header.VerifySignature() {
+		return nil
+	}
+	bhash, err := db.FetchChainBlock(header.Number)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(bhash, header.ID) {
+		return nil
+	}
+	canonical, err := db.FetchBlockHeader(bhash)
+	if err != nil {
+		return err
+	}
+	ev := &figaro.Evidence{Type: figaro.DoubleSignEvidence, Headers: [2]*figaro.BlockHeader{canonical, header}}
+	if !ev.Verify(db) {
+		return nil
+	}
+	err = pool.AddEvidence(ev)
+	if err != nil && err != figaro.ErrKnownEvidence {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/figaro-tech/go-fig-buf"
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+var (
+	// ErrInvalidSnapshot is a self-explantory error.
+	ErrInvalidSnapshot = errors.New("fig-node snapshot: invalid snapshot file")
+	// ErrSnapshotVersion is returned for a snapshot written by an unknown format version.
+	ErrSnapshotVersion = errors.New("fig-node snapshot: unsupported snapshot version")
+	// ErrSnapshotChecksum is returned when a snapshot file is corrupt.
+	ErrSnapshotChecksum = errors.New("fig-node snapshot: checksum mismatch")
+	// ErrSnapshotMismatch is returned when the imported state does not hash to the snapshot root.
+	ErrSnapshotMismatch = errors.New("fig-node snapshot: imported state does not match snapshot root")
+)
+
+// A snapshot file is the snapshotMagic, the SnapshotVersion, and the length prefixed state root,
+// followed by records and a SHA-256 checksum of everything before it. Each record is a kind,
+// a big-endian uint32 payload length and the payload. Every account record is followed by the
+// storage records of that account, and the last record is an end record.
+const (
+	// SnapshotVersion is the version of the snapshot format written by ExportState.
+	SnapshotVersion = 1
+
+	snapshotMagic         = "figsnap"
+	maxSnapshotRecordSize = 1 << 20
+
+	endRecord     byte = 0
+	accountRecord byte = 1
+	storageRecord byte = 2
+)
+
+// ExportState writes a snapshot of every account, and its storage, in the world state at root to w.
+func ExportState(db *figdb.DB, root figaro.Root, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	sum := sha256.New()
+	sw := &snapshotWriter{w: io.MultiWriter(bw, sum)}
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{SnapshotVersion})
+	sw.writeBytes(root)
+	err := db.IterateAccounts(root, func(account *figaro.Account) error {
+		b, err := account.Encode()
+		if err != nil {
+			return err
+		}
+		err = sw.record(accountRecord, encodePair(account.Address, b))
+		if err != nil {
+			return err
+		}
+		return db.IterateAccountStorage(account, func(key, value []byte) error {
+			return sw.record(storageRecord, encodePair(key, value))
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = sw.record(endRecord, nil)
+	if err != nil {
+		return err
+	}
+	_, err = bw.Write(sum.Sum(nil))
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportState reads a snapshot from r, rebuilding the account and storage tries in db, and returns
+// the state root once it is verified against the snapshot. Nothing is written to db on failure.
+func ImportState(db *figdb.DB, r io.Reader) (figaro.Root, error) {
+	db.FigDB.Store.Batch()
+	defer db.FigDB.Store.Discard() // noop if Write is called upon success, otherwise will discard
+
+	br := bufio.NewReader(r)
+	sum := sha256.New()
+	sr := &snapshotReader{r: io.TeeReader(br, sum)}
+	magic := sr.read(len(snapshotMagic))
+	version := sr.read(1)
+	want := figaro.Root(sr.readBytes())
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if string(magic) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+	if version[0] != SnapshotVersion {
+		return nil, ErrSnapshotVersion
+	}
+
+	var (
+		root        figaro.Root
+		account     *figaro.Account
+		storageRoot figaro.Root
+	)
+	// Each account is saved once its storage has been rebuilt, and checked against its StorageRoot
+	saveAccount := func() error {
+		if account == nil {
+			return nil
+		}
+		if !bytes.Equal(account.StorageRoot, storageRoot) {
+			return ErrSnapshotMismatch
+		}
+		var err error
+		root, err = db.SaveAccount(root, account)
+		return err
+	}
+	for {
+		kind, payload := sr.record()
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		if kind == endRecord {
+			break
+		}
+		key, value, err := decodePair(payload)
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		switch kind {
+		case accountRecord:
+			err = saveAccount()
+			if err != nil {
+				return nil, err
+			}
+			account = &figaro.Account{}
+			err = account.Decode(value)
+			if err != nil || !figaro.Address(key).Valid() {
+				return nil, ErrInvalidSnapshot
+			}
+			account.Address = key
+			storageRoot, account.StorageRoot = account.StorageRoot, nil
+		case storageRecord:
+			if account == nil {
+				return nil, ErrInvalidSnapshot
+			}
+			err = db.SetAccountStorage(account, key, value)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, ErrInvalidSnapshot
+		}
+	}
+	err := saveAccount()
+	if err != nil {
+		return nil, err
+	}
+	checksum := make([]byte, sha256.Size)
+	_, err = io.ReadFull(br, checksum)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	if !bytes.Equal(checksum, sum.Sum(nil)) {
+		return nil, ErrSnapshotChecksum
+	}
+	if !bytes.Equal(root, want) {
+		return nil, ErrSnapshotMismatch
+	}
+	return root, db.FigDB.Store.Write()
+}
+
+// snapshotWriter writes snapshot fields, holding the first error.
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *snapshotWriter) write(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *snapshotWriter) writeBytes(b []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	sw.write(n[:])
+	sw.write(b)
+}
+
+func (sw *snapshotWriter) record(kind byte, payload []byte) error {
+	sw.write([]byte{kind})
+	sw.writeBytes(payload)
+	return sw.err
+}
+
+// snapshotReader reads snapshot fields, holding the first error.
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *snapshotReader) read(n int) []byte {
+	b := make([]byte, n)
+	if sr.err == nil {
+		_, err := io.ReadFull(sr.r, b)
+		if err != nil {
+			sr.err = ErrInvalidSnapshot
+		}
+	}
+	return b
+}
+
+func (sr *snapshotReader) readBytes() []byte {
+	n := binary.BigEndian.Uint32(sr.read(4))
+	if n > maxSnapshotRecordSize {
+		if sr.err == nil {
+			sr.err = ErrInvalidSnapshot
+		}
+		return nil
+	}
+	return sr.read(int(n))
+}
+
+func (sr *snapshotReader) record() (byte, []byte) {
+	kind := sr.read(1)[0]
+	return kind, sr.readBytes()
+}
+
+func encodePair(key, value []byte) []byte {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	b, err := enc.EncodeList(func(buf []byte) []byte {
+		buf = enc.EncodeNextBytes(buf, key)
+		buf = enc.EncodeNextBytes(buf, value)
+		return buf
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func decodePair(buf []byte) (key, value []byte, err error) {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	err = dec.DecodeList(buf, func(r []byte) []byte {
+		key, r = dec.DecodeNextBytes(r)
+		value, r = dec.DecodeNextBytes(r)
+		return r
+	})
+	return
+}
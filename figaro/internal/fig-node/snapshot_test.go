@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+)
+
+func TestExportImportState(t *testing.T) {
+	src := figdb.NewMem(0, 16)
+	var root figaro.Root
+	var accounts []*figaro.Account
+	for i := 0; i < 50; i++ {
+		acc := &figaro.Account{
+			Address: bytes.Repeat([]byte{byte(i)}, figaro.AddressSize),
+			Nonce:   uint64(i),
+			Balance: uint64(1000 * (i + 1)),
+		}
+		if i%10 == 0 {
+			acc.Code = []byte{byte(i)}
+			for k := 0; k < 5; k++ {
+				err := src.SetAccountStorage(acc, []byte{byte(k)}, []byte{byte(i), byte(k)})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		var err error
+		root, err = src.SaveAccount(root, acc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts = append(accounts, acc)
+	}
+
+	var buf bytes.Buffer
+	err := ExportState(src, root, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := figdb.NewMem(0, 16)
+	got, err := ImportState(dst, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, root) {
+		t.Fatalf("imported root %x, want %x", got, root)
+	}
+	for _, want := range accounts {
+		acc, err := dst.FetchAccount(root, want.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := acc.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := want.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Errorf("account %x differs after import", want.Address)
+		}
+		var n int
+		err = dst.IterateAccountStorage(acc, func(key, value []byte) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want.Code != nil && n != 5 {
+			t.Errorf("account %x has %d storage keys after import, want 5", want.Address, n)
+		}
+	}
+
+	var n int
+	err = dst.IterateAccounts(root, func(acc *figaro.Account) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(accounts) {
+		t.Errorf("iterated %d accounts, want %d", n, len(accounts))
+	}
+}
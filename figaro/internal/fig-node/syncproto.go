@@ -0,0 +1,522 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/figaro-tech/go-fig-crypto/hasher"
+	"github.com/figaro-tech/go-figaro/figaro"
+	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+)
+
+// The sync protocols serve the canonical chain to syncing peers. Each request is a single
+// stream. Messages are a big-endian uint32 length followed by the payload, and a response
+// ends when the stream is closed.
+const (
+	// HeadersProtocol serves the canonical block headers for a range of block numbers.
+	HeadersProtocol protocol.ID = "/figaro/sync/headers/1.0.0"
+	// BlocksProtocol serves the canonical blocks for a range of block numbers.
+	BlocksProtocol protocol.ID = "/figaro/sync/blocks/1.0.0"
+	// BlockProtocol serves the block for a block hash, canonical or not.
+	BlockProtocol protocol.ID = "/figaro/sync/block/1.0.0"
+	// CompBlockProtocol serves the CompBlock for a block hash, canonical or not.
+	CompBlockProtocol protocol.ID = "/figaro/sync/compblock/1.0.0"
+	// RefBlockProtocol serves the RefBlock for a block hash, canonical or not.
+	RefBlockProtocol protocol.ID = "/figaro/sync/refblock/1.0.0"
+	// TxsProtocol serves the transactions for a block hash and a list of their IDs, for peers that
+	// rebuild a relayed RefBlock from their pool.
+	TxsProtocol protocol.ID = "/figaro/sync/txs/1.0.0"
+	// StateNodesProtocol serves the state trie nodes for a list of their hashes, for peers that
+	// download the world state node by node.
+	StateNodesProtocol protocol.ID = "/figaro/sync/statenodes/1.0.0"
+)
+
+const (
+	// MaxHeadersRequest is the max number of headers served for a single request.
+	MaxHeadersRequest = 512
+	// MaxBlocksRequest is the max number of blocks served for a single request.
+	MaxBlocksRequest = 32
+	// MaxStateNodesRequest is the max number of state trie nodes served for a single request.
+	MaxStateNodesRequest = 384
+)
+
+// The max size of each kind of message, which is checked before it is read. A message is read as
+// it arrives, so a peer that declares a large message must also send it.
+const (
+	// maxBlockMessageSize bounds a full block, or a CompBlock, which are bounded by the block
+	// GasLimit rather than by their encoding.
+	maxBlockMessageSize = 32 << 20
+	// maxRefBlockMessageSize bounds a RefBlock. MaxCommitSize commits and MaxTxSize tx IDs take
+	// under 5 MiB, which leaves room for the evidence.
+	maxRefBlockMessageSize = 8 << 20
+	maxHeaderMessageSize   = 16 << 10
+	// maxTxMessageSize bounds a tx, whose data is at most a DeployTx of MaxCodeSize code and
+	// MaxTxDataSize init code.
+	maxTxMessageSize        = figaro.MaxCodeSize + figaro.MaxTxDataSize + 1<<10
+	maxStateNodeMessageSize = 64 << 10
+	maxStatusMessageSize    = 1 << 10
+)
+
+// ErrInvalidSyncMessage is a self-explantory error.
+var ErrInvalidSyncMessage = errors.New("fig-node sync: invalid sync message")
+
+// ServeSync serves the canonical chain in db to syncing peers.
+func ServeSync(h host.Host, db *figdb.DB) {
+	handleSync(h, HeadersProtocol, func(s inet.Stream) {
+		defer s.Close()
+		serveRange(s, db, MaxHeadersRequest, func(id figaro.BlockHash) ([]byte, error) {
+			header, err := db.FetchBlockHeader(id)
+			if err != nil {
+				return nil, err
+			}
+			return header.Encode()
+		})
+	})
+	handleSync(h, BlocksProtocol, func(s inet.Stream) {
+		defer s.Close()
+		serveRange(s, db, MaxBlocksRequest, func(id figaro.BlockHash) ([]byte, error) {
+			block, err := db.FetchBlock(id)
+			if err != nil {
+				return nil, err
+			}
+			return block.Encode()
+		})
+	})
+	handleSync(h, BlockProtocol, func(s inet.Stream) {
+		defer s.Close()
+		serveHash(s, func(id figaro.BlockHash) ([]byte, error) {
+			block, err := db.FetchBlock(id)
+			if err != nil {
+				return nil, err
+			}
+			return block.Encode()
+		})
+	})
+	handleSync(h, CompBlockProtocol, func(s inet.Stream) {
+		defer s.Close()
+		serveHash(s, func(id figaro.BlockHash) ([]byte, error) {
+			cb, err := db.FetchCompBlock(id)
+			if err != nil {
+				return nil, err
+			}
+			return cb.Encode()
+		})
+	})
+	handleSync(h, RefBlockProtocol, func(s inet.Stream) {
+		defer s.Close()
+		serveHash(s, func(id figaro.BlockHash) ([]byte, error) {
+			rf, err := db.FetchRefBlock(id)
+			if err != nil {
+				return nil, err
+			}
+			return rf.Encode()
+		})
+	})
+	handleSync(h, TxsProtocol, func(s inet.Stream) {
+		defer s.Close()
+		id, err := readSyncMessage(s, figaro.BlockHashSize)
+		if err != nil {
+			return
+		}
+		ids, err := readSyncMessage(s, figaro.MaxTxSize*figaro.TxHashSize)
+		if err != nil || len(ids)%figaro.TxHashSize != 0 {
+			return
+		}
+		block, err := db.FetchBlock(id)
+		if err != nil {
+			return
+		}
+		txs := make(map[string]*figaro.Transaction, len(block.Transactions))
+		for _, tx := range block.Transactions {
+			txs[string(tx.ID)] = tx
+		}
+		for i := 0; i < len(ids); i += figaro.TxHashSize {
+			tx, ok := txs[string(ids[i:i+figaro.TxHashSize])]
+			if !ok {
+				s.Reset()
+				return
+			}
+			b, err := tx.Encode()
+			if err != nil {
+				s.Reset()
+				return
+			}
+			err = writeSyncMessage(s, b)
+			if err != nil {
+				return
+			}
+		}
+	})
+	handleSync(h, StateNodesProtocol, func(s inet.Stream) {
+		defer s.Close()
+		hashes, err := readSyncMessage(s, MaxStateNodesRequest*figaro.RootSize)
+		if err != nil || len(hashes)%figaro.RootSize != 0 {
+			return
+		}
+		// The response stops at the first node that is not known
+		for i := 0; i < len(hashes); i += figaro.RootSize {
+			b, err := db.FetchStateNode(hashes[i : i+figaro.RootSize])
+			if err != nil {
+				return
+			}
+			err = writeSyncMessage(s, b)
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// handleSync serves pid on h with handler, giving the peer syncRequestTimeout to send its request
+// and read the response, so that a stalled peer cannot hold the stream open.
+func handleSync(h host.Host, pid protocol.ID, handler inet.StreamHandler) {
+	h.SetStreamHandler(pid, func(s inet.Stream) {
+		err := s.SetDeadline(time.Now().Add(syncRequestTimeout))
+		if err != nil {
+			s.Reset()
+			return
+		}
+		handler(s)
+	})
+}
+
+// serveRange reads a range request and writes the encoding of each canonical block in the range,
+// up to the chain head and at most max blocks.
+func serveRange(s inet.Stream, db *figdb.DB, max uint64, encode func(id figaro.BlockHash) ([]byte, error)) {
+	var req [16]byte
+	_, err := io.ReadFull(s, req[:])
+	if err != nil {
+		return
+	}
+	from, count := binary.BigEndian.Uint64(req[:8]), binary.BigEndian.Uint64(req[8:])
+	if count > max {
+		count = max
+	}
+	chain, err := db.FetchChain()
+	if err != nil || chain == nil {
+		return
+	}
+	for n := from; n < from+count && n <= chain.Depth; n++ {
+		id, err := db.FetchChainBlock(n)
+		if err != nil || len(id) == 0 {
+			return
+		}
+		b, err := encode(id)
+		if err != nil {
+			s.Reset()
+			return
+		}
+		err = writeSyncMessage(s, b)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// serveHash reads a block hash request and writes the encoding of the block for it, if known.
+func serveHash(s inet.Stream, encode func(id figaro.BlockHash) ([]byte, error)) {
+	id, err := readSyncMessage(s, figaro.BlockHashSize)
+	if err != nil || !figaro.BlockHash(id).Valid() {
+		return
+	}
+	b, err := encode(id)
+	if err != nil {
+		return
+	}
+	writeSyncMessage(s, b)
+}
+
+// RequestHeaders requests up to count canonical block headers from a peer, starting at number from.
+// Fewer headers are returned if the peer does not have them. The header IDs are set from their hash.
+func RequestHeaders(ctx context.Context, h host.Host, p peer.ID, from, count uint64) ([]*figaro.BlockHeader, error) {
+	var headers []*figaro.BlockHeader
+	err := requestRange(ctx, h, p, HeadersProtocol, from, count, maxHeaderMessageSize, func(b []byte) error {
+		header := &figaro.BlockHeader{}
+		err := header.Decode(b)
+		if err != nil {
+			return ErrInvalidSyncMessage
+		}
+		header.ID, err = header.ToHash()
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header)
+		return nil
+	})
+	return headers, err
+}
+
+// RequestBlocks requests up to count canonical blocks from a peer, starting at number from.
+// Fewer blocks are returned if the peer does not have them. The block IDs are set from their hash,
+// but the block contents are not verified against the header.
+func RequestBlocks(ctx context.Context, h host.Host, p peer.ID, from, count uint64) ([]*figaro.Block, error) {
+	var blocks []*figaro.Block
+	err := requestRange(ctx, h, p, BlocksProtocol, from, count, maxBlockMessageSize, func(b []byte) error {
+		block := &figaro.Block{}
+		err := block.Decode(b)
+		if err != nil {
+			return ErrInvalidSyncMessage
+		}
+		block.ID, err = block.ToHash()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+		return nil
+	})
+	return blocks, err
+}
+
+// RequestBlock requests the block for id from a peer, verifying its hash, but not its contents.
+func RequestBlock(ctx context.Context, h host.Host, p peer.ID, id figaro.BlockHash) (*figaro.Block, error) {
+	block := &figaro.Block{}
+	err := requestHash(ctx, h, p, BlockProtocol, id, maxBlockMessageSize, func(b []byte) (*figaro.BlockHeader, error) {
+		err := block.Decode(b)
+		return block.BlockHeader, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// RequestCompBlock requests the CompBlock for id from a peer, verifying its hash, but not its blooms.
+func RequestCompBlock(ctx context.Context, h host.Host, p peer.ID, id figaro.BlockHash) (*figaro.CompBlock, error) {
+	cb := &figaro.CompBlock{}
+	err := requestHash(ctx, h, p, CompBlockProtocol, id, maxBlockMessageSize, func(b []byte) (*figaro.BlockHeader, error) {
+		err := cb.Decode(b)
+		return cb.BlockHeader, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+// RequestRefBlock requests the RefBlock for id from a peer, verifying its hash, but not its contents.
+func RequestRefBlock(ctx context.Context, h host.Host, p peer.ID, id figaro.BlockHash) (*figaro.RefBlock, error) {
+	rf := &figaro.RefBlock{}
+	err := requestHash(ctx, h, p, RefBlockProtocol, id, maxRefBlockMessageSize, func(b []byte) (*figaro.BlockHeader, error) {
+		err := rf.Decode(b)
+		return rf.BlockHeader, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// requestHash sends a block hash request, decoding the response with decode, and verifying that
+// the decoded header hashes to id. The header ID is set.
+func requestHash(ctx context.Context, h host.Host, p peer.ID, pid protocol.ID, id figaro.BlockHash, max int, decode func([]byte) (*figaro.BlockHeader, error)) error {
+	s, err := newStream(ctx, h, p, pid)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	err = writeSyncMessage(s, id)
+	if err != nil {
+		return err
+	}
+	b, err := readSyncMessage(s, max)
+	if err != nil {
+		return err
+	}
+	header, err := decode(b)
+	if err != nil || header == nil {
+		return ErrInvalidSyncMessage
+	}
+	header.ID, err = header.ToHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(header.ID, id) {
+		return ErrInvalidSyncMessage
+	}
+	return nil
+}
+
+// RequestTxs requests the transactions with txids in the block for id from a peer, in order.
+// Each tx ID is derived from the tx when decoded, so the peer cannot substitute another tx.
+func RequestTxs(ctx context.Context, h host.Host, p peer.ID, id figaro.BlockHash, txids []figaro.TxHash) ([]*figaro.Transaction, error) {
+	s, err := newStream(ctx, h, p, TxsProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	err = writeSyncMessage(s, id)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]byte, 0, len(txids)*figaro.TxHashSize)
+	for _, txid := range txids {
+		ids = append(ids, txid...)
+	}
+	err = writeSyncMessage(s, ids)
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]*figaro.Transaction, len(txids))
+	for i, txid := range txids {
+		b, err := readSyncMessage(s, maxTxMessageSize)
+		if err == io.EOF {
+			// The peer may have dropped the block, or some of its txs, since it announced it
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		tx := &figaro.Transaction{}
+		err = tx.Decode(b)
+		if err != nil || !bytes.Equal(tx.ID, txid) {
+			return nil, ErrInvalidSyncMessage
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+// RequestStateNodes requests the state trie nodes for hashes from a peer, in order, verifying each
+// against its hash. Fewer nodes are returned if the peer does not have them all.
+func RequestStateNodes(ctx context.Context, h host.Host, p peer.ID, hashes [][]byte) ([][]byte, error) {
+	s, err := newStream(ctx, h, p, StateNodesProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	req := make([]byte, 0, len(hashes)*figaro.RootSize)
+	for _, hash := range hashes {
+		req = append(req, hash...)
+	}
+	err = writeSyncMessage(s, req)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([][]byte, 0, len(hashes))
+	for _, hash := range hashes {
+		b, err := readSyncMessage(s, maxStateNodeMessageSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(hasher.Hash256(b), hash) {
+			return nil, ErrInvalidSyncMessage
+		}
+		nodes = append(nodes, b)
+	}
+	return nodes, nil
+}
+
+// requestRange sends a range request, calling fn with each message of the response.
+func requestRange(ctx context.Context, h host.Host, p peer.ID, pid protocol.ID, from, count uint64, max int, fn func([]byte) error) error {
+	s, err := newStream(ctx, h, p, pid)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	var req [16]byte
+	binary.BigEndian.PutUint64(req[:8], from)
+	binary.BigEndian.PutUint64(req[8:], count)
+	_, err = s.Write(req[:])
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		b, err := readSyncMessage(s, max)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newStream opens a stream to p for pid, which must be done by the deadline of ctx, if any. The
+// context only bounds opening the stream, so the deadline is set on the stream too.
+func newStream(ctx context.Context, h host.Host, p peer.ID, pid protocol.ID) (inet.Stream, error) {
+	s, err := h.NewStream(ctx, p, pid)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		err = s.SetDeadline(deadline)
+		if err != nil {
+			s.Reset()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func writeSyncMessage(w io.Writer, b []byte) error {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	_, err := w.Write(n[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readSyncMessage reads a message of up to max bytes, returning io.EOF if the stream closed
+// cleanly before it. A message over max returns ErrInvalidSyncMessage, while a stream that fails
+// mid message returns the error of the stream, since it is not necessarily the fault of the peer.
+// The message is buffered as it arrives, rather than for the size the peer declared.
+func readSyncMessage(r io.Reader, max int) ([]byte, error) {
+	var n [4]byte
+	_, err := io.ReadFull(r, n[:])
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if uint64(size) > uint64(max) {
+		return nil, ErrInvalidSyncMessage
+	}
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() < int(size) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+// fromPeers calls fn with each peer that advertised a chain at least depth blocks deep, deepest
+// first, until one succeeds. If none do, ErrNoSyncPeers is returned. A peer is reported only for
+// errors that prove it misbehaved, and never once ctx is done, which is not its fault.
+func fromPeers(ctx context.Context, h host.Host, peers *Peers, depth uint64, fn func(ctx context.Context, p peer.ID) error) error {
+	for _, p := range peers.Best(depth) {
+		rctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+		err := fn(rctx, p)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		peers.Report(p, err)
+		log.Printf("fig-node: sync request to peer %s failed: %v", p.Pretty(), err)
+	}
+	return ErrNoSyncPeers
+}
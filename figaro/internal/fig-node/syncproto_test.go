@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestReadSyncMessage(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeSyncMessage(&buf, []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := readSyncMessage(bytes.NewReader(buf.Bytes()), len("message"))
+	if err != nil || string(b) != "message" {
+		t.Errorf("readSyncMessage() = %q, %v, want %q", b, err, "message")
+	}
+	if _, err := readSyncMessage(bytes.NewReader(buf.Bytes()), len("message")-1); err != ErrInvalidSyncMessage {
+		t.Errorf("readSyncMessage() over max = %v, want %v", err, ErrInvalidSyncMessage)
+	}
+	if _, err := readSyncMessage(bytes.NewReader(nil), 1); err != io.EOF {
+		t.Errorf("readSyncMessage() of a closed stream = %v, want %v", err, io.EOF)
+	}
+
+	// A peer that declares more than it sends is not the cause of a buffer of the declared size
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], maxBlockMessageSize)
+	short := append(n[:], "short"...)
+	if _, err := readSyncMessage(bytes.NewReader(short), maxBlockMessageSize); err != io.ErrUnexpectedEOF {
+		t.Errorf("readSyncMessage() of a truncated message = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	readSyncMessage(bytes.NewReader(short), maxBlockMessageSize)
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("readSyncMessage() of a truncated message allocates %d bytes", alloc)
+	}
+}
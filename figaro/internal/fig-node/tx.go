@@ -1,206 +1,187 @@
 package internal
 
 import (
-	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+	"time"
+
 	"github.com/figaro-tech/go-figaro/figaro"
 	"github.com/figaro-tech/go-figaro/figaro/internal/fig-node/figdb"
+	"github.com/figaro-tech/go-figaro/figaro/vm"
 )
 
-// ValidateTx returns whether the transaction will fail if it is processed as the next transaction.
-// Assumes that signature is already verified as authentic.
-func ValidateTx(db *figdb.DB, tx *figaro.Transaction, txblock *figaro.BlockHeader, commitblock *figaro.Block) (bool, error) {
-	fromAcc, err := db.FetchAccount(txblock.StateRoot, tx.From)
-	if err != nil {
-		return false, err
-	}
-	if fromAcc == nil {
-		return false, nil
-	}
-	// Nonce must match
-	if tx.Nonce != fromAcc.Nonce {
-		return false, nil
-	}
-	// Is a valid TxType
-	if !figaro.ValidTxType(tx.Type) {
-		return false, nil
-	}
-	// Follows data limits
-	if len(tx.Data) > figaro.MaxTxDataSize {
-		return false, nil
-	}
-	// Is signed
-	if len(tx.Signature) != fastsig.SignatureSize {
-		return false, nil
-	}
-	// Sanity check
-	if tx.CommitBlock != commitblock.Number {
-		return false, nil
+// AddTx verifies a tx received from a client or peer, and adds it to the pool if it pays at least
+// the chain MinGasPrice.
+func AddTx(db *figdb.DB, pool *figaro.TxPool, sigs *SigVerifier, tx *figaro.Transaction) error {
+	if !tx.From.Valid() || !tx.ValidData() || !sigs.VerifyTx(tx) {
+		return figaro.ErrInvalidTransaction
 	}
-	// MPTx rules
-	diffN := txblock.Number - commitblock.Number
-	if diffN < uint64(txblock.WaitBlocks) || diffN > 2*uint64(txblock.WaitBlocks)+1 {
-		return false, nil
-	}
-	if !commitblock.HasCommit(tx.ID) {
-		return false, nil
-	}
-	// No free money
-	var totalFees uint32
-	if !commitblock.Beneficiary.IsZeroAddress() {
-		totalFees += commitblock.CommitFee
+	chain, err := db.FetchChain()
+	if err != nil {
+		return err
 	}
-	if !txblock.Beneficiary.IsZeroAddress() {
-		totalFees += txblock.TxFee
+	if chain != nil && tx.GasPrice < chain.MinGasPrice {
+		return figaro.ErrUnderpriced
 	}
-	switch tx.Type {
-	case figaro.StakeTx:
-		if tx.Value > fromAcc.Stake || uint64(totalFees) > fromAcc.Balance {
-			return false, nil
-		}
-	case figaro.BalanceTx:
-		if tx.Value+uint64(totalFees) > fromAcc.Balance {
-			return false, nil
-		}
-	default:
-		return false, nil
+	return pool.AddTx(&figaro.ReceivedTx{Transaction: *tx, Received: time.Now()})
+}
+
+// ValidateTx returns whether the transaction will fail if it is processed as the next transaction,
+// against the current state. Assumes that signature is already verified as authentic.
+func ValidateTx(state *figaro.StateOverlay, tx *figaro.Transaction, txblock *figaro.BlockHeader, commitblock *figaro.Block) (bool, error) {
+	fromAcc, err := state.FetchAccount(tx.From)
+	if err != nil {
+		return false, err
 	}
-	return true, nil
+	return figaro.CheckTx(tx, fromAcc, txblock, commitblock.BlockHeader, commitblock.HasCommit(tx.ID)), nil
 }
 
-// ExecuteTx executes a transaction, returning a transaction Receipt.
-// It assumes that the transaction is valid for processing, and will perform no checks.
-func ExecuteTx(db *figdb.DB, tx *figaro.Transaction, index uint16, txblock, commitblock *figaro.BlockHeader) (figaro.Root, *figaro.Receipt, error) {
-	var fromAcc, toAcc, cbAcc, txbAcc *figaro.Account
-	fromAcc, err := db.FetchAccount(txblock.StateRoot, tx.From)
+// FetchTxAccounts fetches the accounts touched by a transaction from the current state, sharing a
+// single Account between the roles of each address.
+func FetchTxAccounts(state *figaro.StateOverlay, tx *figaro.Transaction, txblock, commitblock *figaro.BlockHeader) (*figaro.TxAccounts, error) {
+	var err error
+	accs := &figaro.TxAccounts{}
+	accs.From, err = state.FetchAccount(tx.From)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	toAcc, err = db.FetchAccount(txblock.StateRoot, tx.To)
+	accs.To, err = state.FetchAccount(tx.To)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	fromAcc.Nonce++
-	var totalFees uint32
 	if !commitblock.Beneficiary.IsZeroAddress() {
-		cbAcc, err = db.FetchAccount(txblock.StateRoot, commitblock.Beneficiary)
+		accs.CommitBeneficiary, err = state.FetchAccount(commitblock.Beneficiary)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		cbAcc.Balance += uint64(commitblock.CommitFee)
-		fromAcc.Balance -= uint64(commitblock.CommitFee)
-		totalFees += commitblock.CommitFee
 	}
 	if !txblock.Beneficiary.IsZeroAddress() {
-		txbAcc, err = db.FetchAccount(txblock.StateRoot, txblock.Beneficiary)
+		accs.TxBeneficiary, err = state.FetchAccount(txblock.Beneficiary)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		txbAcc.Balance += uint64(txblock.TxFee)
-		fromAcc.Balance -= uint64(txblock.TxFee)
-		totalFees += txblock.TxFee
-	}
-	switch tx.Type {
-	case figaro.StakeTx:
-		fromAcc.Stake -= tx.Value
-		toAcc.Stake += tx.Value
-	case figaro.BalanceTx:
-		fromAcc.Balance -= tx.Value
-		toAcc.Balance += tx.Value
-	default:
-		return nil, nil, err
 	}
+	accs.Dedupe()
+	return accs, nil
+}
 
-	// TODO: create contract or execute data against contract
-
-	newroot := txblock.StateRoot
-	newroot, err = db.SaveAccount(newroot, fromAcc)
+// ExecuteTx executes a transaction against the current state, flushing it and returning the new
+// StateRoot and a transaction Receipt.
+// It assumes that the transaction is valid for processing, and will perform no checks.
+// If the tx targets an account with Code, or is a DeployTx with Init code, the code is run
+// with the gas left after the IntrinsicGas. If the code fails, the tx is reverted and executed
+// as an invalid tx, still paying for the gas it used. A BondTx adds the sender to the bonded list.
+func ExecuteTx(db *figdb.DB, state *figaro.StateOverlay, tx *figaro.Transaction, index uint16, txblock, commitblock *figaro.BlockHeader) (figaro.Root, *figaro.Receipt, error) {
+	accs, err := FetchTxAccounts(state, tx, txblock, commitblock)
 	if err != nil {
 		return nil, nil, err
 	}
-	newroot, err = db.SaveAccount(newroot, toAcc)
-	if err != nil {
-		return nil, nil, err
+	snapshot := state.Snapshot()
+	gasUsed := figaro.IntrinsicGas(tx)
+	var result *vm.Result
+	if figaro.RunsCode(tx, accs.To) {
+		result, err = RunContract(state, tx, accs.To, txblock, tx.GasLimit-gasUsed)
+		if err != nil {
+			return nil, nil, err
+		}
+		gasUsed += result.Steps * figaro.StepGas
+		if !result.Success {
+			state.RevertToSnapshot(snapshot)
+			newroot, receipt, err := ExecuteInvalidTx(state, tx, index, gasUsed, txblock, commitblock)
+			if err != nil {
+				return nil, nil, err
+			}
+			receipt.ReturnData = result.ReturnData
+			return newroot, receipt, nil
+		}
+		for _, w := range result.Writes {
+			err = state.SetStorage(accs.To.Address, w.Key, w.Value)
+			if err != nil {
+				state.RevertToSnapshot(snapshot)
+				return nil, nil, err
+			}
+		}
 	}
-	newroot, err = db.SaveAccount(newroot, cbAcc)
+	totalFees, err := figaro.ApplyTx(tx, accs, gasUsed, txblock, commitblock)
 	if err != nil {
+		state.RevertToSnapshot(snapshot)
 		return nil, nil, err
 	}
-	newroot, err = db.SaveAccount(newroot, txbAcc)
+	if tx.Type == figaro.BondTx {
+		err = figaro.AddBondedAddress(state, tx.From)
+		if err != nil {
+			state.RevertToSnapshot(snapshot)
+			return nil, nil, err
+		}
+	}
+	prevroot := state.Root()
+	newroot, err := saveAccounts(state, accs.Ordered())
 	if err != nil {
 		return nil, nil, err
 	}
-
 	receipt := &figaro.Receipt{
 		TxID:          tx.ID,
 		BlockNum:      txblock.Number,
 		Index:         index,
-		PrevStateRoot: txblock.StateRoot,
+		PrevStateRoot: prevroot,
 		StateRoot:     newroot,
+		GasUsed:       gasUsed,
 		TotalFees:     totalFees,
 		Success:       true,
 	}
+	if result != nil {
+		receipt.ReturnData = result.ReturnData
+	}
 	return newroot, receipt, nil
 }
 
-// ExecuteInvalidTx executes an invalid transaction, returning a transaction Receipt. It assumes
-// that the transaction is invalid for processing, and will perform no checks. Invalid executions
-// still pay fees to discourage spam txs, and still generate a receipt.
-func ExecuteInvalidTx(db *figdb.DB, tx *figaro.Transaction, index uint16, txblock, commitblock *figaro.BlockHeader) (figaro.Root, *figaro.Receipt, error) {
-	fromAcc, err := db.FetchAccount(txblock.StateRoot, tx.From)
-	if err != nil {
-		return nil, nil, err
-	}
-	fromAcc.Nonce++
-	var totalFees uint32
-	var feeRatio float64
-	if !commitblock.Beneficiary.IsZeroAddress() {
-		totalFees += commitblock.CommitFee
-	}
-	if !txblock.Beneficiary.IsZeroAddress() {
-		totalFees += txblock.TxFee
-	}
-	if uint64(totalFees) > fromAcc.Balance {
-		feeRatio = float64(fromAcc.Balance) / float64(totalFees)
-	} else {
-		feeRatio = 1
-	}
-	var cbAcc, txbAcc *figaro.Account
-	if !commitblock.Beneficiary.IsZeroAddress() {
-		cbAcc, err = db.FetchAccount(txblock.StateRoot, commitblock.Beneficiary)
+// RunContract runs the Code of the contract account with tx.Data as input, or for a DeployTx,
+// the Init code with no input, against the contract storage in state, using up to gas.
+// Storage writes are returned in the Result, and are not applied.
+func RunContract(state *figaro.StateOverlay, tx *figaro.Transaction, contract *figaro.Account, txblock *figaro.BlockHeader, gas uint64) (*vm.Result, error) {
+	ctx := &vm.Context{
+		Caller:  tx.From,
+		Address: tx.To,
+		Value:   tx.Value,
+		Number:  txblock.Number,
+		Input:   tx.Data,
+	}
+	code := contract.Code
+	if tx.Type == figaro.DeployTx {
+		d := &figaro.DeployData{}
+		err := d.Decode(tx.Data)
 		if err != nil {
-			return nil, nil, err
-		}
-		cfee := uint64(feeRatio * float64(commitblock.CommitFee))
-		if cfee > fromAcc.Balance {
-			// this should never happen
-			panic("invalid commit fee")
+			return nil, err
 		}
-		cbAcc.Balance += uint64(cfee)
-		fromAcc.Balance -= uint64(cfee)
+		code, ctx.Input = d.Init, nil
 	}
-	if !txblock.Beneficiary.IsZeroAddress() {
-		txbAcc, err = db.FetchAccount(txblock.StateRoot, txblock.Beneficiary)
-		if err != nil {
-			return nil, nil, err
-		}
-		txfee := uint64(feeRatio * float64(txblock.TxFee))
-		if txfee > fromAcc.Balance {
-			// this should never happen
-			panic("invalid tx fee")
-		}
-		txbAcc.Balance += uint64(txfee)
-		fromAcc.Balance -= uint64(txfee)
+	limit := gas / figaro.StepGas
+	if limit > vm.MaxSteps {
+		limit = vm.MaxSteps
 	}
-	newroot := txblock.StateRoot
-	newroot, err = db.SaveAccount(newroot, fromAcc)
-	if err != nil {
-		return nil, nil, err
-	}
-	newroot, err = db.SaveAccount(newroot, cbAcc)
+	return vm.Run(code, ctx, contractStorage{state, contract.Address}, limit)
+}
+
+// contractStorage reads contract storage for the vm.
+type contractStorage struct {
+	state   *figaro.StateOverlay
+	address figaro.Address
+}
+
+func (s contractStorage) Load(key []byte) ([]byte, error) {
+	return s.state.FetchStorage(s.address, key)
+}
+
+// ExecuteInvalidTx executes an invalid transaction that used gasUsed against the current state,
+// flushing it and returning the new StateRoot and a transaction Receipt. It assumes that the
+// transaction is invalid for processing, and will perform no checks. Invalid executions still
+// pay fees to discourage spam txs, and still generate a receipt.
+func ExecuteInvalidTx(state *figaro.StateOverlay, tx *figaro.Transaction, index uint16, gasUsed uint64, txblock, commitblock *figaro.BlockHeader) (figaro.Root, *figaro.Receipt, error) {
+	accs, err := FetchTxAccounts(state, tx, txblock, commitblock)
 	if err != nil {
 		return nil, nil, err
 	}
-	newroot, err = db.SaveAccount(newroot, txbAcc)
+	totalFees := figaro.ApplyInvalidTx(tx, accs, gasUsed, txblock, commitblock)
+	prevroot := state.Root()
+	newroot, err := saveAccounts(state, accs.Ordered())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -208,10 +189,19 @@ func ExecuteInvalidTx(db *figdb.DB, tx *figaro.Transaction, index uint16, txbloc
 		TxID:          tx.ID,
 		BlockNum:      txblock.Number,
 		Index:         index,
-		PrevStateRoot: txblock.StateRoot,
+		PrevStateRoot: prevroot,
 		StateRoot:     newroot,
+		GasUsed:       gasUsed,
 		TotalFees:     totalFees,
 		Success:       false,
 	}
 	return newroot, receipt, nil
 }
+
+// saveAccounts sets accounts, and flushes the state, returning the new state root.
+func saveAccounts(state *figaro.StateOverlay, accounts []*figaro.Account) (figaro.Root, error) {
+	for _, acc := range accounts {
+		state.SetAccount(acc)
+	}
+	return state.Flush()
+}
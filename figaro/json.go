@@ -0,0 +1,236 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidHexData is a self-explantory error.
+var ErrInvalidHexData = errors.New("figaro json: invalid hex data")
+
+// JSON representations are stable: binary data is encoded as a 0x prefixed hex string,
+// and addresses are encoded in their Base58 human readable form.
+
+// HexBytes is []byte that is JSON encoded as a 0x prefixed hex string.
+type HexBytes []byte
+
+// MarshalJSON implements json.Marshaler.
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal("0x" + hex.EncodeToString(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *HexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(s, "0x") {
+		return ErrInvalidHexData
+	}
+	*b, err = hex.DecodeString(s[2:])
+	if err != nil {
+		return ErrInvalidHexData
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (addr Address) MarshalJSON() ([]byte, error) {
+	if len(addr) == 0 {
+		return json.Marshal("")
+	}
+	return json.Marshal(addr.Human())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (addr *Address) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		*addr = nil
+		return nil
+	}
+	a, err := NewAddressFromHuman(s)
+	if err != nil {
+		return err
+	}
+	*addr = *a
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (root Root) MarshalJSON() ([]byte, error) { return HexBytes(root).MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (root *Root) UnmarshalJSON(data []byte) error { return (*HexBytes)(root).UnmarshalJSON(data) }
+
+// MarshalJSON implements json.Marshaler.
+func (bh BlockHash) MarshalJSON() ([]byte, error) { return HexBytes(bh).MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (bh *BlockHash) UnmarshalJSON(data []byte) error { return (*HexBytes)(bh).UnmarshalJSON(data) }
+
+// MarshalJSON implements json.Marshaler.
+func (txhash TxHash) MarshalJSON() ([]byte, error) { return HexBytes(txhash).MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (txhash *TxHash) UnmarshalJSON(data []byte) error {
+	return (*HexBytes)(txhash).UnmarshalJSON(data)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c Commit) MarshalJSON() ([]byte, error) { return HexBytes(c).MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Commit) UnmarshalJSON(data []byte) error { return (*HexBytes)(c).UnmarshalJSON(data) }
+
+// MarshalJSON implements json.Marshaler.
+func (bl BlockHeader) MarshalJSON() ([]byte, error) {
+	type header BlockHeader
+	return json.Marshal(struct {
+		header
+		ID        HexBytes `json:"id"`
+		Signature HexBytes `json:"signature"`
+	}{header(bl), bl.ID, bl.Signature})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (bl *BlockHeader) UnmarshalJSON(data []byte) error {
+	type header BlockHeader
+	v := struct {
+		*header
+		ID        HexBytes `json:"id"`
+		Signature HexBytes `json:"signature"`
+	}{header: (*header)(bl)}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	bl.ID, bl.Signature = v.ID, v.Signature
+	return nil
+}
+
+// blockJSON is the JSON representation of a Block. The header is nested, rather than
+// embedded, since the embedded header would otherwise take over the encoding.
+type blockJSON struct {
+	Header       *BlockHeader   `json:"header"`
+	CommitsBloom HexBytes       `json:"commitsBloom"`
+	Commits      []Commit       `json:"commits"`
+	TxBloom      HexBytes       `json:"txBloom"`
+	Transactions []*Transaction `json:"transactions"`
+	Evidence     []*Evidence    `json:"evidence"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (bl Block) MarshalJSON() ([]byte, error) {
+	return json.Marshal(blockJSON{bl.BlockHeader, bl.CommitsBloom, bl.Commits, bl.TxBloom, bl.Transactions, bl.Evidence})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (bl *Block) UnmarshalJSON(data []byte) error {
+	v := blockJSON{}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	if v.Header == nil {
+		return ErrInvalidBlock
+	}
+	bl.BlockHeader = v.Header
+	bl.CommitsBloom, bl.Commits = v.CommitsBloom, v.Commits
+	bl.TxBloom, bl.Transactions = v.TxBloom, v.Transactions
+	bl.Evidence = v.Evidence
+	return nil
+}
+
+// compBlockJSON is the JSON representation of a CompBlock, nesting the header as blockJSON does.
+type compBlockJSON struct {
+	Header       *BlockHeader `json:"header"`
+	CommitsBloom HexBytes     `json:"commitsBloom"`
+	TxBloom      HexBytes     `json:"txBloom"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (cb CompBlock) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compBlockJSON{cb.BlockHeader, cb.CommitsBloom, cb.TxBloom})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (cb *CompBlock) UnmarshalJSON(data []byte) error {
+	v := compBlockJSON{}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	if v.Header == nil {
+		return ErrInvalidBlock
+	}
+	cb.BlockHeader = v.Header
+	cb.CommitsBloom, cb.TxBloom = v.CommitsBloom, v.TxBloom
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (tx Transaction) MarshalJSON() ([]byte, error) {
+	type transaction Transaction
+	return json.Marshal(struct {
+		transaction
+		ID        HexBytes `json:"id"`
+		Signature HexBytes `json:"signature"`
+		Data      HexBytes `json:"data"`
+	}{transaction(tx), tx.ID, tx.Signature, tx.Data})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The ID is not trusted, and is derived
+// from the decoded fields.
+func (tx *Transaction) UnmarshalJSON(data []byte) error {
+	type transaction Transaction
+	v := struct {
+		*transaction
+		ID        HexBytes `json:"id"`
+		Signature HexBytes `json:"signature"`
+		Data      HexBytes `json:"data"`
+	}{transaction: (*transaction)(tx)}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	tx.Signature, tx.Data = v.Signature, v.Data
+	if !ValidTxType(tx.Type) {
+		return ErrInvalidTxTypeData
+	}
+	tx.ID, err = tx.ToHash()
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (acc Account) MarshalJSON() ([]byte, error) {
+	type account Account
+	return json.Marshal(struct {
+		account
+		Code HexBytes `json:"code"`
+	}{account(acc), acc.Code})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (acc *Account) UnmarshalJSON(data []byte) error {
+	type account Account
+	v := struct {
+		*account
+		Code HexBytes `json:"code"`
+	}{account: (*account)(acc)}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	acc.Code = v.Code
+	return nil
+}
@@ -0,0 +1,350 @@
+// Package keystore stores fastsig private keys in password encrypted files, indexed by Address.
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+	"github.com/figaro-tech/go-figaro/figaro"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	// ErrNoKey is a self-explantory error.
+	ErrNoKey = errors.New("figaro keystore: no key for address")
+	// ErrKeyExists is a self-explantory error.
+	ErrKeyExists = errors.New("figaro keystore: key already exists for address")
+	// ErrLocked is returned when a key must be unlocked before use.
+	ErrLocked = errors.New("figaro keystore: key is locked")
+	// ErrDecrypt is returned when a key cannot be decrypted, usually due to a wrong password.
+	ErrDecrypt = errors.New("figaro keystore: could not decrypt key")
+	// ErrKeyMismatch is returned when an imported private key does not belong to the address.
+	ErrKeyMismatch = errors.New("figaro keystore: private key does not match address")
+	// ErrInvalidKeyFile is a self-explantory error.
+	ErrInvalidKeyFile = errors.New("figaro keystore: invalid key file")
+)
+
+// Scrypt parameters, which are stored with each key so they can be raised later. Stored
+// parameters above the max are rejected, so that a key file cannot make key derivation
+// exhaust memory or CPU.
+const (
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltSize     = 32
+
+	maxScryptN = 1 << 20
+	maxScryptR = 8
+	maxScryptP = 16
+
+	keyFileVersion = 1
+	keyFilePrefix  = "key-"
+	keyFileSuffix  = ".json"
+)
+
+// scryptN is the scrypt cost of new keys. It is a var so that tests can lower it.
+var scryptN = 1 << 18
+
+// KeyStore stores keys in a directory, one encrypted file per Address. Keys can be
+// unlocked in memory, for a limited time, to sign without a password.
+type KeyStore struct {
+	dir string
+
+	mu       sync.Mutex
+	unlocked map[string]*unlockedKey
+}
+
+type unlockedKey struct {
+	privkey []byte
+	timer   *time.Timer
+}
+
+// New returns a KeyStore for dir, creating dir if needed.
+func New(dir string) (*KeyStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyStore{dir: dir, unlocked: make(map[string]*unlockedKey)}, nil
+}
+
+// NewKey generates a new key, stores it encrypted with password, and returns its Address.
+func (ks *KeyStore) NewKey(password string) (figaro.Address, error) {
+	pubkey, privkey, err := fastsig.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	address := figaro.Address(pubkey)
+	err = ks.store(address, privkey, password)
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// Import stores an existing private key for address, encrypted with password.
+func (ks *KeyStore) Import(address figaro.Address, privkey []byte, password string) error {
+	if !address.Valid() {
+		return figaro.ErrInvalidAddressData
+	}
+	if !matches(address, privkey) {
+		return ErrKeyMismatch
+	}
+	return ks.store(address, privkey, password)
+}
+
+// Export decrypts and returns the private key for address.
+func (ks *KeyStore) Export(address figaro.Address, password string) ([]byte, error) {
+	return ks.decrypt(address, password)
+}
+
+// Accounts lists the addresses of all stored keys.
+func (ks *KeyStore) Accounts() ([]figaro.Address, error) {
+	files, err := ioutil.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []figaro.Address
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, keyFilePrefix) || !strings.HasSuffix(name, keyFileSuffix) {
+			continue
+		}
+		address := make(figaro.Address, figaro.AddressSize)
+		err = address.SetHex(strings.TrimSuffix(strings.TrimPrefix(name, keyFilePrefix), keyFileSuffix))
+		if err != nil {
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// Unlock decrypts the key for address and holds it in memory until timeout passes, or
+// until it is locked. A timeout of 0 holds the key until it is locked.
+func (ks *KeyStore) Unlock(address figaro.Address, password string, timeout time.Duration) error {
+	privkey, err := ks.decrypt(address, password)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.lock(address)
+	u := &unlockedKey{privkey: privkey}
+	if timeout > 0 {
+		u.timer = time.AfterFunc(timeout, func() {
+			ks.mu.Lock()
+			defer ks.mu.Unlock()
+			if ks.unlocked[string(address)] == u {
+				ks.lock(address)
+			}
+		})
+	}
+	ks.unlocked[string(address)] = u
+	return nil
+}
+
+// Lock removes the key for address from memory.
+func (ks *KeyStore) Lock(address figaro.Address) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.lock(address)
+}
+
+// Unlocked returns whether the key for address is unlocked.
+func (ks *KeyStore) Unlocked(address figaro.Address) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	_, ok := ks.unlocked[string(address)]
+	return ok
+}
+
+// SignTx signs tx with the unlocked key for its sender.
+func (ks *KeyStore) SignTx(tx *figaro.Transaction) error {
+	return ks.withKey(tx.From, tx.Sign)
+}
+
+// SignBlock signs a block header with the unlocked key for its producer.
+func (ks *KeyStore) SignBlock(bl *figaro.BlockHeader) error {
+	return ks.withKey(bl.Producer, bl.Sign)
+}
+
+// withKey calls f with the unlocked key for address. The key is held locked, so
+// that it cannot be wiped while in use.
+func (ks *KeyStore) withKey(address figaro.Address, f func(privkey []byte) error) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	u, ok := ks.unlocked[string(address)]
+	if !ok {
+		return ErrLocked
+	}
+	return f(u.privkey)
+}
+
+// lock must be called with ks.mu held.
+func (ks *KeyStore) lock(address figaro.Address) {
+	u, ok := ks.unlocked[string(address)]
+	if !ok {
+		return
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	for i := range u.privkey {
+		u.privkey[i] = 0
+	}
+	delete(ks.unlocked, string(address))
+}
+
+func (ks *KeyStore) path(address figaro.Address) string {
+	return filepath.Join(ks.dir, keyFilePrefix+address.Hex()+keyFileSuffix)
+}
+
+// keyFile is the JSON encoded, encrypted key file. The private key is encrypted with
+// AES-256-GCM under a key derived from the password with scrypt, and the address
+// is authenticated as additional data.
+type keyFile struct {
+	Version    int             `json:"version"`
+	Address    figaro.Address  `json:"address"`
+	Cipher     string          `json:"cipher"`
+	CipherText figaro.HexBytes `json:"ciphertext"`
+	Nonce      figaro.HexBytes `json:"nonce"`
+	KDF        string          `json:"kdf"`
+	KDFParams  scryptParams    `json:"kdfparams"`
+}
+
+type scryptParams struct {
+	N      int             `json:"n"`
+	R      int             `json:"r"`
+	P      int             `json:"p"`
+	KeyLen int             `json:"keylen"`
+	Salt   figaro.HexBytes `json:"salt"`
+}
+
+func (ks *KeyStore) store(address figaro.Address, privkey []byte, password string) error {
+	path := ks.path(address)
+	// Fail early, before deriving the key, but the key file is only created by os.Link below,
+	// which never replaces an existing file
+	_, err := os.Stat(path)
+	if err == nil {
+		return ErrKeyExists
+	}
+	params := scryptParams{N: scryptN, R: scryptR, P: scryptP, KeyLen: scryptKeyLen, Salt: make([]byte, saltSize)}
+	_, err = rand.Read(params.Salt)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(password, params)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	kf := keyFile{
+		Version:    keyFileVersion,
+		Address:    address,
+		Cipher:     "aes-256-gcm",
+		CipherText: gcm.Seal(nil, nonce, privkey, address),
+		Nonce:      nonce,
+		KDF:        "scrypt",
+		KDFParams:  params,
+	}
+	b, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file first, so that a key file is never partially written
+	tmp, err := ioutil.TempFile(ks.dir, keyFilePrefix+"*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Link(tmp.Name(), path)
+	if os.IsExist(err) {
+		return ErrKeyExists
+	}
+	return err
+}
+
+func (ks *KeyStore) decrypt(address figaro.Address, password string) ([]byte, error) {
+	b, err := ioutil.ReadFile(ks.path(address))
+	if os.IsNotExist(err) {
+		return nil, ErrNoKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	kf := keyFile{}
+	err = json.Unmarshal(b, &kf)
+	if err != nil || kf.Version != keyFileVersion || kf.Cipher != "aes-256-gcm" || kf.KDF != "scrypt" {
+		return nil, ErrInvalidKeyFile
+	}
+	if !bytes.Equal(kf.Address, address) {
+		return nil, ErrInvalidKeyFile
+	}
+	gcm, err := newGCM(password, kf.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(kf.Nonce) != gcm.NonceSize() {
+		return nil, ErrInvalidKeyFile
+	}
+	privkey, err := gcm.Open(nil, kf.Nonce, kf.CipherText, address)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return privkey, nil
+}
+
+func newGCM(password string, params scryptParams) (cipher.AEAD, error) {
+	if params.KeyLen != scryptKeyLen {
+		return nil, ErrInvalidKeyFile
+	}
+	if params.N <= 1 || params.N > maxScryptN || params.R <= 0 || params.R > maxScryptR || params.P <= 0 || params.P > maxScryptP {
+		return nil, ErrInvalidKeyFile
+	}
+	key, err := scrypt.Key([]byte(password), params.Salt, params.N, params.R, params.P, params.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// matches returns whether privkey is the private key for address.
+func matches(address figaro.Address, privkey []byte) bool {
+	probe := []byte("figaro/keystore")
+	sig, err := fastsig.Sign(privkey, probe)
+	if err != nil {
+		return false
+	}
+	return fastsig.Verify(address, sig, probe)
+}
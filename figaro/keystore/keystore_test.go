@@ -0,0 +1,178 @@
+package keystore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/figaro-tech/go-fig-crypto/signature/fastsig"
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+func init() {
+	// Keep key derivation fast, the cost is stored with each key
+	scryptN = 1 << 4
+}
+
+// newTestKeyStore returns a KeyStore in a new temp dir, which the caller must remove.
+func newTestKeyStore(t *testing.T) (*KeyStore, string) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return ks, dir
+}
+
+func TestSignTx(t *testing.T) {
+	ks, dir := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+	address, err := ks.NewKey("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &figaro.Transaction{From: address, To: address}
+	tx.ID, err = tx.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SignTx(tx); err != ErrLocked {
+		t.Fatalf("SignTx() of a locked key = %v, want %v", err, ErrLocked)
+	}
+	err = ks.Unlock(address, "password", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ks.SignTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.VerifySignature() {
+		t.Error("tx signature does not verify")
+	}
+}
+
+func TestUnlockWrongPassword(t *testing.T) {
+	ks, dir := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+	address, err := ks.NewKey("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock(address, "wrong", 0); err != ErrDecrypt {
+		t.Errorf("Unlock() = %v, want %v", err, ErrDecrypt)
+	}
+	if ks.Unlocked(address) {
+		t.Error("key unlocked with the wrong password")
+	}
+}
+
+func TestImport(t *testing.T) {
+	ks, dir := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+	pubkey, privkey, err := fastsig.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := fastsig.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Import(other, privkey, "password"); err != ErrKeyMismatch {
+		t.Errorf("Import() of a mismatched key = %v, want %v", err, ErrKeyMismatch)
+	}
+	err = ks.Import(pubkey, privkey, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Import(pubkey, privkey, "password"); err != ErrKeyExists {
+		t.Errorf("Import() of a stored key = %v, want %v", err, ErrKeyExists)
+	}
+	exported, err := ks.Export(pubkey, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(exported) != string(privkey) {
+		t.Error("exported key does not match the imported key")
+	}
+}
+
+func TestUnlockTimeout(t *testing.T) {
+	ks, dir := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+	address, err := ks.NewKey("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ks.Unlock(address, "password", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.mu.Lock()
+	privkey := ks.unlocked[string(address)].privkey
+	ks.mu.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	if ks.Unlocked(address) {
+		t.Fatal("key is still unlocked after the timeout")
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, b := range privkey {
+		if b != 0 {
+			t.Fatal("key was not wiped from memory")
+		}
+	}
+}
+
+func TestTamperedKeyFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(kf map[string]interface{})
+	}{
+		{"version", func(kf map[string]interface{}) { kf["version"] = keyFileVersion + 1 }},
+		{"cipher", func(kf map[string]interface{}) { kf["cipher"] = "aes-128-ctr" }},
+		{"kdf", func(kf map[string]interface{}) { kf["kdf"] = "pbkdf2" }},
+		{"nonce", func(kf map[string]interface{}) { kf["nonce"] = "0x00" }},
+		{"scrypt n", func(kf map[string]interface{}) { kf["kdfparams"].(map[string]interface{})["n"] = maxScryptN * 2 }},
+		{"scrypt r", func(kf map[string]interface{}) { kf["kdfparams"].(map[string]interface{})["r"] = maxScryptR + 1 }},
+		{"scrypt p", func(kf map[string]interface{}) { kf["kdfparams"].(map[string]interface{})["p"] = maxScryptP + 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, dir := newTestKeyStore(t)
+			defer os.RemoveAll(dir)
+			address, err := ks.NewKey("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(ks.path(address))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kf := make(map[string]interface{})
+			err = json.Unmarshal(b, &kf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(kf)
+			b, err = json.Marshal(kf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(ks.path(address), b, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ks.Unlock(address, "password", 0); err != ErrInvalidKeyFile {
+				t.Errorf("Unlock() = %v, want %v", err, ErrInvalidKeyFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import "encoding/json"
+
+// StateProof is a Merkle proof of a key in a state trie.
+type StateProof [][][]byte
+
+// ArchiveProof is a Merkle proof of an index in an archive.
+type ArchiveProof [][]byte
+
+// AccountProof is an account along with its Merkle proof in a state root.
+type AccountProof struct {
+	Account *Account   `json:"account"`
+	Proof   StateProof `json:"proof"`
+}
+
+// StorageProof is a value in account storage, along with a Merkle proof of the key in the
+// account StorageRoot. A missing key has an empty value.
+type StorageProof struct {
+	Key   HexBytes   `json:"key"`
+	Value HexBytes   `json:"value"`
+	Proof StateProof `json:"proof"`
+}
+
+// TxProof is a transaction, along with a Merkle proof of the transaction at its index in the
+// TransactionsRoot of a block.
+type TxProof struct {
+	Transaction *Transaction `json:"tx"`
+	Index       int          `json:"index"`
+	Proof       ArchiveProof `json:"proof"`
+}
+
+// ReceiptProof is a receipt, along with a Merkle proof of the receipt at its index in the
+// ReceiptsRoot of a block. The TxID is not part of the proof.
+type ReceiptProof struct {
+	Receipt *Receipt     `json:"receipt"`
+	Index   int          `json:"index"`
+	Proof   ArchiveProof `json:"proof"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p StateProof) MarshalJSON() ([]byte, error) {
+	v := make([][]HexBytes, len(p))
+	for i, node := range p {
+		v[i] = make([]HexBytes, len(node))
+		for j, b := range node {
+			v[i][j] = b
+		}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *StateProof) UnmarshalJSON(data []byte) error {
+	var v [][]HexBytes
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	*p = make(StateProof, len(v))
+	for i, node := range v {
+		(*p)[i] = make([][]byte, len(node))
+		for j, b := range node {
+			(*p)[i][j] = b
+		}
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p ArchiveProof) MarshalJSON() ([]byte, error) {
+	v := make([]HexBytes, len(p))
+	for i, b := range p {
+		v[i] = b
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *ArchiveProof) UnmarshalJSON(data []byte) error {
+	var v []HexBytes
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	*p = make(ArchiveProof, len(v))
+	for i, b := range v {
+		(*p)[i] = b
+	}
+	return nil
+}
@@ -5,13 +5,16 @@ import "github.com/figaro-tech/go-fig-buf"
 
 // Receipt is a record of a processed transaction.
 type Receipt struct {
-	TxID          TxHash
-	BlockNum      uint64
-	Index         uint16
-	PrevStateRoot Root
-	StateRoot     Root
-	TotalFees     uint32
-	Success       bool
+	TxID          TxHash `json:"txId"`
+	BlockNum      uint64 `json:"blockNum"`
+	Index         uint16 `json:"index"`
+	PrevStateRoot Root   `json:"prevStateRoot"`
+	StateRoot     Root   `json:"stateRoot"`
+	GasUsed       uint64 `json:"gasUsed"`
+	TotalFees     uint64 `json:"totalFees"`
+	Success       bool   `json:"success"`
+	// ReturnData is the data returned, or reverted with, by contract code.
+	ReturnData HexBytes `json:"returnData"`
 }
 
 // Encode encodes to binary.
@@ -24,8 +27,10 @@ func (rc Receipt) Encode() ([]byte, error) {
 		buf = enc.EncodeNextUint16(buf, rc.Index)
 		buf = enc.EncodeNextBytes(buf, rc.PrevStateRoot)
 		buf = enc.EncodeNextBytes(buf, rc.StateRoot)
-		buf = enc.EncodeNextUint32(buf, rc.TotalFees)
+		buf = enc.EncodeNextUint64(buf, rc.GasUsed)
+		buf = enc.EncodeNextUint64(buf, rc.TotalFees)
 		buf = enc.EncodeNextBool(buf, rc.Success)
+		buf = enc.EncodeNextBytes(buf, rc.ReturnData)
 		return buf
 	})
 }
@@ -40,8 +45,10 @@ func (rc *Receipt) Decode(buf []byte) error {
 		rc.Index, r = dec.DecodeNextUint16(r)
 		rc.PrevStateRoot, r = dec.DecodeNextBytes(r)
 		rc.StateRoot, r = dec.DecodeNextBytes(r)
-		rc.TotalFees, r = dec.DecodeNextUint32(r)
+		rc.GasUsed, r = dec.DecodeNextUint64(r)
+		rc.TotalFees, r = dec.DecodeNextUint64(r)
 		rc.Success, r = dec.DecodeNextBool(r)
+		rc.ReturnData, r = dec.DecodeNextBytes(r)
 		return r
 	})
 }
@@ -49,10 +56,13 @@ func (rc *Receipt) Decode(buf []byte) error {
 // ReceiptLDataService handles limited local storage of receipts.
 type ReceiptLDataService interface {
 	FetchReceipt(txid TxHash) (*Receipt, error)
+	GetAndProveReceipt(root Root, index int) (receipt *Receipt, proof [][]byte, err error)
+	ValidateReceipt(root Root, index int, receipt Receipt, proof [][]byte) bool
 }
 
 // ReceiptDataService handles db storage of receipts.
 type ReceiptDataService interface {
 	SaveReceipt(r Receipt) error
+	ArchiveReceipts(receipts []*Receipt) (root Root, err error)
 	ReceiptLDataService
 }
@@ -0,0 +1,169 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import "sort"
+
+// StateOverlay is an in-memory journal of account and contract storage changes over the world
+// state at a root. Accounts are cached as they are fetched, and set accounts and storage are
+// held in memory until Flush writes them to the state trie, so that the intermediate roots of
+// individual writes are never computed. Changes since a Snapshot can be reverted. Accounts are
+// copied in and out, so that callers may change them freely. It is not safe for concurrent use.
+type StateOverlay struct {
+	db   AccountDataService
+	root Root
+
+	accounts map[string]*Account
+	storage  map[string]map[string][]byte
+	dirty    map[string]bool
+	journal  []journalEntry
+}
+
+// journalEntry records the account at an address before it was set, or for a storage write, the
+// pending value at the storage key before it was set.
+type journalEntry struct {
+	key   string
+	prev  *Account
+	dirty bool
+
+	storage     bool
+	storageKey  string
+	storagePrev []byte
+	storageHad  bool
+}
+
+// NewStateOverlay returns a StateOverlay over the world state at root.
+func NewStateOverlay(db AccountDataService, root Root) *StateOverlay {
+	return &StateOverlay{
+		db:       db,
+		root:     root,
+		accounts: make(map[string]*Account),
+		storage:  make(map[string]map[string][]byte),
+		dirty:    make(map[string]bool),
+	}
+}
+
+// Root returns the world state root as of the last Flush.
+func (s *StateOverlay) Root() Root {
+	return s.root
+}
+
+// FetchAccount returns a copy of the current account at address.
+func (s *StateOverlay) FetchAccount(address Address) (*Account, error) {
+	acc, ok := s.accounts[string(address)]
+	if !ok {
+		var err error
+		acc, err = s.db.FetchAccount(s.root, address)
+		if err != nil {
+			return nil, err
+		}
+		s.accounts[string(address)] = acc
+	}
+	cp := *acc
+	return &cp, nil
+}
+
+// SetAccount sets a copy of acc as the current account at its address.
+func (s *StateOverlay) SetAccount(acc *Account) {
+	key := string(acc.Address)
+	s.journal = append(s.journal, journalEntry{key: key, prev: s.accounts[key], dirty: s.dirty[key]})
+	cp := *acc
+	s.accounts[key] = &cp
+	s.dirty[key] = true
+}
+
+// FetchStorage returns the current value at key in the storage of the account at address.
+func (s *StateOverlay) FetchStorage(address Address, key []byte) ([]byte, error) {
+	if data, ok := s.storage[string(address)][string(key)]; ok {
+		return data, nil
+	}
+	acc, err := s.FetchAccount(address)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.FetchAccountStorage(acc, key)
+}
+
+// SetStorage sets the value at key in the storage of the account at address. The StorageRoot of
+// the account is updated when it is flushed.
+func (s *StateOverlay) SetStorage(address Address, key, data []byte) error {
+	_, err := s.FetchAccount(address)
+	if err != nil {
+		return err
+	}
+	akey, skey := string(address), string(key)
+	pending, ok := s.storage[akey]
+	if !ok {
+		pending = make(map[string][]byte)
+		s.storage[akey] = pending
+	}
+	prev, had := pending[skey]
+	s.journal = append(s.journal, journalEntry{key: akey, dirty: s.dirty[akey], storage: true, storageKey: skey, storagePrev: prev, storageHad: had})
+	pending[skey] = append([]byte(nil), data...)
+	s.dirty[akey] = true
+	return nil
+}
+
+// Snapshot returns an identifier for the current state, to pass to RevertToSnapshot.
+func (s *StateOverlay) Snapshot() int {
+	return len(s.journal)
+}
+
+// RevertToSnapshot reverts every account and storage value set since the snapshot was taken.
+// Snapshots taken before the last Flush cannot be reverted to.
+func (s *StateOverlay) RevertToSnapshot(snapshot int) {
+	for i := len(s.journal) - 1; i >= snapshot; i-- {
+		e := s.journal[i]
+		if e.storage {
+			if e.storageHad {
+				s.storage[e.key][e.storageKey] = e.storagePrev
+			} else {
+				delete(s.storage[e.key], e.storageKey)
+			}
+		} else if e.prev == nil {
+			delete(s.accounts, e.key)
+		} else {
+			s.accounts[e.key] = e.prev
+		}
+		if !e.dirty {
+			delete(s.dirty, e.key)
+		}
+	}
+	s.journal = s.journal[:snapshot]
+}
+
+// Flush writes the accounts and storage set since the last Flush to the state trie, in address
+// and key order, returning the new world state root. The journal is cleared.
+func (s *StateOverlay) Flush() (Root, error) {
+	keys := make([]string, 0, len(s.dirty))
+	for key := range s.dirty {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	root := s.root
+	for _, key := range keys {
+		acc := *s.accounts[key]
+		pending := s.storage[key]
+		skeys := make([]string, 0, len(pending))
+		for skey := range pending {
+			skeys = append(skeys, skey)
+		}
+		sort.Strings(skeys)
+		for _, skey := range skeys {
+			err := s.db.SetAccountStorage(&acc, []byte(skey), pending[skey])
+			if err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		root, err = s.db.SaveAccount(root, &acc)
+		if err != nil {
+			return nil, err
+		}
+		s.accounts[key] = &acc
+	}
+	s.root = root
+	s.storage = make(map[string]map[string][]byte)
+	s.dirty = make(map[string]bool)
+	s.journal = s.journal[:0]
+	return root, nil
+}
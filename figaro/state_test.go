@@ -0,0 +1,46 @@
+package figaro
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStateOverlayRevertStorage(t *testing.T) {
+	contract := Address("contract")
+	state := NewStateOverlay(memState{}, Root("root"))
+	fetch := func(key string) []byte {
+		data, err := state.FetchStorage(contract, []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	set := func(key, data string) {
+		err := state.SetStorage(contract, []byte(key), []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set("a", "1")
+	snapshot := state.Snapshot()
+	set("a", "2")
+	set("b", "3")
+	if got := fetch("a"); !bytes.Equal(got, []byte("2")) {
+		t.Errorf("a = %q before revert, want %q", got, "2")
+	}
+	state.RevertToSnapshot(snapshot)
+	if got := fetch("a"); !bytes.Equal(got, []byte("1")) {
+		t.Errorf("a = %q after revert, want %q", got, "1")
+	}
+	if got := fetch("b"); got != nil {
+		t.Errorf("b = %q after revert, want nil", got)
+	}
+	state.RevertToSnapshot(0)
+	if got := fetch("a"); got != nil {
+		t.Errorf("a = %q after full revert, want nil", got)
+	}
+	if len(state.dirty) != 0 {
+		t.Errorf("%d dirty accounts after full revert, want 0", len(state.dirty))
+	}
+}
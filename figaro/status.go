@@ -0,0 +1,130 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/figaro-tech/go-fig-buf"
+)
+
+const (
+	// ProtocolVersion is the version of the figaro wire protocols spoken by this node.
+	ProtocolVersion uint32 = 1
+	// MinProtocolVersion is the oldest version of the figaro wire protocols this node can talk to.
+	MinProtocolVersion uint32 = 1
+)
+
+// DefaultNetworkID is the network ID of the main figaro network.
+const DefaultNetworkID uint64 = 1
+
+var (
+	// ErrNetworkMismatch is returned when a peer is on a different network.
+	ErrNetworkMismatch = errors.New("figaro status: network ID mismatch")
+	// ErrIncompatibleVersion is returned when a peer speaks an incompatible protocol version.
+	ErrIncompatibleVersion = errors.New("figaro status: incompatible protocol version")
+)
+
+// Status is exchanged by peers when they connect, identifying their network and chain, and
+// advertising their chain head.
+type Status struct {
+	NetworkID uint64    `json:"networkId"`
+	Version   uint32    `json:"version"`
+	Genesis   BlockHash `json:"genesis"`
+	Depth     uint64    `json:"depth"`
+	Head      BlockHash `json:"head"`
+}
+
+// Compatible returns an error if a peer with status theirs is on another network or chain, or
+// speaks an incompatible protocol version.
+func (s Status) Compatible(theirs *Status) error {
+	if theirs.NetworkID != s.NetworkID {
+		return ErrNetworkMismatch
+	}
+	if !bytes.Equal(theirs.Genesis, s.Genesis) {
+		return ErrGenesisMismatch
+	}
+	// A newer peer judges for itself whether it can talk to us
+	if theirs.Version < MinProtocolVersion {
+		return ErrIncompatibleVersion
+	}
+	return nil
+}
+
+// Encode encodes to binary.
+func (s Status) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	return enc.EncodeList(func(buf []byte) []byte {
+		buf = enc.EncodeNextUint64(buf, s.NetworkID)
+		buf = enc.EncodeNextUint32(buf, s.Version)
+		buf = enc.EncodeNextBytes(buf, s.Genesis)
+		buf = enc.EncodeNextUint64(buf, s.Depth)
+		buf = enc.EncodeNextBytes(buf, s.Head)
+		return buf
+	})
+}
+
+// Decode decodes from binary.
+func (s *Status) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	return dec.DecodeList(buf, func(r []byte) []byte {
+		s.NetworkID, r = dec.DecodeNextUint64(r)
+		s.Version, r = dec.DecodeNextUint32(r)
+		s.Genesis, r = dec.DecodeNextBytes(r)
+		s.Depth, r = dec.DecodeNextUint64(r)
+		s.Head, r = dec.DecodeNextBytes(r)
+		return r
+	})
+}
+
+// PeerBan bans a peer, by its binary peer ID, from connecting until a time.
+type PeerBan struct {
+	Peer  string    `json:"peer"`
+	Until time.Time `json:"until"`
+}
+
+// PeerBans is a list of banned peers.
+type PeerBans []PeerBan
+
+// Encode encodes to binary.
+func (bans PeerBans) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	return enc.EncodeList(func(buf []byte) []byte {
+		for _, ban := range bans {
+			buf = enc.EncodeNextList(buf, func(buf []byte) []byte {
+				buf = enc.EncodeNextBytes(buf, []byte(ban.Peer))
+				buf = enc.EncodeNextTextMarshaler(buf, ban.Until)
+				return buf
+			})
+		}
+		return buf
+	})
+}
+
+// Decode decodes from binary.
+func (bans *PeerBans) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	return dec.DecodeList(buf, func(r []byte) []byte {
+		for len(r) > 0 {
+			r = dec.DecodeNextList(r, func(r []byte) []byte {
+				var ban PeerBan
+				var p []byte
+				p, r = dec.DecodeNextBytes(r)
+				ban.Peer = string(p)
+				r = dec.DecodeNextTextUnmarshaler(r, &ban.Until)
+				*bans = append(*bans, ban)
+				return r
+			})
+		}
+		return r
+	})
+}
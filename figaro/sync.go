@@ -0,0 +1,87 @@
+// Package figaro is the main package for go-figaro
+package figaro
+
+import "github.com/figaro-tech/go-fig-buf"
+
+// SyncStage is a stage of fast sync.
+type SyncStage uint8
+
+// A fast sync downloads and verifies the header chain up to a pivot block, downloads the
+// world state at the pivot, and then executes the blocks after the pivot in full.
+const (
+	// SyncHeaders is downloading and verifying the header chain up to the pivot block.
+	SyncHeaders SyncStage = iota + 1
+	// SyncState is downloading the world state at the pivot block.
+	SyncState
+	// SyncBlocks is executing the blocks after the pivot block.
+	SyncBlocks
+	// SyncDone is a completed fast sync.
+	SyncDone
+)
+
+// String implements fmt.Stringer.
+func (s SyncStage) String() string {
+	switch s {
+	case SyncHeaders:
+		return "headers"
+	case SyncState:
+		return "state"
+	case SyncBlocks:
+		return "blocks"
+	case SyncDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, so SyncStage is readable in JSON.
+func (s SyncStage) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// SyncProgress is the progress of a fast sync, saved as it advances so that it can be
+// resumed after a restart.
+type SyncProgress struct {
+	Stage SyncStage `json:"stage"`
+	// Pivot is the number of the block whose world state is downloaded.
+	Pivot uint64 `json:"pivot"`
+	// Headers is the number of the last verified header.
+	Headers uint64 `json:"headers"`
+	// Blocks is the number of the last executed block.
+	Blocks uint64 `json:"blocks"`
+	// Target is the chain depth of the best peer.
+	Target uint64 `json:"target"`
+}
+
+// Encode encodes to binary.
+func (p SyncProgress) Encode() ([]byte, error) {
+	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
+	defer figbuf.EncoderPool.Put(enc)
+
+	return enc.EncodeList(func(buf []byte) []byte {
+		buf = enc.EncodeNextUint8(buf, uint8(p.Stage))
+		buf = enc.EncodeNextUint64(buf, p.Pivot)
+		buf = enc.EncodeNextUint64(buf, p.Headers)
+		buf = enc.EncodeNextUint64(buf, p.Blocks)
+		buf = enc.EncodeNextUint64(buf, p.Target)
+		return buf
+	})
+}
+
+// Decode decodes from binary.
+func (p *SyncProgress) Decode(buf []byte) error {
+	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
+	defer figbuf.DecoderPool.Put(dec)
+
+	return dec.DecodeList(buf, func(r []byte) []byte {
+		var stage uint8
+		stage, r = dec.DecodeNextUint8(r)
+		p.Stage = SyncStage(stage)
+		p.Pivot, r = dec.DecodeNextUint64(r)
+		p.Headers, r = dec.DecodeNextUint64(r)
+		p.Blocks, r = dec.DecodeNextUint64(r)
+		p.Target, r = dec.DecodeNextUint64(r)
+		return r
+	})
+}
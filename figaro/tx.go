@@ -24,15 +24,17 @@ const MaxTxDataSize = 4096
 // cryptographic signature over the Transaction hash by the sender, and a nonce value which
 // must match the account nonce of the sender at the time it is mined into a block.
 type Transaction struct {
-	ID          []byte
-	Signature   []byte
-	From        Address
-	To          Address
-	Nonce       uint64
-	Type        TxType
-	CommitBlock uint64
-	Value       uint64
-	Data        []byte
+	ID          []byte  `json:"id"`
+	Signature   []byte  `json:"signature"`
+	From        Address `json:"from"`
+	To          Address `json:"to"`
+	Nonce       uint64  `json:"nonce"`
+	Type        TxType  `json:"type"`
+	CommitBlock uint64  `json:"commitBlock"`
+	Value       uint64  `json:"value"`
+	GasLimit    uint64  `json:"gasLimit"`
+	GasPrice    uint64  `json:"gasPrice"`
+	Data        []byte  `json:"data"`
 }
 
 // ToHash hashes the Tx fields other than Signature, creating a unique ID.
@@ -40,7 +42,7 @@ func (tx Transaction) ToHash() (TxHash, error) {
 	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
 	defer figbuf.EncoderPool.Put(enc)
 
-	e, err := enc.Encode(tx.Nonce, tx.CommitBlock, tx.From, tx.To, tx.Type, tx.Value, tx.Data)
+	e, err := enc.Encode(tx.Nonce, tx.CommitBlock, tx.From, tx.To, tx.Type, tx.Value, tx.GasLimit, tx.GasPrice, tx.Data)
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +61,16 @@ func (tx Transaction) VerifySignature() bool {
 	return fastsig.Verify(tx.From, tx.Signature, tx.ID)
 }
 
+// ValidData returns whether the tx Data is within limits for its type. The Data of a DeployTx
+// must be valid DeployData.
+func (tx Transaction) ValidData() bool {
+	if tx.Type == DeployTx {
+		_, err := decodeDeployData(&tx)
+		return err == nil
+	}
+	return len(tx.Data) <= MaxTxDataSize
+}
+
 // Encode deterministically encodes a transaction to binary format.
 func (tx Transaction) Encode() ([]byte, error) {
 	enc := figbuf.EncoderPool.Get().(*figbuf.Encoder)
@@ -72,17 +84,20 @@ func (tx Transaction) Encode() ([]byte, error) {
 		buf = enc.EncodeNextBytes(buf, tx.To)
 		buf = enc.EncodeNextBinaryMarshaler(buf, tx.Type)
 		buf = enc.EncodeNextUint64(buf, tx.Value)
+		buf = enc.EncodeNextUint64(buf, tx.GasLimit)
+		buf = enc.EncodeNextUint64(buf, tx.GasPrice)
 		buf = enc.EncodeNextBytes(buf, tx.Data)
 		return buf
 	})
 }
 
 // Decode decodes a deterministically encoded transaction from binary format.
+// The ID is not encoded, and is derived from the decoded fields.
 func (tx *Transaction) Decode(buf []byte) error {
 	dec := figbuf.DecoderPool.Get().(*figbuf.Decoder)
 	defer figbuf.DecoderPool.Put(dec)
 
-	return dec.DecodeList(buf, func(r []byte) []byte {
+	err := dec.DecodeList(buf, func(r []byte) []byte {
 		tx.Signature, r = dec.DecodeNextBytes(r)
 		tx.Nonce, r = dec.DecodeNextUint64(r)
 		tx.CommitBlock, r = dec.DecodeNextUint64(r)
@@ -90,9 +105,16 @@ func (tx *Transaction) Decode(buf []byte) error {
 		tx.To, r = dec.DecodeNextBytes(r)
 		r = dec.DecodeNextBinaryUnmarshaler(r, &tx.Type)
 		tx.Value, r = dec.DecodeNextUint64(r)
+		tx.GasLimit, r = dec.DecodeNextUint64(r)
+		tx.GasPrice, r = dec.DecodeNextUint64(r)
 		tx.Data, r = dec.DecodeNextBytes(r)
 		return r
 	})
+	if err != nil {
+		return err
+	}
+	tx.ID, err = tx.ToHash()
+	return err
 }
 
 // TransactionLDataService implements only limited local data.
@@ -149,6 +171,33 @@ func (h *TxReceivedHeap) Pop() interface{} {
 	return x
 }
 
+// TxPriceHeap is a priority Heap of pending transactions, sorted by highest GasPrice, and then
+// by Received timestamp.
+type TxPriceHeap []*ReceivedTx
+
+func (h TxPriceHeap) Len() int { return len(h) }
+func (h TxPriceHeap) Less(i, j int) bool {
+	if h[i].GasPrice != h[j].GasPrice {
+		return h[i].GasPrice > h[j].GasPrice
+	}
+	return h[i].Received.Before(h[j].Received)
+}
+func (h TxPriceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// Push implements a heap.Interface. Use `heap.Push, etc`.
+func (h *TxPriceHeap) Push(x interface{}) {
+	*h = append(*h, x.(*ReceivedTx))
+}
+
+// Pop implements a heap.Interface. Use `heap.Pop, etc`.
+func (h *TxPriceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
 // TxNonceHeap is a is a priority Heap of transactions, sorted by Nonce.
 type TxNonceHeap []*ReceivedTx
 
@@ -1,4 +1,468 @@
 // Package figaro is the main package for go-figaro
 package figaro
 
-// TxPool handles the pool of incoming transactions to be processed
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTxPoolFull is returned when the pool has reached its configured capacity.
+	ErrTxPoolFull = errors.New("figaro txpool: pool is full")
+	// ErrKnownCommit is returned when a commit is already in the pool.
+	ErrKnownCommit = errors.New("figaro txpool: known commit")
+	// ErrKnownTx is returned when a transaction is already in the pool.
+	ErrKnownTx = errors.New("figaro txpool: known transaction")
+	// ErrNonceConflict is returned when a sender already has a pooled transaction with the same nonce.
+	ErrNonceConflict = errors.New("figaro txpool: nonce already pooled for sender")
+	// ErrKnownEvidence is returned when evidence of the same offence is already in the pool.
+	ErrKnownEvidence = errors.New("figaro txpool: known evidence")
+	// ErrUnderpriced is returned when a transaction pays less than the chain MinGasPrice.
+	ErrUnderpriced = errors.New("figaro txpool: gas price below minimum")
+)
+
+// DefaultTxPoolConfig is a sensible default configuration for a TxPool.
+var DefaultTxPoolConfig = TxPoolConfig{
+	MaxCommits:       4 * MaxCommitSize,
+	MaxTxs:           4 * MaxTxSize,
+	MaxTxsPerAccount: 64,
+	MaxEvidence:      4 * MaxEvidenceSize,
+	Lifetime:         3 * time.Hour,
+}
+
+// TxPoolConfig sets the memory limits of a TxPool.
+type TxPoolConfig struct {
+	MaxCommits       int
+	MaxTxs           int
+	MaxTxsPerAccount int
+	MaxEvidence      int
+	// Lifetime is how long an unmined commit or an unrevealed transaction
+	// is kept before it is evicted.
+	Lifetime time.Duration
+}
+
+// TxPool handles the pool of incoming transactions to be processed. It holds a commit
+// pool of commits waiting to be mined into a block, and a reveal pool of transactions
+// waiting for their commit to mature, queued per sender by nonce. It also holds evidence
+// of producer offences waiting to be included in a block.
+type TxPool struct {
+	mu  sync.RWMutex
+	cfg TxPoolConfig
+
+	commits   *CommitHeap
+	commitset map[string]*ReceivedCommit
+	// mined maps the txid of a commit to the number of the block it was mined into.
+	mined map[string]uint64
+
+	queues map[string]*TxNonceHeap
+	txset  map[string]*ReceivedTx
+
+	evidence    []*Evidence
+	evidenceset map[string]bool
+}
+
+// NewTxPool returns a TxPool, ready to use.
+func NewTxPool(cfg TxPoolConfig) *TxPool {
+	return &TxPool{
+		cfg:       cfg,
+		commits:   NewCommitHeap(),
+		commitset: make(map[string]*ReceivedCommit),
+		mined:     make(map[string]uint64),
+		queues:    make(map[string]*TxNonceHeap),
+		txset:     make(map[string]*ReceivedTx),
+
+		evidenceset: make(map[string]bool),
+	}
+}
+
+// AddCommit adds a commit to the commit pool.
+func (p *TxPool) AddCommit(rc *ReceivedCommit) error {
+	if !TxHash(rc.Commit).Valid() {
+		return ErrInvalidTxHashData
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := string(rc.Commit)
+	if _, ok := p.commitset[key]; ok {
+		return ErrKnownCommit
+	}
+	if _, ok := p.mined[key]; ok {
+		return ErrKnownCommit
+	}
+	if p.commits.Len() >= p.cfg.MaxCommits {
+		return ErrTxPoolFull
+	}
+	heap.Push(p.commits, rc)
+	p.commitset[key] = rc
+	return nil
+}
+
+// AddTx adds a transaction to the reveal pool. The transaction ID must already be set,
+// and should be verified against the signature by the caller.
+func (p *TxPool) AddTx(rtx *ReceivedTx) error {
+	if !TxHash(rtx.ID).Valid() || !rtx.From.Valid() {
+		return ErrInvalidTransaction
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addTx(rtx)
+}
+
+func (p *TxPool) addTx(rtx *ReceivedTx) error {
+	key := string(rtx.ID)
+	if _, ok := p.txset[key]; ok {
+		return ErrKnownTx
+	}
+	if len(p.txset) >= p.cfg.MaxTxs {
+		return ErrTxPoolFull
+	}
+	q, ok := p.queues[string(rtx.From)]
+	if !ok {
+		q = &TxNonceHeap{}
+		heap.Init(q)
+		p.queues[string(rtx.From)] = q
+	}
+	if q.Len() >= p.cfg.MaxTxsPerAccount {
+		return ErrTxPoolFull
+	}
+	for _, qtx := range *q {
+		if qtx.Nonce == rtx.Nonce {
+			return ErrNonceConflict
+		}
+	}
+	heap.Push(q, rtx)
+	p.txset[key] = rtx
+	return nil
+}
+
+// AddEvidence adds evidence of a producer offence to the pool. The evidence should be
+// verified by the caller.
+func (p *TxPool) AddEvidence(ev *Evidence) error {
+	key := ev.OffenceKey()
+	if key == nil {
+		return ErrInvalidEvidence
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.evidenceset[string(key)] {
+		return ErrKnownEvidence
+	}
+	if len(p.evidence) >= p.cfg.MaxEvidence {
+		return ErrTxPoolFull
+	}
+	p.evidence = append(p.evidence, ev)
+	p.evidenceset[string(key)] = true
+	return nil
+}
+
+// RemoveEvidence drops evidence of the same offence as ev from the pool, if present.
+func (p *TxPool) RemoveEvidence(ev *Evidence) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeEvidence(map[string]bool{string(ev.OffenceKey()): true})
+}
+
+// removeEvidence drops the evidence of the offences in keys.
+func (p *TxPool) removeEvidence(keys map[string]bool) {
+	kept := p.evidence[:0]
+	for _, ev := range p.evidence {
+		key := string(ev.OffenceKey())
+		if keys[key] {
+			delete(p.evidenceset, key)
+		} else {
+			kept = append(kept, ev)
+		}
+	}
+	p.evidence = kept
+}
+
+// PendingEvidence returns up to max evidence waiting to be included in a block, oldest first.
+func (p *TxPool) PendingEvidence(max int) []*Evidence {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if max > len(p.evidence) {
+		max = len(p.evidence)
+	}
+	evidence := make([]*Evidence, max)
+	copy(evidence, p.evidence)
+	return evidence
+}
+
+// HasCommit returns whether a commit for the txhash is pooled, either pending or mined.
+func (p *TxPool) HasCommit(txhash TxHash) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if _, ok := p.commitset[string(txhash)]; ok {
+		return true
+	}
+	_, ok := p.mined[string(txhash)]
+	return ok
+}
+
+// HasTx returns whether the transaction is in the reveal pool.
+func (p *TxPool) HasTx(txhash TxHash) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.txset[string(txhash)]
+	return ok
+}
+
+// GetTx returns the transaction for txhash from the reveal pool, or nil if it is not pooled.
+func (p *TxPool) GetTx(txhash TxHash) *Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	rtx, ok := p.txset[string(txhash)]
+	if !ok {
+		return nil
+	}
+	tx := rtx.Transaction
+	return &tx
+}
+
+// RemoveTx drops a transaction from the reveal pool, if present.
+func (p *TxPool) RemoveTx(txhash TxHash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeTx(txhash)
+}
+
+// Len returns the number of commits waiting to be mined and the number of pooled transactions.
+func (p *TxPool) Len() (commits int, txs int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.commits.Len(), len(p.txset)
+}
+
+// PendingCommits returns up to max commits waiting to be mined, oldest first.
+func (p *TxPool) PendingCommits(max int) []Commit {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	h := make(CommitHeap, p.commits.Len())
+	copy(h, *p.commits)
+	if max > h.Len() {
+		max = h.Len()
+	}
+	commits := make([]Commit, 0, max)
+	for len(commits) < max {
+		commits = append(commits, heap.Pop(&h).(*ReceivedCommit).Commit)
+	}
+	return commits
+}
+
+// Pending returns up to max transactions that can be processed in the given block, highest
+// GasPrice first, and then oldest first.
+// A transaction is pending if its nonce follows the sender account nonce at the block StateRoot,
+// without gaps, and its commit was mined `WaitBlocks` to `2*WaitBlocks+1` blocks before the block.
+func (p *TxPool) Pending(db AccountLDataService, next *BlockHeader, max int) ([]*Transaction, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	runs, _, err := p.split(db, next)
+	if err != nil {
+		return nil, err
+	}
+	// Merge the per-sender runs by gas price, preserving nonce order within each sender
+	h := &TxPriceHeap{}
+	for _, run := range runs {
+		heap.Push(h, run[0])
+	}
+	txs := make([]*Transaction, 0)
+	for h.Len() > 0 && len(txs) < max {
+		rtx := heap.Pop(h).(*ReceivedTx)
+		txs = append(txs, &rtx.Transaction)
+		run := runs[string(rtx.From)][1:]
+		runs[string(rtx.From)] = run
+		if len(run) > 0 {
+			heap.Push(h, run[0])
+		}
+	}
+	return txs, nil
+}
+
+// Queued returns the pooled transactions that cannot be processed in the given block,
+// either because of a nonce gap or because their commit has not matured.
+func (p *TxPool) Queued(db AccountLDataService, next *BlockHeader) ([]*Transaction, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, queued, err := p.split(db, next)
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]*Transaction, len(queued))
+	for i, rtx := range queued {
+		txs[i] = &rtx.Transaction
+	}
+	return txs, nil
+}
+
+// split sorts the reveal pool into per-sender runs of pending transactions and a list of queued transactions.
+func (p *TxPool) split(db AccountLDataService, next *BlockHeader) (map[string][]*ReceivedTx, []*ReceivedTx, error) {
+	runs := make(map[string][]*ReceivedTx)
+	queued := make([]*ReceivedTx, 0)
+	for sender, q := range p.queues {
+		acc, err := db.FetchAccount(next.StateRoot, Address(sender))
+		if err != nil {
+			return nil, nil, err
+		}
+		sorted := make(TxNonceHeap, q.Len())
+		copy(sorted, *q)
+		nonce := acc.Nonce
+		for sorted.Len() > 0 {
+			rtx := heap.Pop(&sorted).(*ReceivedTx)
+			if rtx.Ordinal(acc.Nonce) == -1 {
+				// Stale, and will be evicted on the next Update
+				continue
+			}
+			if rtx.Nonce == nonce && p.revealable(rtx, next) {
+				runs[sender] = append(runs[sender], rtx)
+				nonce++
+				continue
+			}
+			// Anything after a gap must wait, too
+			queued = append(queued, rtx)
+			queued = append(queued, sorted...)
+			break
+		}
+	}
+	return runs, queued, nil
+}
+
+// revealable returns whether the commit for the tx is within the reveal window for the block.
+func (p *TxPool) revealable(rtx *ReceivedTx, next *BlockHeader) bool {
+	n, ok := p.mined[string(rtx.ID)]
+	if !ok || n != rtx.CommitBlock || next.Number < n {
+		return false
+	}
+	diffN := next.Number - n
+	return diffN >= uint64(next.WaitBlocks) && diffN <= 2*uint64(next.WaitBlocks)+1
+}
+
+// Update updates the pool after a block has been added to the chain. It moves commits
+// mined in the block out of the commit pool, drops transactions and evidence included in the block,
+// evicts stale nonces of senders in the block, expires commits that have passed the reveal
+// window along with their transactions, and evicts anything older than the pool Lifetime.
+func (p *TxPool) Update(db AccountLDataService, bl *Block) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range bl.Commits {
+		p.mined[string(c)] = bl.Number
+		delete(p.commitset, string(c))
+	}
+	senders := make(map[string]bool)
+	for _, tx := range bl.Transactions {
+		senders[string(tx.From)] = true
+		p.removeTx(tx.ID)
+	}
+	for sender := range senders {
+		acc, err := db.FetchAccount(bl.StateRoot, Address(sender))
+		if err != nil {
+			return err
+		}
+		p.evictStale(sender, acc.Nonce)
+	}
+	for txid, n := range p.mined {
+		if bl.Number > n+2*uint64(bl.WaitBlocks)+1 {
+			delete(p.mined, txid)
+			p.removeTx([]byte(txid))
+		}
+	}
+	if len(bl.Evidence) > 0 {
+		included := make(map[string]bool)
+		for _, ev := range bl.Evidence {
+			included[string(ev.OffenceKey())] = true
+		}
+		p.removeEvidence(included)
+	}
+	cutoff := time.Now().Add(-p.cfg.Lifetime)
+	for key, rtx := range p.txset {
+		if _, ok := p.mined[key]; !ok && rtx.Received.Before(cutoff) {
+			p.removeTx(rtx.ID)
+		}
+	}
+	p.filterCommits(func(rc *ReceivedCommit) bool {
+		_, ok := p.commitset[string(rc.Commit)]
+		return ok && !rc.Received.Before(cutoff)
+	})
+	return nil
+}
+
+// Reinject returns the commits and transactions of a block orphaned by a chain reorg to the pools.
+// Orphaned blocks should be reinjected from the highest to the lowest before the new branch is
+// applied with Update, so that anything the new branch includes again is removed once more.
+func (p *TxPool) Reinject(bl *Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range bl.Commits {
+		if n, ok := p.mined[string(c)]; !ok || n != bl.Number {
+			continue
+		}
+		delete(p.mined, string(c))
+		if p.commits.Len() < p.cfg.MaxCommits {
+			rc := &ReceivedCommit{Commit: c, Received: now}
+			heap.Push(p.commits, rc)
+			p.commitset[string(c)] = rc
+		}
+	}
+	for _, tx := range bl.Transactions {
+		// Errors mean the tx is already pooled or the pool is full, either way it is dropped
+		_ = p.addTx(&ReceivedTx{Transaction: *tx, Received: now})
+	}
+}
+
+// evictStale drops every pooled transaction of sender with a nonce below the account nonce.
+func (p *TxPool) evictStale(sender string, accnonce uint64) {
+	q, ok := p.queues[sender]
+	if !ok {
+		return
+	}
+	for q.Len() > 0 && (*q)[0].Ordinal(accnonce) == -1 {
+		rtx := heap.Pop(q).(*ReceivedTx)
+		delete(p.txset, string(rtx.ID))
+	}
+	if q.Len() == 0 {
+		delete(p.queues, sender)
+	}
+}
+
+// removeTx drops a transaction from the reveal pool, if present.
+func (p *TxPool) removeTx(txid TxHash) {
+	rtx, ok := p.txset[string(txid)]
+	if !ok {
+		return
+	}
+	delete(p.txset, string(txid))
+	q := p.queues[string(rtx.From)]
+	for i, qtx := range *q {
+		if qtx == rtx {
+			heap.Remove(q, i)
+			break
+		}
+	}
+	if q.Len() == 0 {
+		delete(p.queues, string(rtx.From))
+	}
+}
+
+// filterCommits keeps only the pending commits for which keep returns true.
+func (p *TxPool) filterCommits(keep func(rc *ReceivedCommit) bool) {
+	kept := (*p.commits)[:0]
+	for _, rc := range *p.commits {
+		if keep(rc) {
+			kept = append(kept, rc)
+		} else {
+			delete(p.commitset, string(rc.Commit))
+		}
+	}
+	*p.commits = kept
+	heap.Init(p.commits)
+}
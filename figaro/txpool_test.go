@@ -0,0 +1,286 @@
+package figaro
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var testTxPoolConfig = TxPoolConfig{
+	MaxCommits:       16,
+	MaxTxs:           16,
+	MaxTxsPerAccount: 16,
+	MaxEvidence:      16,
+	Lifetime:         time.Hour,
+}
+
+// poolAddress returns a valid address filled with b.
+func poolAddress(b byte) Address {
+	return bytes.Repeat([]byte{b}, AddressSize)
+}
+
+// newPoolTx returns a tx from the sender filled with from, with its ID set, received at received.
+func newPoolTx(t *testing.T, from byte, nonce, commitBlock, gasPrice uint64, received time.Time) *ReceivedTx {
+	tx := Transaction{
+		Type:        BalanceTx,
+		From:        poolAddress(from),
+		To:          poolAddress(0xff),
+		Nonce:       nonce,
+		CommitBlock: commitBlock,
+		GasLimit:    TxGas,
+		GasPrice:    gasPrice,
+	}
+	var err error
+	tx.ID, err = tx.ToHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ReceivedTx{Transaction: tx, Received: received}
+}
+
+// addPoolTx adds the commit for rtx and rtx itself to the pool.
+func addPoolTx(t *testing.T, pool *TxPool, rtx *ReceivedTx) {
+	err := pool.AddCommit(&ReceivedCommit{Commit: Commit(rtx.ID), Received: rtx.Received})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pool.AddTx(rtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// updatePool updates the pool with a block at number, with the given WaitBlocks, commits and txs.
+func updatePool(t *testing.T, pool *TxPool, db memState, number uint64, wait uint8, commits []Commit, txs []*Transaction) *Block {
+	bl := &Block{
+		BlockHeader:  &BlockHeader{Number: number, ChainConfig: ChainConfig{WaitBlocks: wait}},
+		Commits:      commits,
+		Transactions: txs,
+	}
+	err := pool.Update(db, bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bl
+}
+
+// txIDs returns the IDs of txs, in order.
+func txIDs(txs []*Transaction) []string {
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[i] = string(tx.ID)
+	}
+	return ids
+}
+
+func sameIDs(got []*Transaction, want ...*ReceivedTx) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i, id := range txIDs(got) {
+		if id != string(want[i].ID) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTxPoolRevealWindow(t *testing.T) {
+	const mined, wait = 10, 2
+	tests := []struct {
+		number  uint64
+		pending bool
+	}{
+		{mined + wait - 1, false},
+		{mined + wait, true},
+		{mined + 2*wait + 1, true},
+		{mined + 2*wait + 2, false},
+	}
+	for _, tt := range tests {
+		db := memState{}
+		pool := NewTxPool(testTxPoolConfig)
+		rtx := newPoolTx(t, 1, 0, mined, 1, time.Now())
+		addPoolTx(t, pool, rtx)
+		updatePool(t, pool, db, mined, wait, []Commit{Commit(rtx.ID)}, nil)
+
+		next := &BlockHeader{Number: tt.number, ChainConfig: ChainConfig{WaitBlocks: wait}}
+		pending, err := pool.Pending(db, next, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued, err := pool.Queued(db, next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.pending && (!sameIDs(pending, rtx) || len(queued) != 0) {
+			t.Errorf("block %d: %d pending, %d queued, want the tx pending", tt.number, len(pending), len(queued))
+		}
+		if !tt.pending && (len(pending) != 0 || !sameIDs(queued, rtx)) {
+			t.Errorf("block %d: %d pending, %d queued, want the tx queued", tt.number, len(pending), len(queued))
+		}
+	}
+}
+
+func TestTxPoolNonceGap(t *testing.T) {
+	db := memState{string(poolAddress(1)): {Address: poolAddress(1), Nonce: 3}}
+	pool := NewTxPool(testTxPoolConfig)
+	now := time.Now()
+	tx3 := newPoolTx(t, 1, 3, 1, 1, now)
+	tx5 := newPoolTx(t, 1, 5, 1, 1, now)
+	tx6 := newPoolTx(t, 1, 6, 1, 1, now)
+	for _, rtx := range []*ReceivedTx{tx6, tx3, tx5} {
+		addPoolTx(t, pool, rtx)
+	}
+	updatePool(t, pool, db, 1, 0, []Commit{Commit(tx3.ID), Commit(tx5.ID), Commit(tx6.ID)}, nil)
+
+	next := &BlockHeader{Number: 1}
+	pending, err := pool.Pending(db, next, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIDs(pending, tx3) {
+		t.Errorf("pending %d txs, want only nonce 3", len(pending))
+	}
+	queued, err := pool.Queued(db, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIDs(queued, tx5, tx6) {
+		t.Errorf("queued %d txs, want nonces 5 and 6", len(queued))
+	}
+}
+
+func TestTxPoolUpdateEvictsStaleNonces(t *testing.T) {
+	sender := poolAddress(1)
+	db := memState{}
+	pool := NewTxPool(testTxPoolConfig)
+	now := time.Now()
+	var txs []*ReceivedTx
+	for nonce := uint64(0); nonce < 4; nonce++ {
+		rtx := newPoolTx(t, 1, nonce, 1, 1, now)
+		addPoolTx(t, pool, rtx)
+		txs = append(txs, rtx)
+	}
+	// The block includes nonce 1 only, but the sender nonce moves past nonce 2, too
+	db[string(sender)] = &Account{Address: sender, Nonce: 3}
+	updatePool(t, pool, db, 1, 0, nil, []*Transaction{&txs[1].Transaction})
+
+	for nonce, rtx := range txs {
+		if got, want := pool.HasTx(rtx.ID), nonce == 3; got != want {
+			t.Errorf("nonce %d pooled = %v, want %v", nonce, got, want)
+		}
+	}
+	if _, n := pool.Len(); n != 1 {
+		t.Errorf("Len() = %d txs, want 1", n)
+	}
+}
+
+func TestTxPoolCommitExpiry(t *testing.T) {
+	const mined, wait = 10, 2
+	db := memState{}
+	pool := NewTxPool(testTxPoolConfig)
+	rtx := newPoolTx(t, 1, 0, mined, 1, time.Now())
+	addPoolTx(t, pool, rtx)
+	updatePool(t, pool, db, mined, wait, []Commit{Commit(rtx.ID)}, nil)
+	if commits, _ := pool.Len(); commits != 0 || !pool.HasCommit(rtx.ID) {
+		t.Fatal("mined commit was not moved out of the commit pool")
+	}
+
+	// The last block of the reveal window keeps the commit and its tx
+	updatePool(t, pool, db, mined+2*wait+1, wait, nil, nil)
+	if !pool.HasCommit(rtx.ID) || !pool.HasTx(rtx.ID) {
+		t.Fatal("commit expired within the reveal window")
+	}
+	updatePool(t, pool, db, mined+2*wait+2, wait, nil, nil)
+	if pool.HasCommit(rtx.ID) || pool.HasTx(rtx.ID) {
+		t.Error("commit and tx were kept after the reveal window")
+	}
+}
+
+func TestTxPoolMaxTxs(t *testing.T) {
+	cfg := testTxPoolConfig
+	cfg.MaxTxs = 2
+	pool := NewTxPool(cfg)
+	now := time.Now()
+	for i := byte(1); i <= 2; i++ {
+		err := pool.AddTx(newPoolTx(t, i, 0, 1, 1, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := pool.AddTx(newPoolTx(t, 3, 0, 1, 1, now))
+	if err != ErrTxPoolFull {
+		t.Errorf("AddTx over MaxTxs = %v, want %v", err, ErrTxPoolFull)
+	}
+	if _, n := pool.Len(); n != 2 {
+		t.Errorf("Len() = %d txs, want 2", n)
+	}
+}
+
+func TestTxPoolReinject(t *testing.T) {
+	db := memState{}
+	pool := NewTxPool(testTxPoolConfig)
+	now := time.Now()
+	committed := newPoolTx(t, 1, 0, 5, 1, now)
+	revealed := newPoolTx(t, 2, 0, 1, 1, now)
+	addPoolTx(t, pool, committed)
+	addPoolTx(t, pool, revealed)
+	updatePool(t, pool, db, 1, 2, []Commit{Commit(revealed.ID)}, nil)
+
+	orphan := updatePool(t, pool, db, 5, 2, []Commit{Commit(committed.ID)}, []*Transaction{&revealed.Transaction})
+	if pool.HasTx(revealed.ID) {
+		t.Fatal("included tx was not removed from the pool")
+	}
+	if commits := pool.PendingCommits(10); len(commits) != 0 {
+		t.Fatalf("%d pending commits, want 0", len(commits))
+	}
+
+	pool.Reinject(orphan)
+	if !pool.HasTx(revealed.ID) {
+		t.Error("tx of the orphaned block was not reinjected")
+	}
+	commits := pool.PendingCommits(10)
+	if len(commits) != 1 || !bytes.Equal(commits[0], committed.ID) {
+		t.Errorf("pending commits = %v, want the commit of the orphaned block", commits)
+	}
+	// The commit mined in the surviving block stays mined
+	pending, err := pool.Pending(db, &BlockHeader{Number: 5, ChainConfig: ChainConfig{WaitBlocks: 2}}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIDs(pending, revealed) {
+		t.Errorf("pending %d txs, want the reinjected tx", len(pending))
+	}
+}
+
+func TestTxPoolPendingOrder(t *testing.T) {
+	db := memState{}
+	pool := NewTxPool(testTxPoolConfig)
+	now := time.Now()
+	// Alice pays the least for nonce 0, which must still come before her best paid nonce 1
+	alice0 := newPoolTx(t, 1, 0, 1, 1, now)
+	alice1 := newPoolTx(t, 1, 1, 1, 10, now)
+	bob0 := newPoolTx(t, 2, 0, 1, 5, now)
+	carol0 := newPoolTx(t, 3, 0, 1, 5, now.Add(time.Second))
+	var commits []Commit
+	for _, rtx := range []*ReceivedTx{alice1, carol0, alice0, bob0} {
+		addPoolTx(t, pool, rtx)
+		commits = append(commits, Commit(rtx.ID))
+	}
+	updatePool(t, pool, db, 1, 0, commits, nil)
+
+	pending, err := pool.Pending(db, &BlockHeader{Number: 1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIDs(pending, bob0, carol0, alice0, alice1) {
+		t.Errorf("pending order is wrong: got %d txs %x", len(pending), txIDs(pending))
+	}
+	pending, err = pool.Pending(db, &BlockHeader{Number: 1}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIDs(pending, bob0, carol0) {
+		t.Errorf("Pending(max 2) returned %d txs, want the 2 best paid", len(pending))
+	}
+}
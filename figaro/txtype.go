@@ -18,6 +18,15 @@ const (
 	BalanceTx TxType = iota
 	// StakeTx transactions transfer FIG Stake from one account to another.
 	StakeTx
+	// DeployTx transactions create a contract account from DeployData, transferring
+	// Fia Balance to it. The contract address must be ContractAddress(From, Nonce).
+	DeployTx
+	// BondTx transactions bond the FIG Stake of the sender, making it eligible to produce blocks
+	// under proof-of-stake. To must be the sender, and Value must be 0.
+	BondTx
+	// UnbondTx transactions unbond the FIG Stake of the sender. To must be the sender, and Value
+	// must be 0.
+	UnbondTx
 )
 
 // ValidTxType is returns whether a TxType is a valid TxType
@@ -27,6 +36,12 @@ func ValidTxType(t TxType) bool {
 		return true
 	case StakeTx:
 		return true
+	case DeployTx:
+		return true
+	case BondTx:
+		return true
+	case UnbondTx:
+		return true
 	default:
 		return false
 	}
@@ -39,7 +54,7 @@ func (tx TxType) MarshalBinary() ([]byte, error) {
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler
 func (tx *TxType) UnmarshalBinary(b []byte) error {
-	if len(b) > 1 {
+	if len(b) != 1 {
 		return ErrInvalidTxTypeData
 	}
 	t := TxType(b[0])
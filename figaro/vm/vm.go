@@ -0,0 +1,469 @@
+// Package vm implements a sandboxed, deterministic stack machine for contract code.
+//
+// Contract code is a sequence of single byte opcodes. The stack holds byte strings of
+// up to MaxWordSize bytes. Arithmetic treats words as big-endian unsigned integers of up
+// to 8 bytes, and pushes 8 byte results. Every operation costs steps, and execution
+// faults once the step limit is reached. Storage writes are journaled, and only handed
+// back to the caller if execution succeeds, so a failed execution has no effect.
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// Execution limits. These are protocol values, and changing them changes consensus.
+const (
+	// MaxSteps is the max number of steps a single execution may use.
+	MaxSteps = 100000
+	// MaxWordSize is the max size, in bytes, of a stack word, and so of storage keys,
+	// storage values and return data.
+	MaxWordSize = 32
+	// MaxStackSize is the max number of words on the stack.
+	MaxStackSize = 1024
+)
+
+// Execution faults. A fault fails the execution, but is not an error of the caller.
+var (
+	// ErrOutOfSteps is a self-explantory error.
+	ErrOutOfSteps = errors.New("figaro vm: out of steps")
+	// ErrInvalidOpcode is a self-explantory error.
+	ErrInvalidOpcode = errors.New("figaro vm: invalid opcode")
+	// ErrStackUnderflow is a self-explantory error.
+	ErrStackUnderflow = errors.New("figaro vm: stack underflow")
+	// ErrStackOverflow is a self-explantory error.
+	ErrStackOverflow = errors.New("figaro vm: stack overflow")
+	// ErrInvalidOperand is returned when a word is too large for the operation.
+	ErrInvalidOperand = errors.New("figaro vm: invalid operand")
+	// ErrInvalidJump is a self-explantory error.
+	ErrInvalidJump = errors.New("figaro vm: invalid jump destination")
+	// ErrDivideByZero is a self-explantory error.
+	ErrDivideByZero = errors.New("figaro vm: divide by zero")
+	// ErrReverted is returned when code explicitly reverts.
+	ErrReverted = errors.New("figaro vm: reverted")
+)
+
+// Opcodes.
+const (
+	STOP   byte = 0x00 // halt successfully, with no return data
+	PUSH   byte = 0x01 // PUSH n <n bytes>: push the next n bytes
+	POP    byte = 0x02 // discard the top word
+	DUP    byte = 0x03 // DUP i: push a copy of the word i below the top
+	SWAP   byte = 0x04 // SWAP i: swap the top word with the word i+1 below the top
+	ADD    byte = 0x10
+	SUB    byte = 0x11
+	MUL    byte = 0x12
+	DIV    byte = 0x13
+	MOD    byte = 0x14
+	LT     byte = 0x15
+	GT     byte = 0x16
+	EQ     byte = 0x17 // byte-wise equality
+	ISZERO byte = 0x18
+	AND    byte = 0x19
+	OR     byte = 0x1a
+	NOT    byte = 0x1b // logical not
+	CONCAT byte = 0x1c // pop b, a, and push a||b
+	JUMP   byte = 0x20 // pop dest, and jump to it
+	JUMPI  byte = 0x21 // pop dest, cond, and jump to dest if cond is not zero
+	INPUT  byte = 0x30 // pop size, offset, and push that slice of the input
+	INSIZE byte = 0x31 // push the input size
+	CALLER byte = 0x32 // push the tx sender
+	VALUE  byte = 0x33 // push the tx value
+	SELF   byte = 0x34 // push the contract address
+	NUMBER byte = 0x35 // push the block number
+	SLOAD  byte = 0x40 // pop key, and push its storage value
+	SSTORE byte = 0x41 // pop value, key, and store the value at key
+	RETURN byte = 0x50 // pop data, and halt successfully, returning data
+	REVERT byte = 0x51 // pop data, and fail, returning data
+)
+
+// Step costs.
+const (
+	stepBase   = 1
+	stepLoad   = 20
+	stepStore  = 100
+	stepJumpTo = 2
+)
+
+// Context is the environment of an execution.
+type Context struct {
+	Caller  figaro.Address
+	Address figaro.Address
+	Value   uint64
+	Number  uint64
+	Input   []byte
+}
+
+// Storage is read-only access to the contract storage before execution.
+type Storage interface {
+	Load(key []byte) ([]byte, error)
+}
+
+// Write is a storage write made by a successful execution.
+type Write struct {
+	Key   []byte
+	Value []byte
+}
+
+// Result is the outcome of an execution.
+type Result struct {
+	Success    bool
+	ReturnData []byte
+	Steps      uint64
+	// Fault is the reason a failed execution failed.
+	Fault error
+	// Writes are the storage writes to apply, in order, if the execution succeeded.
+	Writes []Write
+}
+
+type machine struct {
+	code    []byte
+	ctx     *Context
+	storage Storage
+	limit   uint64
+
+	pc      int
+	steps   uint64
+	stack   [][]byte
+	journal map[string]int
+	writes  []Write
+
+	storageErr error
+}
+
+// Run executes code in ctx, with up to limit steps. Execution faults are returned in
+// the Result, and an error is only returned if storage cannot be read.
+func Run(code []byte, ctx *Context, storage Storage, limit uint64) (*Result, error) {
+	m := &machine{
+		code:    code,
+		ctx:     ctx,
+		storage: storage,
+		limit:   limit,
+		journal: make(map[string]int),
+	}
+	data, err := m.run()
+	res := &Result{ReturnData: data, Steps: m.steps}
+	if err == errStorage {
+		return nil, m.storageErr
+	}
+	if err != nil {
+		res.Fault = err
+		return res, nil
+	}
+	res.Success = true
+	res.Writes = m.writes
+	return res, nil
+}
+
+// errStorage marks a storage backend error, which is not an execution fault.
+var errStorage = errors.New("figaro vm: storage error")
+
+func (m *machine) run() ([]byte, error) {
+	for m.pc < len(m.code) {
+		op := m.code[m.pc]
+		m.pc++
+		err := m.step(stepBase)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case STOP:
+			return nil, nil
+		case PUSH:
+			var n int
+			n, err = m.immediate()
+			if err != nil {
+				return nil, err
+			}
+			if n > MaxWordSize || m.pc+n > len(m.code) {
+				return nil, ErrInvalidOperand
+			}
+			err = m.push(append([]byte{}, m.code[m.pc:m.pc+n]...))
+			if err != nil {
+				return nil, err
+			}
+			m.pc += n
+		case POP:
+			_, err = m.pop()
+		case DUP:
+			var i int
+			i, err = m.immediate()
+			if err != nil {
+				return nil, err
+			}
+			if i >= len(m.stack) {
+				return nil, ErrStackUnderflow
+			}
+			err = m.push(m.stack[len(m.stack)-1-i])
+		case SWAP:
+			var i int
+			i, err = m.immediate()
+			if err != nil {
+				return nil, err
+			}
+			top := len(m.stack) - 1
+			if i+1 > top {
+				return nil, ErrStackUnderflow
+			}
+			m.stack[top], m.stack[top-1-i] = m.stack[top-1-i], m.stack[top]
+		case ADD, SUB, MUL, DIV, MOD, LT, GT, AND, OR:
+			err = m.arith(op)
+		case EQ:
+			var a, b []byte
+			b, a, err = m.pop2()
+			if err == nil {
+				err = m.pushBool(bytes.Equal(a, b))
+			}
+		case ISZERO, NOT:
+			var a uint64
+			a, err = m.popUint()
+			if err == nil {
+				err = m.pushBool(a == 0)
+			}
+		case CONCAT:
+			var a, b []byte
+			b, a, err = m.pop2()
+			if err == nil {
+				if len(a)+len(b) > MaxWordSize {
+					return nil, ErrInvalidOperand
+				}
+				err = m.push(append(append([]byte{}, a...), b...))
+			}
+		case JUMP:
+			err = m.jump(true)
+		case JUMPI:
+			err = m.jump(false)
+		case INPUT:
+			var size, offset uint64
+			size, err = m.popUint()
+			if err == nil {
+				offset, err = m.popUint()
+			}
+			if err == nil {
+				if size > MaxWordSize || offset+size < offset || offset+size > uint64(len(m.ctx.Input)) {
+					return nil, ErrInvalidOperand
+				}
+				err = m.push(append([]byte{}, m.ctx.Input[offset:offset+size]...))
+			}
+		case INSIZE:
+			err = m.pushUint(uint64(len(m.ctx.Input)))
+		case CALLER:
+			err = m.push(m.ctx.Caller)
+		case VALUE:
+			err = m.pushUint(m.ctx.Value)
+		case SELF:
+			err = m.push(m.ctx.Address)
+		case NUMBER:
+			err = m.pushUint(m.ctx.Number)
+		case SLOAD:
+			err = m.sload()
+		case SSTORE:
+			err = m.sstore()
+		case RETURN, REVERT:
+			var data []byte
+			data, err = m.pop()
+			if err != nil {
+				return nil, err
+			}
+			if op == REVERT {
+				return data, ErrReverted
+			}
+			return data, nil
+		default:
+			return nil, ErrInvalidOpcode
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (m *machine) step(n uint64) error {
+	m.steps += n
+	if m.steps > m.limit {
+		m.steps = m.limit
+		return ErrOutOfSteps
+	}
+	return nil
+}
+
+// immediate reads the next code byte as an operand.
+func (m *machine) immediate() (int, error) {
+	if m.pc >= len(m.code) {
+		return 0, ErrInvalidOperand
+	}
+	n := int(m.code[m.pc])
+	m.pc++
+	return n, nil
+}
+
+func (m *machine) push(w []byte) error {
+	if len(m.stack) >= MaxStackSize {
+		return ErrStackOverflow
+	}
+	m.stack = append(m.stack, w)
+	return nil
+}
+
+func (m *machine) pushUint(n uint64) error {
+	w := make([]byte, 8)
+	binary.BigEndian.PutUint64(w, n)
+	return m.push(w)
+}
+
+func (m *machine) pushBool(b bool) error {
+	if b {
+		return m.pushUint(1)
+	}
+	return m.pushUint(0)
+}
+
+func (m *machine) pop() ([]byte, error) {
+	if len(m.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	w := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return w, nil
+}
+
+// pop2 pops the top word, then the word below it.
+func (m *machine) pop2() ([]byte, []byte, error) {
+	a, err := m.pop()
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := m.pop()
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+func (m *machine) popUint() (uint64, error) {
+	w, err := m.pop()
+	if err != nil {
+		return 0, err
+	}
+	if len(w) > 8 {
+		return 0, ErrInvalidOperand
+	}
+	var b [8]byte
+	copy(b[8-len(w):], w)
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func (m *machine) arith(op byte) error {
+	b, err := m.popUint()
+	if err != nil {
+		return err
+	}
+	a, err := m.popUint()
+	if err != nil {
+		return err
+	}
+	switch op {
+	case ADD:
+		return m.pushUint(a + b)
+	case SUB:
+		return m.pushUint(a - b)
+	case MUL:
+		return m.pushUint(a * b)
+	case DIV:
+		if b == 0 {
+			return ErrDivideByZero
+		}
+		return m.pushUint(a / b)
+	case MOD:
+		if b == 0 {
+			return ErrDivideByZero
+		}
+		return m.pushUint(a % b)
+	case LT:
+		return m.pushBool(a < b)
+	case GT:
+		return m.pushBool(a > b)
+	case AND:
+		return m.pushBool(a != 0 && b != 0)
+	default: // OR
+		return m.pushBool(a != 0 || b != 0)
+	}
+}
+
+func (m *machine) jump(always bool) error {
+	dest, err := m.popUint()
+	if err != nil {
+		return err
+	}
+	if !always {
+		cond, err := m.popUint()
+		if err != nil {
+			return err
+		}
+		if cond == 0 {
+			return nil
+		}
+	}
+	err = m.step(stepJumpTo)
+	if err != nil {
+		return err
+	}
+	if dest >= uint64(len(m.code)) {
+		return ErrInvalidJump
+	}
+	m.pc = int(dest)
+	return nil
+}
+
+func (m *machine) sload() error {
+	key, err := m.pop()
+	if err != nil {
+		return err
+	}
+	err = m.step(stepLoad)
+	if err != nil {
+		return err
+	}
+	if i, ok := m.journal[string(key)]; ok {
+		return m.pushValue(m.writes[i].Value)
+	}
+	value, err := m.storage.Load(key)
+	if err != nil {
+		m.storageErr = err
+		return errStorage
+	}
+	return m.pushValue(value)
+}
+
+// pushValue pushes a storage value, which must fit in a word.
+func (m *machine) pushValue(value []byte) error {
+	if len(value) > MaxWordSize {
+		return ErrInvalidOperand
+	}
+	return m.push(append([]byte{}, value...))
+}
+
+func (m *machine) sstore() error {
+	value, key, err := m.pop2()
+	if err != nil {
+		return err
+	}
+	err = m.step(stepStore)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return ErrInvalidOperand
+	}
+	if i, ok := m.journal[string(key)]; ok {
+		m.writes[i].Value = value
+		return nil
+	}
+	m.journal[string(key)] = len(m.writes)
+	m.writes = append(m.writes, Write{Key: key, Value: value})
+	return nil
+}
@@ -0,0 +1,279 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/figaro-tech/go-figaro/figaro"
+)
+
+// mapStorage is contract storage held in a map.
+type mapStorage map[string][]byte
+
+func (s mapStorage) Load(key []byte) ([]byte, error) {
+	return s[string(key)], nil
+}
+
+type failStorage struct{}
+
+var errLoad = errors.New("load failed")
+
+func (failStorage) Load(key []byte) ([]byte, error) {
+	return nil, errLoad
+}
+
+func word(n uint64) []byte {
+	w := make([]byte, 8)
+	binary.BigEndian.PutUint64(w, n)
+	return w
+}
+
+func pushUint(n uint64) []byte {
+	return append([]byte{PUSH, 8}, word(n)...)
+}
+
+func pushBytes(b ...byte) []byte {
+	return append([]byte{PUSH, byte(len(b))}, b...)
+}
+
+func program(parts ...[]byte) []byte {
+	var code []byte
+	for _, part := range parts {
+		code = append(code, part...)
+	}
+	return code
+}
+
+// jumpOver returns code that jumps over skip with op, given the code for any condition, and then
+// returns 0xbb. If the jump is not taken, skip runs instead.
+func jumpOver(cond []byte, op byte, skip []byte) []byte {
+	// cond, PUSH 8 <dest>, op, skip, PUSH 1 0xbb, RETURN
+	dest := uint64(len(cond) + 10 + 1 + len(skip))
+	return program(cond, pushUint(dest), []byte{op}, skip, pushBytes(0xbb), []byte{RETURN})
+}
+
+func TestRun(t *testing.T) {
+	ctx := &Context{
+		Caller:  figaro.Address("caller"),
+		Address: figaro.Address("contract"),
+		Value:   42,
+		Number:  7,
+		Input:   []byte{1, 2, 3, 4, 5},
+	}
+	bigWord := bytes.Repeat([]byte{0xff}, MaxWordSize)
+	overflow := program()
+	for i := 0; i <= MaxStackSize; i++ {
+		overflow = append(overflow, pushBytes(1)...)
+	}
+	tests := []struct {
+		name    string
+		code    []byte
+		storage mapStorage
+		limit   uint64
+		want    []byte
+		fault   error
+		steps   uint64
+		writes  []Write
+	}{
+		// Control
+		{name: "empty", code: nil},
+		{name: "stop", code: []byte{STOP, 0xff}, steps: 1},
+		{name: "invalid opcode", code: []byte{0xff}, fault: ErrInvalidOpcode},
+		{name: "return", code: program(pushBytes(1, 2, 3), []byte{RETURN}), want: []byte{1, 2, 3}, steps: 2},
+		{name: "revert", code: program(pushBytes(9), []byte{REVERT}), want: []byte{9}, fault: ErrReverted},
+
+		// Stack
+		{name: "push empty", code: program(pushBytes(), []byte{RETURN}), want: []byte{}},
+		{name: "push max word", code: program(pushBytes(bigWord...), []byte{RETURN}), want: bigWord},
+		{name: "push too large", code: program([]byte{PUSH, MaxWordSize + 1}, bigWord, []byte{0xff}), fault: ErrInvalidOperand},
+		{name: "push truncated", code: []byte{PUSH, 4, 1}, fault: ErrInvalidOperand},
+		{name: "push without size", code: []byte{PUSH}, fault: ErrInvalidOperand},
+		{name: "pop", code: program(pushUint(1), pushUint(2), []byte{POP, RETURN}), want: word(1)},
+		{name: "dup top", code: program(pushUint(1), pushUint(2), []byte{DUP, 0, POP, RETURN}), want: word(2)},
+		{name: "dup below", code: program(pushUint(1), pushUint(2), []byte{DUP, 1, RETURN}), want: word(1)},
+		{name: "swap", code: program(pushUint(1), pushUint(2), pushUint(3), []byte{SWAP, 1, RETURN}), want: word(1)},
+		{name: "swap keeps the rest", code: program(pushUint(1), pushUint(2), pushUint(3), []byte{SWAP, 1, POP, RETURN}), want: word(2)},
+		{name: "dup without operand", code: program(pushUint(1), []byte{DUP}), fault: ErrInvalidOperand},
+
+		// Stack underflow
+		{name: "pop empty", code: []byte{POP}, fault: ErrStackUnderflow},
+		{name: "dup empty", code: []byte{DUP, 0}, fault: ErrStackUnderflow},
+		{name: "dup past bottom", code: program(pushUint(1), []byte{DUP, 1}), fault: ErrStackUnderflow},
+		{name: "swap single", code: program(pushUint(1), []byte{SWAP, 0}), fault: ErrStackUnderflow},
+		{name: "swap past bottom", code: program(pushUint(1), pushUint(2), []byte{SWAP, 1}), fault: ErrStackUnderflow},
+		{name: "add single", code: program(pushUint(1), []byte{ADD}), fault: ErrStackUnderflow},
+		{name: "eq single", code: program(pushUint(1), []byte{EQ}), fault: ErrStackUnderflow},
+		{name: "iszero empty", code: []byte{ISZERO}, fault: ErrStackUnderflow},
+		{name: "concat single", code: program(pushUint(1), []byte{CONCAT}), fault: ErrStackUnderflow},
+		{name: "jump empty", code: []byte{JUMP}, fault: ErrStackUnderflow},
+		{name: "jumpi without cond", code: program(pushUint(0), []byte{JUMPI}), fault: ErrStackUnderflow},
+		{name: "input single", code: program(pushUint(1), []byte{INPUT}), fault: ErrStackUnderflow},
+		{name: "sload empty", code: []byte{SLOAD}, fault: ErrStackUnderflow},
+		{name: "sstore single", code: program(pushBytes(1), []byte{SSTORE}), fault: ErrStackUnderflow},
+		{name: "return empty", code: []byte{RETURN}, fault: ErrStackUnderflow},
+		{name: "revert empty", code: []byte{REVERT}, fault: ErrStackUnderflow},
+
+		// Stack overflow
+		{name: "stack overflow", code: overflow, fault: ErrStackOverflow},
+		{name: "stack full", code: program(overflow[:len(overflow)-3], []byte{RETURN}), want: []byte{1}},
+		{name: "dup overflow", code: program(overflow[:len(overflow)-3], []byte{DUP, 0}), fault: ErrStackOverflow},
+
+		// Arithmetic
+		{name: "add", code: program(pushUint(2), pushUint(3), []byte{ADD, RETURN}), want: word(5), steps: 4},
+		{name: "add wraps", code: program(pushUint(math.MaxUint64), pushUint(2), []byte{ADD, RETURN}), want: word(1)},
+		{name: "add short words", code: program(pushBytes(1), pushBytes(1, 0), []byte{ADD, RETURN}), want: word(257)},
+		{name: "add empty words", code: program(pushBytes(), pushBytes(), []byte{ADD, RETURN}), want: word(0)},
+		{name: "add large operand", code: program(pushBytes(1, 0, 0, 0, 0, 0, 0, 0, 0), pushUint(1), []byte{ADD}), fault: ErrInvalidOperand},
+		{name: "sub", code: program(pushUint(5), pushUint(3), []byte{SUB, RETURN}), want: word(2)},
+		{name: "sub wraps", code: program(pushUint(0), pushUint(1), []byte{SUB, RETURN}), want: word(math.MaxUint64)},
+		{name: "mul", code: program(pushUint(6), pushUint(7), []byte{MUL, RETURN}), want: word(42)},
+		{name: "div", code: program(pushUint(7), pushUint(2), []byte{DIV, RETURN}), want: word(3)},
+		{name: "div by zero", code: program(pushUint(7), pushUint(0), []byte{DIV}), fault: ErrDivideByZero},
+		{name: "mod", code: program(pushUint(7), pushUint(3), []byte{MOD, RETURN}), want: word(1)},
+		{name: "mod by zero", code: program(pushUint(7), pushUint(0), []byte{MOD}), fault: ErrDivideByZero},
+		{name: "lt true", code: program(pushUint(1), pushUint(2), []byte{LT, RETURN}), want: word(1)},
+		{name: "lt false", code: program(pushUint(2), pushUint(2), []byte{LT, RETURN}), want: word(0)},
+		{name: "gt true", code: program(pushUint(3), pushUint(2), []byte{GT, RETURN}), want: word(1)},
+		{name: "gt false", code: program(pushUint(1), pushUint(2), []byte{GT, RETURN}), want: word(0)},
+		{name: "and true", code: program(pushUint(1), pushUint(5), []byte{AND, RETURN}), want: word(1)},
+		{name: "and false", code: program(pushUint(1), pushUint(0), []byte{AND, RETURN}), want: word(0)},
+		{name: "or true", code: program(pushUint(0), pushUint(5), []byte{OR, RETURN}), want: word(1)},
+		{name: "or false", code: program(pushUint(0), pushUint(0), []byte{OR, RETURN}), want: word(0)},
+		{name: "iszero true", code: program(pushUint(0), []byte{ISZERO, RETURN}), want: word(1)},
+		{name: "iszero false", code: program(pushUint(3), []byte{ISZERO, RETURN}), want: word(0)},
+		{name: "not", code: program(pushUint(3), []byte{NOT, RETURN}), want: word(0)},
+		{name: "not large operand", code: program(pushBytes(bigWord...), []byte{NOT}), fault: ErrInvalidOperand},
+
+		// Bytes
+		{name: "eq true", code: program(pushBytes(1, 2), pushBytes(1, 2), []byte{EQ, RETURN}), want: word(1)},
+		{name: "eq bytewise", code: program(pushBytes(1), pushUint(1), []byte{EQ, RETURN}), want: word(0)},
+		{name: "eq large words", code: program(pushBytes(bigWord...), pushBytes(bigWord...), []byte{EQ, RETURN}), want: word(1)},
+		{name: "concat", code: program(pushBytes(1, 2), pushBytes(3), []byte{CONCAT, RETURN}), want: []byte{1, 2, 3}},
+		{name: "concat too large", code: program(pushBytes(bigWord...), pushBytes(1), []byte{CONCAT}), fault: ErrInvalidOperand},
+
+		// Jumps
+		{name: "jump", code: jumpOver(nil, JUMP, pushBytes(0xaa)), want: []byte{0xbb}, steps: 2 + stepJumpTo + 2},
+		{name: "jumpi taken", code: jumpOver(pushUint(1), JUMPI, program(pushBytes(0xaa), []byte{RETURN})), want: []byte{0xbb}},
+		{name: "jumpi not taken", code: jumpOver(pushUint(0), JUMPI, program(pushBytes(0xaa), []byte{RETURN})), want: []byte{0xaa}},
+		{name: "jumpi not taken to invalid dest", code: program(pushUint(0), pushUint(1000), []byte{JUMPI, STOP}), steps: 4},
+		{name: "jump to end", code: program(pushUint(11), []byte{JUMP}), fault: ErrInvalidJump},
+		{name: "jumpi past end", code: program(pushUint(1), pushUint(1000), []byte{JUMPI}), fault: ErrInvalidJump},
+		{name: "jump large operand", code: program(pushBytes(bigWord...), []byte{JUMP}), fault: ErrInvalidOperand},
+		{
+			// Counts down from 3, returning the number of iterations
+			name: "loop",
+			code: program(
+				pushUint(3),                                         // 0: counter
+				pushUint(0),                                         // 10: iterations
+				[]byte{DUP, 1, ISZERO}, pushUint(71), []byte{JUMPI}, // 20: exit if counter is zero
+				pushUint(1), []byte{ADD, SWAP, 0}, // 34: iterations++
+				pushUint(1), []byte{SUB, SWAP, 0}, // 47: counter--
+				pushUint(20), []byte{JUMP}, // 60
+				[]byte{RETURN}, // 71
+			),
+			want: word(3),
+		},
+
+		// Environment
+		{name: "caller", code: []byte{CALLER, RETURN}, want: []byte("caller")},
+		{name: "self", code: []byte{SELF, RETURN}, want: []byte("contract")},
+		{name: "value", code: []byte{VALUE, RETURN}, want: word(42)},
+		{name: "number", code: []byte{NUMBER, RETURN}, want: word(7)},
+		{name: "insize", code: []byte{INSIZE, RETURN}, want: word(5)},
+		{name: "input", code: program(pushUint(1), pushUint(3), []byte{INPUT, RETURN}), want: []byte{2, 3, 4}},
+		{name: "input empty slice", code: program(pushUint(5), pushUint(0), []byte{INPUT, RETURN}), want: []byte{}},
+		{name: "input past end", code: program(pushUint(3), pushUint(3), []byte{INPUT}), fault: ErrInvalidOperand},
+		{name: "input offset overflow", code: program(pushUint(math.MaxUint64), pushUint(2), []byte{INPUT}), fault: ErrInvalidOperand},
+
+		// Storage
+		{
+			name:    "sload",
+			code:    program(pushBytes('k'), []byte{SLOAD, RETURN}),
+			storage: mapStorage{"k": []byte("v")},
+			want:    []byte("v"),
+			steps:   1 + 1 + stepLoad + 1,
+		},
+		{name: "sload missing", code: program(pushBytes('k'), []byte{SLOAD, RETURN}), want: []byte{}},
+		{
+			name:    "sload too large",
+			code:    program(pushBytes('k'), []byte{SLOAD}),
+			storage: mapStorage{"k": bytes.Repeat([]byte{1}, MaxWordSize+1)},
+			fault:   ErrInvalidOperand,
+		},
+		{
+			name:   "sstore",
+			code:   program(pushBytes('k'), pushBytes('v'), []byte{SSTORE, STOP}),
+			steps:  1 + 1 + 1 + stepStore + 1,
+			writes: []Write{{Key: []byte("k"), Value: []byte("v")}},
+		},
+		{
+			name:    "sstore then sload",
+			code:    program(pushBytes('k'), pushBytes('w'), []byte{SSTORE}, pushBytes('k'), []byte{SLOAD, RETURN}),
+			storage: mapStorage{"k": []byte("v")},
+			want:    []byte("w"),
+			writes:  []Write{{Key: []byte("k"), Value: []byte("w")}},
+		},
+		{
+			name: "sstore overwrites in order",
+			code: program(
+				pushBytes('a'), pushBytes(1), []byte{SSTORE},
+				pushBytes('b'), pushBytes(2), []byte{SSTORE},
+				pushBytes('a'), pushBytes(3), []byte{SSTORE},
+			),
+			writes: []Write{{Key: []byte("a"), Value: []byte{3}}, {Key: []byte("b"), Value: []byte{2}}},
+		},
+		{name: "sstore empty key", code: program(pushBytes(), pushBytes('v'), []byte{SSTORE}), fault: ErrInvalidOperand},
+		{name: "revert discards writes", code: program(pushBytes('k'), pushBytes('v'), []byte{SSTORE}, pushBytes(), []byte{REVERT}), want: []byte{}, fault: ErrReverted},
+		{name: "fault discards writes", code: program(pushBytes('k'), pushBytes('v'), []byte{SSTORE, POP}), fault: ErrStackUnderflow},
+
+		// Steps
+		{name: "steps exact", code: program(pushUint(1), pushUint(2), []byte{ADD}), limit: 3, steps: 3},
+		{name: "out of steps", code: program(pushUint(1), pushUint(2), []byte{ADD}), limit: 2, fault: ErrOutOfSteps, steps: 2},
+		{name: "out of steps on jump", code: program(pushUint(0), []byte{JUMP}), limit: 3, fault: ErrOutOfSteps, steps: 3},
+		{name: "out of steps on sload", code: program(pushBytes('k'), []byte{SLOAD}), limit: stepLoad, fault: ErrOutOfSteps, steps: stepLoad},
+		{name: "out of steps on sstore", code: program(pushBytes('k'), pushBytes('v'), []byte{SSTORE}), limit: stepStore, fault: ErrOutOfSteps, steps: stepStore},
+		{name: "infinite loop", code: program(pushUint(0), []byte{JUMP}), fault: ErrOutOfSteps, steps: MaxSteps},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := tt.limit
+			if limit == 0 {
+				limit = MaxSteps
+			}
+			storage := tt.storage
+			if storage == nil {
+				storage = mapStorage{}
+			}
+			res, err := Run(tt.code, ctx, storage, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Fault != tt.fault {
+				t.Fatalf("fault = %v, want %v", res.Fault, tt.fault)
+			}
+			if res.Success != (tt.fault == nil) {
+				t.Errorf("success = %v, want %v", res.Success, tt.fault == nil)
+			}
+			if !bytes.Equal(res.ReturnData, tt.want) || (res.ReturnData == nil) != (tt.want == nil) {
+				t.Errorf("return data = %x, want %x", res.ReturnData, tt.want)
+			}
+			if tt.steps != 0 && res.Steps != tt.steps {
+				t.Errorf("steps = %d, want %d", res.Steps, tt.steps)
+			}
+			if !reflect.DeepEqual(res.Writes, tt.writes) {
+				t.Errorf("writes = %v, want %v", res.Writes, tt.writes)
+			}
+		})
+	}
+}
+
+func TestRunStorageError(t *testing.T) {
+	_, err := Run(program(pushBytes('k'), []byte{SLOAD}), &Context{}, failStorage{}, MaxSteps)
+	if err != errLoad {
+		t.Fatalf("err = %v, want %v", err, errLoad)
+	}
+}